@@ -212,6 +212,19 @@ func getCluster() (*cfg.Cluster, error) {
 	if clusterConf != nil {
 		return clusterConf, nil
 	}
+	// a cluster given in the environment, e.g. exported by the installer,
+	// takes precedence over the default cluster of the config file but not
+	// over one named with -c or FLYNN_CLUSTER
+	if url := os.Getenv("FLYNN_CONTROLLER_URL"); url != "" && flagCluster == "" {
+		clusterConf = &cfg.Cluster{
+			Name:    "env",
+			URL:     url,
+			Key:     os.Getenv("FLYNN_CONTROLLER_KEY"),
+			TLSPin:  os.Getenv("FLYNN_TLS_PIN"),
+			GitHost: os.Getenv("FLYNN_GIT_HOST"),
+		}
+		return clusterConf, nil
+	}
 	if err := readConfig(); err != nil {
 		return nil, err
 	}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/flynn/flynn/Godeps/_workspace/src/github.com/flynn/go-check"
+	cfg "github.com/flynn/flynn/cli/config"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&S{})
+
+type S struct{}
+
+func (S) TestGetClusterEnv(c *C) {
+	defer func() {
+		config, clusterConf, flagCluster = nil, nil, ""
+		os.Setenv("FLYNN_CONTROLLER_URL", "")
+	}()
+	prod := &cfg.Cluster{Name: "prod", URL: "https://controller.prod.example.com"}
+	config = &cfg.Config{
+		Default:  "default",
+		Clusters: []*cfg.Cluster{{Name: "default", URL: "https://controller.example.com"}, prod},
+	}
+	os.Setenv("FLYNN_CONTROLLER_URL", "https://controller.env.example.com")
+
+	// the cluster in the environment takes precedence over the default
+	cluster, err := getCluster()
+	c.Assert(err, IsNil)
+	c.Assert(cluster.Name, Equals, "env")
+	c.Assert(cluster.URL, Equals, "https://controller.env.example.com")
+
+	// but not over a cluster named with -c or FLYNN_CLUSTER
+	clusterConf, flagCluster = nil, "prod"
+	cluster, err = getCluster()
+	c.Assert(err, IsNil)
+	c.Assert(cluster, Equals, prod)
+}
@@ -191,7 +191,7 @@ func (s *service) Leaders(leaders chan *Instance) (stream.Stream, error) {
 			for range events {
 			}
 			if err := eventStream.Err(); err != nil {
-				stream.Error = err
+				stream.SetError(err)
 			}
 			close(leaders)
 		}()
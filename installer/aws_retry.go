@@ -0,0 +1,103 @@
+package installer
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/aws"
+)
+
+// AWSRetryPolicy controls how AWS API calls which fail with a transient
+// error, such as throttling, are retried.
+type AWSRetryPolicy struct {
+	// Attempts is the maximum number of times a call is made.
+	Attempts int
+
+	// Delay is how long to wait before the first retry, doubling with each
+	// one after up to MaxDelay. A random jitter of up to half the delay is
+	// subtracted so concurrent calls don't retry in lockstep.
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// AWSRetry is the policy used for the AWS API calls made while provisioning
+// a cluster.
+var AWSRetry = AWSRetryPolicy{
+	Attempts: 6,
+	Delay:    time.Second,
+	MaxDelay: 30 * time.Second,
+}
+
+// backoff returns how long to wait before the given retry, starting from 1.
+func (p AWSRetryPolicy) backoff(retry int) time.Duration {
+	delay := p.Delay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if half := int64(delay / 2); half > 0 {
+		delay -= time.Duration(rand.Int63n(half))
+	}
+	return delay
+}
+
+// awsCodeRetryable lists the AWS error codes of throttled or temporarily
+// failed requests.
+var awsCodeRetryable = map[string]bool{
+	"Throttling":                    true,
+	"ThrottlingException":           true,
+	"RequestLimitExceeded":          true,
+	"RequestThrottled":              true,
+	"ProvisionedThroughputExceeded": true,
+	"InternalError":                 true,
+	"InternalFailure":               true,
+	"ServiceUnavailable":            true,
+	"Unavailable":                   true,
+}
+
+// retryableAWSError reports whether a call which failed with err may succeed
+// if it is made again. Errors such as invalid credentials or a failed
+// validation are not retryable.
+func retryableAWSError(err error) bool {
+	switch e := err.(type) {
+	case aws.APIError:
+		return e.StatusCode >= 500 || awsCodeRetryable[e.Code]
+	case *url.Error:
+		return true
+	case net.Error:
+		return e.Timeout() || e.Temporary()
+	}
+	return false
+}
+
+// retryAWS calls f, retrying it according to AWSRetry while it fails with a
+// retryable error and sending a retrying event before each retry. op names
+// the call in the event. ErrCancelled is returned if the install is
+// cancelled while waiting to retry.
+func (s *Stack) retryAWS(op string, f func() error) error {
+	policy := AWSRetry
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= policy.Attempts || !retryableAWSError(err) {
+			return err
+		}
+		delay := policy.backoff(attempt)
+		s.sendTypedEvent("retrying", fmt.Sprintf("%s failed (%s), retrying in %s (attempt %d of %d)", op, err, delay, attempt+1, policy.Attempts), map[string]string{
+			"operation": op,
+			"attempt":   strconv.Itoa(attempt + 1),
+			"attempts":  strconv.Itoa(policy.Attempts),
+			"error":     err.Error(),
+		})
+		select {
+		case <-time.After(delay):
+		case <-s.cancelChan():
+			return ErrCancelled
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package installer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/julienschmidt/httprouter"
+	"github.com/flynn/flynn/Godeps/_workspace/src/golang.org/x/crypto/nacl/secretbox"
+	"github.com/flynn/flynn/pkg/httphelper"
+)
+
+var (
+	ErrInstallerNotEmpty = errors.New("installer: refusing to restore over existing installer data")
+	ErrInstallsRunning   = errors.New("installer: refusing to restore while installs are in progress")
+	ErrBackupDecrypt     = errors.New("installer: unable to decrypt backup, wrong passphrase?")
+)
+
+const (
+	backupVersion    = 1
+	backupIterations = 100000
+)
+
+type backup struct {
+	Version int           `json:"version"`
+	Salt    []byte        `json:"salt"`
+	Files   []*backupFile `json:"files"`
+}
+
+// backupFile is a file from the installer directory with its contents
+// encrypted, as most of them contain secrets such as credentials, SSH keys
+// or controller keys.
+type backupFile struct {
+	Path  string      `json:"path"`
+	Mode  os.FileMode `json:"mode"`
+	Nonce []byte      `json:"nonce"`
+	Data  []byte      `json:"data"`
+}
+
+func installerDir() string {
+	return filepath.Dir(dataPath)
+}
+
+// lockAll stops any installer data being written while it is backed up or
+// restored, the returned function releases the locks. The event logs are
+// locked last as nothing else is locked while appending to them.
+func (api *httpAPI) lockAll() func() {
+	api.InstallerStackMtx.Lock()
+	credentialsMtx.Lock()
+	// Restore may drop installs from InstallerStacks while they are locked
+	stacks := make([]*Stack, 0, len(api.InstallerStacks))
+	for _, s := range api.InstallerStacks {
+		s.Stack.persistMutex.Lock()
+		stacks = append(stacks, s.Stack)
+	}
+	eventsMtx.Lock()
+	return func() {
+		eventsMtx.Unlock()
+		for _, s := range stacks {
+			s.persistMutex.Unlock()
+		}
+		credentialsMtx.Unlock()
+		api.InstallerStackMtx.Unlock()
+	}
+}
+
+// Backup writes a consistent snapshot of all installer data (clusters,
+// credentials, keys, events and queued jobs) to w, encrypted with the
+// passphrase. Files are backed up as they are stored, so credentials and
+// keys encrypted with CredentialsKeyEnv stay encrypted with it once
+// restored.
+func (api *httpAPI) Backup(w io.Writer, passphrase string) error {
+	unlock := api.lockAll()
+	defer unlock()
+
+	b := &backup{Version: backupVersion, Salt: make([]byte, 32)}
+	if _, err := io.ReadFull(rand.Reader, b.Salt); err != nil {
+		return err
+	}
+	key := backupKey(passphrase, b.Salt)
+
+	dir := installerDir()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == dir {
+			return filepath.SkipDir
+		} else if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		f := &backupFile{Path: filepath.ToSlash(rel), Mode: info.Mode().Perm()}
+		var nonce [24]byte
+		if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+			return err
+		}
+		f.Nonce = nonce[:]
+		f.Data = secretbox.Seal(nil, data, &nonce, key)
+		b.Files = append(b.Files, f)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(b)
+}
+
+// Restore replaces the installer data with the contents of a backup created
+// by Backup. It returns ErrInstallerNotEmpty if there is existing data unless
+// force is set, in which case the files which aren't in the backup are
+// removed so the installer is left exactly as it was backed up. A forced
+// restore returns ErrInstallsRunning if any install is in progress, as it
+// would carry on saving over the restored data, and otherwise drops the
+// finished installs so their restored versions are loaded instead.
+func (api *httpAPI) Restore(r io.Reader, passphrase string, force bool) error {
+	b := &backup{}
+	if err := json.NewDecoder(r).Decode(b); err != nil {
+		return err
+	}
+	if b.Version != backupVersion {
+		return errors.New("installer: unsupported backup version")
+	}
+	key := backupKey(passphrase, b.Salt)
+
+	// decrypt everything before touching the existing data
+	contents := make([][]byte, len(b.Files))
+	for i, f := range b.Files {
+		if len(f.Nonce) != 24 || f.Path == "" || filepath.IsAbs(f.Path) || strings.HasPrefix(filepath.Clean(f.Path), "..") {
+			return errors.New("installer: invalid file in backup")
+		}
+		var nonce [24]byte
+		copy(nonce[:], f.Nonce)
+		data, ok := secretbox.Open(nil, f.Data, &nonce, key)
+		if !ok {
+			return ErrBackupDecrypt
+		}
+		contents[i] = data
+	}
+
+	unlock := api.lockAll()
+	defer unlock()
+
+	dir := installerDir()
+	if !force {
+		if empty, err := dirEmpty(dir); err != nil {
+			return err
+		} else if !empty {
+			return ErrInstallerNotEmpty
+		}
+	} else {
+		if len(api.launching) > 0 {
+			return ErrInstallsRunning
+		}
+		for _, inst := range api.InstallerStacks {
+			// the stacks are locked, so Done can't be replaced
+			select {
+			case <-inst.Stack.Done:
+			default:
+				return ErrInstallsRunning
+			}
+		}
+		for id := range api.InstallerStacks {
+			delete(api.InstallerStacks, id)
+		}
+	}
+	restored := make(map[string]struct{}, len(b.Files))
+	for i, f := range b.Files {
+		path := filepath.Join(dir, filepath.FromSlash(f.Path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := writeFile(path, contents[i], f.Mode); err != nil {
+			return err
+		}
+		restored[filepath.Clean(path)] = struct{}{}
+		invalidateBackupFile(f.Path)
+	}
+	if !force {
+		return nil
+	}
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		if _, ok := restored[filepath.Clean(path)]; ok {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		if rel, err := filepath.Rel(dir, path); err == nil {
+			invalidateBackupFile(filepath.ToSlash(rel))
+		}
+		return nil
+	})
+}
+
+type backupRequest struct {
+	Passphrase string `json:"passphrase"`
+}
+
+type restoreRequest struct {
+	Passphrase string          `json:"passphrase"`
+	Force      bool            `json:"force"`
+	Backup     json.RawMessage `json:"backup"`
+}
+
+// BackupHandler responds with a backup of all installer data encrypted with
+// the passphrase in the request.
+func (api *httpAPI) BackupHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	var input *backupRequest
+	if err := httphelper.DecodeJSON(req, &input); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	if input.Passphrase == "" {
+		httphelper.ValidationError(w, "passphrase", "must be set")
+		return
+	}
+	var buf bytes.Buffer
+	if err := api.Backup(&buf, input.Passphrase); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="flynn-installer-backup.json"`)
+	w.WriteHeader(200)
+	w.Write(buf.Bytes())
+}
+
+// RestoreHandler restores the backup in the request, see Restore.
+func (api *httpAPI) RestoreHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	var input *restoreRequest
+	if err := httphelper.DecodeJSON(req, &input); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	if len(input.Backup) == 0 {
+		httphelper.ValidationError(w, "backup", "must be set")
+		return
+	}
+	err := api.Restore(bytes.NewReader(input.Backup), input.Passphrase, input.Force)
+	switch err {
+	case nil:
+		w.WriteHeader(200)
+	case ErrBackupDecrypt:
+		httphelper.ValidationError(w, "passphrase", "does not decrypt the backup")
+	case ErrInstallerNotEmpty, ErrInstallsRunning:
+		httphelper.Error(w, httphelper.PreconditionFailedErr(err.Error()))
+	default:
+		httphelper.Error(w, err)
+	}
+}
+
+// invalidateBackupFile drops the cached cluster saved in the file with the
+// given backup path, if it is a cluster's file, once it has been replaced.
+func invalidateBackupFile(path string) {
+	if strings.HasPrefix(path, "clusters/") && strings.HasSuffix(path, ".json") {
+		clusterCache.Invalidate(strings.TrimSuffix(filepath.Base(path), ".json"))
+	}
+}
+
+func dirEmpty(dir string) (bool, error) {
+	empty := true
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == dir {
+			return filepath.SkipDir
+		} else if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			empty = false
+		}
+		return nil
+	})
+	return empty, err
+}
+
+// backupKey derives the encryption key from the passphrase using
+// PBKDF2-HMAC-SHA256.
+func backupKey(passphrase string, salt []byte) *[32]byte {
+	var key [32]byte
+	copy(key[:], pbkdf2SHA256([]byte(passphrase), salt, backupIterations, len(key)))
+	return &key
+}
@@ -0,0 +1,232 @@
+package installer
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/aws"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/cloudformation"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/ec2"
+)
+
+// redactedFields are the fields of a persisted cluster which are left out
+// of diagnostic bundles.
+var redactedFields = []string{"controller_key", "dashboard_login_token", "discovery_token"}
+
+func clusterLogPath(clusterID string) string {
+	return filepath.Join(clustersDir, filepath.Base(clusterID)+".log")
+}
+
+// DiagnosticBundle writes a gzipped tarball to w for debugging an install,
+// containing the cluster spec with secrets removed, its events, the stack
+// events and console output of failed instances (if the install is still
+// running in this process) and the installer log of the install. Secrets
+// are also scrubbed from everything else in the bundle.
+func (api *httpAPI) DiagnosticBundle(clusterID string, w io.Writer) error {
+	s, err := api.FindCluster(clusterID)
+	if os.IsNotExist(err) {
+		return ErrClusterNotFound
+	} else if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	b := &bundleWriter{
+		tw:       tar.NewWriter(gz),
+		replacer: newRedactor(s.secrets()),
+	}
+
+	spec, err := redactedSpec(s)
+	if err != nil {
+		return err
+	}
+	b.addJSON("cluster.json", spec)
+
+	api.InstallerStackMtx.RLock()
+	inst := api.InstallerStacks[clusterID]
+	api.InstallerStackMtx.RUnlock()
+	var events []*httpEvent
+	if inst != nil {
+		// in memory events include the ephemeral ones
+		inst.eventsMtx.Lock()
+		events = append(events, inst.events...)
+		inst.eventsMtx.Unlock()
+	} else if events, err = loadEvents(clusterID); err != nil {
+		b.addError("events.json", err)
+	}
+	b.addJSON("events.json", events)
+
+	if inst != nil && s.StackID != "" && s.cf != nil {
+		res, err := s.cf.DescribeStackEvents(&cloudformation.DescribeStackEventsInput{StackName: aws.String(s.StackID)})
+		if err != nil {
+			b.addError("stack_events.json", err)
+		} else {
+			b.addJSON("stack_events.json", res.StackEvents)
+		}
+		b.addConsoleOutput(s, failedInstances(s, events))
+	}
+
+	if data, err := ioutil.ReadFile(clusterLogPath(clusterID)); err == nil {
+		b.add("installer.log", data)
+	} else if !os.IsNotExist(err) {
+		b.addError("installer.log", err)
+	}
+
+	if b.err != nil {
+		return b.err
+	}
+	if err := b.tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// secrets returns the secret values of the stack which must not be exposed.
+func (s *Stack) secrets() []string {
+	var secrets []string
+	values := []string{s.ControllerKey, s.DashboardLoginToken, s.DiscoveryToken}
+	if s.Domain != nil {
+		values = append(values, s.Domain.Token)
+	}
+	for _, v := range values {
+		if v != "" {
+			secrets = append(secrets, v)
+		}
+	}
+	return secrets
+}
+
+// redactedSpec returns the persisted fields of the stack with the secret
+// ones redacted.
+func redactedSpec(s *Stack) (map[string]interface{}, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var spec map[string]interface{}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+	for _, f := range redactedFields {
+		if _, ok := spec[f]; ok {
+			spec[f] = "[REDACTED]"
+		}
+	}
+	if domain, ok := spec["domain"].(map[string]interface{}); ok {
+		if _, ok := domain["token"]; ok {
+			domain["token"] = "[REDACTED]"
+		}
+	}
+	return spec, nil
+}
+
+// failedInstances returns the logical IDs of the instances which failed
+// their readiness probe, or nil for all instances if the install failed
+// before they were probed.
+func failedInstances(s *Stack, events []*httpEvent) map[string]bool {
+	failed := make(map[string]bool)
+	for _, e := range events {
+		if e.Type == "instance_failed" && e.Metadata["instance"] != "" {
+			failed[e.Metadata["instance"]] = true
+		}
+	}
+	if len(failed) == 0 && s.State == StateError {
+		return nil
+	}
+	return failed
+}
+
+func (b *bundleWriter) addConsoleOutput(s *Stack, failed map[string]bool) {
+	if failed != nil && len(failed) == 0 {
+		return
+	}
+	instances, err := s.stackInstances()
+	if err != nil {
+		b.addError("console", err)
+		return
+	}
+	for _, i := range instances {
+		if i.InstanceID == nil {
+			continue
+		}
+		if failed != nil {
+			var logicalID string
+			for _, t := range i.Tags {
+				if t.Key != nil && *t.Key == "aws:cloudformation:logical-id" && t.Value != nil {
+					logicalID = *t.Value
+				}
+			}
+			if !failed[logicalID] {
+				continue
+			}
+		}
+		name := fmt.Sprintf("console/%s.log", *i.InstanceID)
+		res, err := s.ec2.GetConsoleOutput(&ec2.GetConsoleOutputRequest{InstanceID: i.InstanceID})
+		if err != nil {
+			b.addError(name, err)
+			continue
+		}
+		if res.Output == nil {
+			continue
+		}
+		output, err := base64.StdEncoding.DecodeString(*res.Output)
+		if err != nil {
+			b.addError(name, err)
+			continue
+		}
+		b.add(name, output)
+	}
+}
+
+type bundleWriter struct {
+	tw       *tar.Writer
+	replacer *strings.Replacer
+	err      error
+}
+
+func newRedactor(secrets []string) *strings.Replacer {
+	pairs := make([]string, 0, 2*len(secrets))
+	for _, s := range secrets {
+		pairs = append(pairs, s, "[REDACTED]")
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+func (b *bundleWriter) add(name string, data []byte) {
+	if b.err != nil {
+		return
+	}
+	data = []byte(b.replacer.Replace(string(data)))
+	if b.err = b.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}); b.err != nil {
+		return
+	}
+	_, b.err = b.tw.Write(data)
+}
+
+func (b *bundleWriter) addJSON(name string, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		b.addError(name, err)
+		return
+	}
+	b.add(name, data)
+}
+
+// addError records that part of the bundle couldn't be collected.
+func (b *bundleWriter) addError(name string, err error) {
+	b.add(name+".error", []byte(err.Error()))
+}
@@ -0,0 +1,97 @@
+package installer
+
+import (
+	"sync"
+	"time"
+)
+
+// clusterCache caches stacks loaded from disk by FindCluster.
+var clusterCache = newStackCache(100, time.Minute)
+
+type stackCacheEntry struct {
+	stack   *Stack
+	expires time.Time
+}
+
+// stackCache is a bounded cache of stacks keyed by ID with entries expiring
+// after ttl.
+type stackCache struct {
+	mtx     sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*stackCacheEntry
+
+	// generation is incremented by every Invalidate, so that a stack
+	// loaded while any stack was invalidated isn't cached. It is a
+	// single counter rather than one per ID so that it doesn't grow with
+	// the number of stacks ever invalidated.
+	generation uint64
+}
+
+func newStackCache(size int, ttl time.Duration) *stackCache {
+	return &stackCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*stackCacheEntry, size),
+	}
+}
+
+// Load returns the cached stack for id, calling load to fetch it on a miss.
+// The result of load isn't cached if a stack was invalidated in the
+// meantime.
+func (c *stackCache) Load(id string, load func() (*Stack, error)) (*Stack, error) {
+	c.mtx.Lock()
+	if e, ok := c.entries[id]; ok {
+		if time.Now().Before(e.expires) {
+			c.mtx.Unlock()
+			return e.stack, nil
+		}
+		delete(c.entries, id)
+	}
+	gen := c.generation
+	c.mtx.Unlock()
+
+	s, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.generation != gen {
+		return s, nil
+	}
+	if _, ok := c.entries[id]; !ok && len(c.entries) >= c.size {
+		c.evict()
+	}
+	c.entries[id] = &stackCacheEntry{stack: s, expires: time.Now().Add(c.ttl)}
+	return s, nil
+}
+
+// Invalidate removes the entry for id, it must be called whenever the
+// stack is modified or deleted.
+func (c *stackCache) Invalidate(id string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	delete(c.entries, id)
+	c.generation++
+}
+
+// evict removes expired entries, or the entry closest to expiring if there
+// are none, the caller must hold mtx.
+func (c *stackCache) evict() {
+	now := time.Now()
+	var oldest string
+	for id, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, id)
+			continue
+		}
+		if oldest == "" || e.expires.Before(c.entries[oldest].expires) {
+			oldest = id
+		}
+	}
+	if len(c.entries) >= c.size {
+		delete(c.entries, oldest)
+	}
+}
@@ -0,0 +1,95 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/julienschmidt/httprouter"
+	"github.com/flynn/flynn/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/flynn/flynn/pkg/httphelper"
+)
+
+var ErrInstallNotRunning = errors.New("installer: install is not in progress")
+
+// CancelInstall aborts the running install with the given ID. The install
+// stops before its next step, or once it stops waiting for the stack or for
+// an answer to a prompt, then the stack is deleted to roll back the AWS
+// resources created so far. The cluster is left in the aborted state and a
+// cluster_install_aborted event is sent. ErrInstallNotRunning is returned if
+// the install has already finished, or been aborted by a concurrent call.
+func (api *httpAPI) CancelInstall(id string) error {
+	api.InstallerStackMtx.RLock()
+	inst := api.InstallerStacks[id]
+	api.InstallerStackMtx.RUnlock()
+	if inst == nil {
+		return ErrClusterNotFound
+	}
+	s := inst.Stack
+	if state := s.currentState(); state != StateProvisioning && state != StateBootstrapping {
+		return ErrInstallNotRunning
+	}
+
+	s.cancelInstall()
+	<-s.doneChan()
+	// the install may have been deleted, or have finished before noticing
+	// it was cancelled
+	if err := s.beginOperation(); err != nil {
+		return ErrInstallNotRunning
+	}
+	defer s.endOperation()
+	if s.currentState() == StateRunning {
+		return ErrInstallNotRunning
+	}
+	if err := s.setState(StateAborted); err == ErrInvalidTransition && s.currentState() == StateAborted {
+		return ErrInstallNotRunning
+	} else if err != nil {
+		return err
+	}
+	// forward the stack events of the rollback now that the install is over
+	s.resetCancel()
+	defer inst.pumpEvents()()
+
+	s.ErrorReason = "Install aborted"
+	var err error
+	if s.StackID != "" {
+		if err = s.deleteStack(); err != nil {
+			s.ErrorReason = fmt.Sprintf("Install aborted, failed to delete stack %s: %s", s.StackName, err)
+		} else {
+			s.StackID = ""
+			s.StackName = ""
+		}
+	}
+	s.persist()
+	inst.sendEvent(&httpEvent{
+		Type:        "cluster_install_aborted",
+		Description: s.ErrorReason,
+	})
+	return err
+}
+
+// cancelWhenDone aborts the install once ctx is done unless the install has
+// finished first.
+func (api *httpAPI) cancelWhenDone(ctx context.Context, inst *httpInstaller) {
+	select {
+	case <-ctx.Done():
+		if err := api.CancelInstall(inst.ID); err != nil && err != ErrInstallNotRunning {
+			inst.logger.Error("error aborting install", "err", err)
+		}
+	case <-inst.Stack.doneChan():
+	}
+}
+
+func (api *httpAPI) CancelInstallHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	if err := api.CancelInstall(params.ByName("id")); err == ErrClusterNotFound {
+		w.WriteHeader(404)
+		return
+	} else if err == ErrInstallNotRunning {
+		httphelper.Error(w, httphelper.PreconditionFailedErr(err.Error()))
+		return
+	} else if err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
@@ -0,0 +1,84 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/golang.org/x/crypto/ssh"
+	"github.com/flynn/flynn/util/release/types"
+)
+
+// DefaultChannel is the release channel installed from if none is chosen.
+const DefaultChannel = "stable"
+
+// ReleaseChannels maps the release channels to the URL of their image
+// manifest, the first version in which is the latest.
+var ReleaseChannels = map[string]string{
+	"stable":  "https://dl.flynn.io/ec2/images.json",
+	"nightly": "https://dl.flynn.io/ec2/nightly/images.json",
+}
+
+var ErrVersionUnavailable = errors.New("installer: the chosen Flynn version is unavailable")
+
+// versionPattern matches Flynn release versions, e.g. v20150515.0.
+var versionPattern = regexp.MustCompile(`^v\d{8}\.\d+(-[\w.]+)?$`)
+
+func (s *Stack) validateRelease() error {
+	if _, ok := ReleaseChannels[s.Channel]; !ok {
+		channels := make([]string, 0, len(ReleaseChannels))
+		for c := range ReleaseChannels {
+			channels = append(channels, c)
+		}
+		sort.Strings(channels)
+		return fmt.Errorf("Unknown release channel %s, must be one of %s", s.Channel, strings.Join(channels, ", "))
+	}
+	if s.Version != "" && !versionPattern.MatchString(s.Version) {
+		return fmt.Errorf("Invalid Flynn version %s, versions look like v20150515.0", s.Version)
+	}
+	return nil
+}
+
+// findVersion returns the given version from the manifest, or the latest
+// version if it is empty.
+func findVersion(manifest *release.EC2Manifest, version string) (*release.EC2Version, error) {
+	if len(manifest.Versions) == 0 {
+		return nil, errors.New("No versions in manifest")
+	}
+	if version == "" {
+		return manifest.Versions[0], nil
+	}
+	for _, v := range manifest.Versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return nil, ErrVersionUnavailable
+}
+
+// checkHostVersion checks that the instance is running the resolved Flynn
+// version, so that a pinned version isn't silently bootstrapped with another
+// one. Clusters launched from a saved image record the version it runs.
+func (s *Stack) checkHostVersion(conn *ssh.Client) error {
+	sess, err := conn.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+	out, err := sess.Output("flynn-host version")
+	if err != nil {
+		return fmt.Errorf("Unable to find the instance's Flynn version: %s", err)
+	}
+	version := strings.TrimSpace(string(out))
+	if s.InstalledVersion == "" {
+		s.InstalledVersion = version
+		s.persist()
+		return nil
+	}
+	if version != s.InstalledVersion {
+		return fmt.Errorf("Instance is running Flynn %s, expected %s", version, s.InstalledVersion)
+	}
+	return nil
+}
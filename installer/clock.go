@@ -0,0 +1,22 @@
+package installer
+
+import "time"
+
+// Clock is the source of the time used to stamp events and time install
+// phases, tests replace it to get deterministic timelines.
+type Clock interface {
+	Now() time.Time
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time { return time.Now() }
+
+// now returns the current time from the stack's Clock, or the wall clock if
+// it isn't set.
+func (s *Stack) now() time.Time {
+	if s.Clock == nil {
+		return wallClock{}.Now()
+	}
+	return s.Clock.Now()
+}
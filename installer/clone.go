@@ -0,0 +1,135 @@
+package installer
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/julienschmidt/httprouter"
+	"github.com/flynn/flynn/pkg/httphelper"
+)
+
+// CloneCluster launches a new cluster with the configuration of an existing
+// one, with the given overrides applied. Overrides are keyed by the fields
+// of the install request (e.g. "num_instances" or "credential_id"). No data
+// is copied, and secrets such as credentials and the discovery token are not
+// carried over. It returns the ID of the new cluster.
+func (api *httpAPI) CloneCluster(sourceID string, overrides map[string]interface{}) (string, error) {
+	src, err := api.FindCluster(sourceID)
+	if os.IsNotExist(err) {
+		return "", ErrClusterNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	input := cloneInput(src)
+	if len(overrides) > 0 {
+		if input, err = applyOverrides(input, overrides); err != nil {
+			return "", err
+		}
+	}
+
+	s, err := api.launch(input)
+	if err != nil {
+		return "", err
+	}
+	return s.ID, nil
+}
+
+// cloneInput returns the install input which launches a cluster with the
+// configuration of the given one, leaving out its credentials and secrets.
+func cloneInput(src *Stack) *jsonInput {
+	input := &jsonInput{
+		Region:               src.Region,
+		InstanceType:         src.InstanceType,
+		NumInstances:         src.NumInstances,
+		VpcCidr:              src.VpcCidr,
+		SubnetCidr:           src.SubnetCidr,
+		VpcID:                src.VpcID,
+		SubnetID:             src.SubnetID,
+		BootstrapManifest:    src.BootstrapManifest,
+		DNSProvider:          src.DNSProvider,
+		LogLevel:             src.LogLevel,
+		SnapshotBeforeDelete: src.SnapshotBeforeDelete,
+		VolumeSize:           src.VolumeSize,
+		EncryptVolumes:       src.EncryptVolumes,
+		Features:             src.Features,
+		PlacementGroup:       src.PlacementGroup,
+		CreatePlacementGroup: src.CreatePlacementGroup,
+		InstanceNameTemplate: src.InstanceNameTemplate,
+		NotifyURL:            src.NotifyURL,
+		UseSpotInstances:     src.UseSpotInstances,
+		SpotMaxPrice:         src.SpotMaxPrice,
+		SpotFallback:         src.SpotFallback,
+		CopyImageFromRegion:  src.CopyImageFromRegion,
+		Channel:              src.Channel,
+		Version:              src.Version,
+	}
+	if src.Timeout != 0 {
+		input.Timeout = src.Timeout.String()
+	}
+	if src.PostBootWait != 0 {
+		input.PostBootWait = src.PostBootWait.String()
+	}
+	// a generated key pair belongs to the source cluster
+	if !src.GeneratedSSHKey {
+		input.SSHKeyName = src.SSHKeyName
+	}
+	if len(src.Tags) > 0 {
+		input.Tags = make(map[string]string, len(src.Tags))
+		for k, v := range src.Tags {
+			input.Tags[k] = v
+		}
+	}
+	if len(src.Metadata) > 0 {
+		input.Metadata = make(map[string]string, len(src.Metadata))
+		for k, v := range src.Metadata {
+			input.Metadata[k] = v
+		}
+	}
+	return input
+}
+
+// applyOverrides sets the given fields of the input, returning a validation
+// error for unknown fields.
+func applyOverrides(input *jsonInput, overrides map[string]interface{}) (*jsonInput, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for k, v := range overrides {
+		fields[k] = v
+	}
+	if data, err = json.Marshal(fields); err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	res := &jsonInput{}
+	if err := dec.Decode(res); err != nil {
+		return nil, validationErr("", err.Error())
+	}
+	return res, nil
+}
+
+func (api *httpAPI) CloneHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	var overrides map[string]interface{}
+	if err := httphelper.DecodeJSON(req, &overrides); err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	id, err := api.CloneCluster(params.ByName("id"), overrides)
+	if err == ErrClusterNotFound {
+		httphelper.ObjectNotFoundError(w, "install instance not found")
+		return
+	} else if err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, map[string]string{"id": id})
+}
@@ -0,0 +1,122 @@
+package installer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	log "github.com/flynn/flynn/Godeps/_workspace/src/gopkg.in/inconshreveable/log15.v2"
+)
+
+// clusterLogSize is the maximum size of the tail of an install's log which
+// is kept in memory and saved with the cluster once the install is done.
+const clusterLogSize = 256 * 1024
+
+// clusterLog is a log handler which keeps the latest lines logged for an
+// install, up to clusterLogSize bytes of them.
+type clusterLog struct {
+	mtx    sync.Mutex
+	lines  [][]byte
+	size   int
+	format log.Format
+}
+
+// newClusterLog returns a clusterLog which starts with the lines of the
+// given log, that of an earlier run of the install.
+func newClusterLog(data []byte) *clusterLog {
+	l := &clusterLog{format: log.LogfmtFormat()}
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(line) > 0 {
+			l.add(line)
+		}
+	}
+	return l
+}
+
+func (l *clusterLog) Log(r *log.Record) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.add(l.format.Format(r))
+	return nil
+}
+
+// add appends the line, dropping the oldest lines until the log fits in
+// clusterLogSize again. The caller must hold mtx.
+func (l *clusterLog) add(line []byte) {
+	l.lines = append(l.lines, line)
+	l.size += len(line)
+	for l.size > clusterLogSize && len(l.lines) > 1 {
+		l.size -= len(l.lines[0])
+		l.lines[0] = nil
+		l.lines = l.lines[1:]
+	}
+}
+
+func (l *clusterLog) Bytes() []byte {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return bytes.Join(l.lines, nil)
+}
+
+// readClusterLogTail returns the last clusterLogSize bytes of the log saved
+// for the cluster, starting at a line.
+func readClusterLogTail(id string) ([]byte, error) {
+	f, err := os.Open(clusterLogPath(id))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size() - clusterLogSize
+	if offset <= 0 {
+		return ioutil.ReadAll(f)
+	}
+	if _, err := f.Seek(offset, os.SEEK_SET); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	return data, nil
+}
+
+// saveLogTail replaces the log saved for the install with its tail, so the
+// logs of past installs don't grow without bound.
+func (s *httpInstaller) saveLogTail() {
+	if s.logBuffer == nil {
+		return
+	}
+	if err := ioutil.WriteFile(clusterLogPath(s.ID), s.logBuffer.Bytes(), 0600); err != nil {
+		s.logger.Error("error saving install log", "err", err)
+	}
+}
+
+// ClusterLog returns the latest lines logged for the install with the given
+// ID, those of an install in progress or the tail saved with the cluster,
+// for showing what happened during the install.
+func (api *httpAPI) ClusterLog(id string) ([]byte, error) {
+	api.InstallerStackMtx.RLock()
+	inst := api.InstallerStacks[id]
+	api.InstallerStackMtx.RUnlock()
+	if inst != nil && inst.logBuffer != nil {
+		return inst.logBuffer.Bytes(), nil
+	}
+	if _, err := api.FindCluster(id); os.IsNotExist(err) {
+		return nil, ErrClusterNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	data, err := readClusterLogTail(id)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
@@ -0,0 +1,123 @@
+package installer
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/golang.org/x/crypto/ssh"
+)
+
+// connectivityEndpoint is a port which the instances must be able to reach
+// for the cluster to work.
+type connectivityEndpoint struct {
+	Name string
+	Host string
+	Port int
+}
+
+func (e connectivityEndpoint) String() string {
+	return fmt.Sprintf("%s (%s:%d)", e.Name, e.Host, e.Port)
+}
+
+// connectivityEndpoints returns the endpoints checked from the first
+// instance: the controller and git through the cluster domain, the router
+// on each instance's public IP and flynn-host and discoverd on the private
+// IPs of the other instances.
+func (s *Stack) connectivityEndpoints(privateIPs []string) []connectivityEndpoint {
+	endpoints := []connectivityEndpoint{
+		{"controller", "controller." + s.Domain.Name, 443},
+		{"git", s.Domain.Name, 2222},
+	}
+	for _, ip := range s.InstanceIPs {
+		endpoints = append(endpoints,
+			connectivityEndpoint{"router-http", ip, 80},
+			connectivityEndpoint{"router-https", ip, 443},
+		)
+	}
+	for i, ip := range privateIPs {
+		if i == 0 || ip == "" {
+			continue
+		}
+		endpoints = append(endpoints,
+			connectivityEndpoint{"flynn-host", ip, 1113},
+			connectivityEndpoint{"discoverd", ip, 1111},
+		)
+	}
+	return endpoints
+}
+
+// checkConnectivity verifies from the first instance that the controller,
+// router and the other instances are reachable, sending a
+// connectivity_failed event for each endpoint which isn't and
+// connectivity_ok if they all are. A failure usually means the security
+// groups have been changed, it doesn't fail the install as the cluster may
+// still be usable.
+func (s *Stack) checkConnectivity() error {
+	s.SendEvent("Checking connectivity between instances")
+	sshConfig, err := s.sshConfig()
+	if err != nil {
+		return err
+	}
+	privateIPs, err := s.instancePrivateIPs()
+	if err != nil {
+		s.SendEvent(fmt.Sprintf("WARNING: Unable to find the instances' private IPs, skipping internal checks: %s", err))
+	}
+
+	from := s.InstanceIPs[0]
+	conn, err := ssh.Dial("tcp", from+":22", sshConfig)
+	if err != nil {
+		s.SendEvent(fmt.Sprintf("WARNING: Unable to check connectivity from %s: %s", from, err))
+		return nil
+	}
+	defer conn.Close()
+
+	failed := 0
+	for _, e := range s.connectivityEndpoints(privateIPs) {
+		if err := probeTCP(conn, e.Host, e.Port); err != nil {
+			failed++
+			s.sendTypedEvent("connectivity_failed", fmt.Sprintf("WARNING: %s can't reach %s, check the security group rules for port %d", instanceName(0), e, e.Port), map[string]string{
+				"endpoint": e.Name,
+				"host":     e.Host,
+				"port":     strconv.Itoa(e.Port),
+			})
+		}
+	}
+	if failed == 0 {
+		s.sendTypedEvent("connectivity_ok", "All cluster endpoints are reachable", nil)
+	}
+	return nil
+}
+
+// probeTCP connects to host:port from the remote end of the SSH connection.
+func probeTCP(conn *ssh.Client, host string, port int) error {
+	sess, err := conn.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+	return sess.Run(fmt.Sprintf("timeout 5 bash -c 'exec 3<>/dev/tcp/%s/%d'", host, port))
+}
+
+// instancePrivateIPs returns the private IP of each instance, indexed like
+// InstanceIPs.
+func (s *Stack) instancePrivateIPs() ([]string, error) {
+	instances, err := s.stackInstances()
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]string, s.NumInstances)
+	for _, i := range instances {
+		if i.PrivateIPAddress == nil {
+			continue
+		}
+		for _, t := range i.Tags {
+			if t.Key == nil || *t.Key != "aws:cloudformation:logical-id" || t.Value == nil {
+				continue
+			}
+			if n := s.instanceIndex(*t.Value); n >= 0 {
+				ips[n] = *i.PrivateIPAddress
+			}
+		}
+	}
+	return ips, nil
+}
@@ -0,0 +1,262 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// InstanceHourlyPrices are the approximate on-demand Linux prices in USD of
+// the instance types, used to estimate cluster costs.
+var InstanceHourlyPrices = map[string]float64{
+	"t2.medium":   0.052,
+	"t2.large":    0.104,
+	"m3.medium":   0.067,
+	"m3.large":    0.133,
+	"m3.xlarge":   0.266,
+	"m3.2xlarge":  0.532,
+	"m4.large":    0.126,
+	"m4.xlarge":   0.252,
+	"m4.2xlarge":  0.504,
+	"m4.4xlarge":  1.008,
+	"m4.10xlarge": 2.52,
+	"c3.large":    0.105,
+	"c3.xlarge":   0.21,
+	"c3.2xlarge":  0.42,
+	"c3.4xlarge":  0.84,
+	"c3.8xlarge":  1.68,
+	"c4.large":    0.116,
+	"c4.xlarge":   0.232,
+	"c4.2xlarge":  0.464,
+	"c4.4xlarge":  0.928,
+	"c4.8xlarge":  1.856,
+	"r3.large":    0.175,
+	"r3.xlarge":   0.35,
+	"r3.2xlarge":  0.7,
+	"r3.4xlarge":  1.4,
+	"r3.8xlarge":  2.8,
+}
+
+// VolumeMonthlyPricePerGB is the approximate price in USD of general purpose
+// EBS storage.
+var VolumeMonthlyPricePerGB = 0.10
+
+// HostedZoneMonthlyPrice and HealthCheckMonthlyPrice are the approximate
+// prices in USD of a Route53 hosted zone and of a health check of an AWS
+// endpoint.
+var (
+	HostedZoneMonthlyPrice  = 0.50
+	HealthCheckMonthlyPrice = 0.50
+)
+
+const hoursPerMonth = 730
+
+// PricingSource gives the prices in USD of the resources of a cluster in the
+// given region, see CostEstimate.
+type PricingSource interface {
+	InstanceHourlyPrice(region, instanceType string) (float64, error)
+	VolumeMonthlyPricePerGB(region string) (float64, error)
+	HostedZoneMonthlyPrice(region string) (float64, error)
+	HealthCheckMonthlyPrice(region string) (float64, error)
+}
+
+// Pricing is the source of the prices clusters are estimated to cost, it
+// may be replaced with one which queries the AWS Pricing API. By default the
+// prices are those of the tables above, which don't vary by region.
+var Pricing PricingSource = tablePricing{}
+
+type tablePricing struct{}
+
+func (tablePricing) InstanceHourlyPrice(region, instanceType string) (float64, error) {
+	price, ok := InstanceHourlyPrices[instanceType]
+	if !ok {
+		return 0, fmt.Errorf("No price known for instance type %s", instanceType)
+	}
+	return price, nil
+}
+
+func (tablePricing) VolumeMonthlyPricePerGB(string) (float64, error) {
+	return VolumeMonthlyPricePerGB, nil
+}
+
+func (tablePricing) HostedZoneMonthlyPrice(string) (float64, error) {
+	return HostedZoneMonthlyPrice, nil
+}
+
+func (tablePricing) HealthCheckMonthlyPrice(string) (float64, error) {
+	return HealthCheckMonthlyPrice, nil
+}
+
+// EstimateMonthlyCost returns the approximate monthly cost in USD of running
+// count instances of the given type.
+func EstimateMonthlyCost(instanceType string, count int) (float64, error) {
+	price, ok := InstanceHourlyPrices[instanceType]
+	if !ok {
+		return 0, fmt.Errorf("No price known for instance type %s", instanceType)
+	}
+	perInstance := price*hoursPerMonth + VolumeMonthlyPricePerGB*defaultVolumeSize
+	return perInstance * float64(count), nil
+}
+
+// CostDelta is the change in the estimated monthly cost of a cluster when it
+// is resized.
+type CostDelta struct {
+	Current  float64 `json:"current"`
+	Proposed float64 `json:"proposed"`
+	Delta    float64 `json:"delta"`
+}
+
+// EstimateResizeCost estimates how the monthly cost of the cluster with the
+// given ID changes if it is resized to newCount instances.
+func (api *httpAPI) EstimateResizeCost(id string, newCount int) (*CostDelta, error) {
+	s, err := api.FindCluster(id)
+	if os.IsNotExist(err) {
+		return nil, ErrClusterNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if err := validateNumInstances(newCount); err != nil {
+		return nil, err
+	}
+	current, err := s.EstimateCost()
+	if err != nil {
+		return nil, err
+	}
+	proposed, err := s.resizedTo(newCount).EstimateCost()
+	if err != nil {
+		return nil, err
+	}
+	return &CostDelta{
+		Current:  current.Monthly,
+		Proposed: proposed.Monthly,
+		Delta:    proposed.Monthly - current.Monthly,
+	}, nil
+}
+
+// resizedTo returns a copy of the fields of the stack its cost is estimated
+// from with NumInstances set to n.
+func (s *Stack) resizedTo(n int) *Stack {
+	return &Stack{
+		Region:           s.Region,
+		InstanceType:     s.InstanceType,
+		NumInstances:     n,
+		VolumeSize:       s.VolumeSize,
+		DomainName:       s.DomainName,
+		DNSZoneID:        s.DNSZoneID,
+		UseSpotInstances: s.UseSpotInstances,
+		LaunchedOnDemand: s.LaunchedOnDemand,
+		SpotMaxPrice:     s.SpotMaxPrice,
+	}
+}
+
+// CostEstimate is the approximate cost in USD of running a cluster, broken
+// down by the resources which are charged for.
+type CostEstimate struct {
+	Items   []*CostItem `json:"items"`
+	Hourly  float64     `json:"hourly"`
+	Monthly float64     `json:"monthly"`
+}
+
+// CostItem is the cost of Quantity of one kind of resource of a cluster.
+type CostItem struct {
+	Description string  `json:"description"`
+	Quantity    int     `json:"quantity"`
+	Hourly      float64 `json:"hourly"`
+	Monthly     float64 `json:"monthly"`
+}
+
+func (e *CostEstimate) add(description string, quantity int, hourly, monthly float64) {
+	if hourly == 0 {
+		hourly = monthly / hoursPerMonth
+	} else if monthly == 0 {
+		monthly = hourly * hoursPerMonth
+	}
+	e.Items = append(e.Items, &CostItem{
+		Description: description,
+		Quantity:    quantity,
+		Hourly:      hourly,
+		Monthly:     monthly,
+	})
+	e.Hourly += hourly
+	e.Monthly += monthly
+}
+
+// EstimateCost estimates the cost of the cluster's instances, their volumes
+// and its Route53 hosted zone and health checks from the prices of Pricing.
+// The stack has no NAT gateway or load balancer, the instances are reached
+// directly through the VPC's internet gateway, which is free.
+func (s *Stack) EstimateCost() (*CostEstimate, error) {
+	volumeSize := s.VolumeSize
+	if volumeSize == 0 {
+		volumeSize = defaultVolumeSize
+	}
+	n := float64(s.NumInstances)
+
+	instance, err := Pricing.InstanceHourlyPrice(s.Region, s.InstanceType)
+	if err != nil {
+		return nil, err
+	}
+	volume, err := Pricing.VolumeMonthlyPricePerGB(s.Region)
+	if err != nil {
+		return nil, err
+	}
+	healthCheck, err := Pricing.HealthCheckMonthlyPrice(s.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	// spot instances cost at most the on-demand price or SpotMaxPrice
+	desc := fmt.Sprintf("%s instances", s.InstanceType)
+	if s.spotInstances() {
+		desc = fmt.Sprintf("%s spot instances (at most)", s.InstanceType)
+		if max, err := strconv.ParseFloat(s.SpotMaxPrice, 64); err == nil && max < instance {
+			instance = max
+		}
+	}
+
+	e := &CostEstimate{}
+	e.add(desc, s.NumInstances, instance*n, 0)
+	e.add(fmt.Sprintf("%dGB EBS volumes", volumeSize), s.NumInstances, 0, volume*float64(volumeSize)*n)
+	// an existing zone given for a custom domain is already paid for
+	if s.externalDNSZoneID() == "" {
+		zone, err := Pricing.HostedZoneMonthlyPrice(s.Region)
+		if err != nil {
+			return nil, err
+		}
+		e.add("Route53 hosted zone", 1, 0, zone)
+	}
+	e.add("Route53 health checks", s.NumInstances, 0, healthCheck*n)
+	return e, nil
+}
+
+// EstimateClusterCost estimates the cost of a cluster before or after it is
+// launched, c is either the *Stack of the cluster or the launch input of
+// one, which is given the same defaults as an install.
+func (api *httpAPI) EstimateClusterCost(c interface{}) (*CostEstimate, error) {
+	var s *Stack
+	switch c := c.(type) {
+	case *Stack:
+		s = c
+	case *jsonInput:
+		s = &Stack{
+			Region:           c.Region,
+			InstanceType:     c.InstanceType,
+			NumInstances:     c.NumInstances,
+			VolumeSize:       c.VolumeSize,
+			DomainName:       c.DomainName,
+			DNSZoneID:        c.DNSZoneID,
+			UseSpotInstances: c.UseSpotInstances,
+			SpotMaxPrice:     c.SpotMaxPrice,
+		}
+		s.setDefaults()
+		if err := validateNumInstances(s.NumInstances); err != nil {
+			return nil, err
+		}
+		if err := validateRegion(s.Region); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Unable to estimate the cost of a %T", c)
+	}
+	return s.EstimateCost()
+}
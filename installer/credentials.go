@@ -0,0 +1,459 @@
+package installer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/aws"
+)
+
+// AWSEnvCredentialsID is the ID used to refer to the credentials found in
+// the environment.
+const AWSEnvCredentialsID = "aws_env"
+
+// Types of stored credentials, an empty type being CredentialsTypeStatic.
+const (
+	CredentialsTypeStatic     = "static"
+	CredentialsTypeAssumeRole = "assume_role"
+)
+
+type AWSCredentials struct {
+	Type   string `json:"type,omitempty"`
+	Name   string `json:"name,omitempty"`
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+
+	// RoleARN, ExternalID and SourceID are set for assume_role credentials,
+	// which assume the role with the credentials with ID SourceID, or those
+	// in the environment if it is empty.
+	RoleARN    string `json:"role_arn,omitempty"`
+	ExternalID string `json:"external_id,omitempty"`
+	SourceID   string `json:"source_id,omitempty"`
+
+	// Token and Expiry are set for temporary credentials, e.g. those
+	// returned by AssumeRole.
+	Token  string     `json:"token,omitempty"`
+	Expiry *time.Time `json:"expiry,omitempty"`
+}
+
+var ErrCredentialsExpired = errors.New("installer: credentials have expired")
+
+// expiringCredentials is a provider of temporary credentials.
+type expiringCredentials struct {
+	aws.CredentialsProvider
+	expiry time.Time
+}
+
+// credentialsExpiry returns when the credentials from the given provider
+// expire, or the zero time if they don't.
+func credentialsExpiry(p aws.CredentialsProvider) time.Time {
+	if c, ok := p.(*expiringCredentials); ok {
+		return c.expiry
+	}
+	return time.Time{}
+}
+
+// envCredentials returns the credentials from the environment, with the
+// expiry from AWS_CREDENTIAL_EXPIRATION if set.
+func envCredentials() (aws.CredentialsProvider, error) {
+	creds, err := aws.EnvCreds()
+	if err != nil {
+		return nil, err
+	}
+	exp := os.Getenv("AWS_CREDENTIAL_EXPIRATION")
+	if exp == "" {
+		return creds, nil
+	}
+	expiry, err := time.Parse(time.RFC3339, exp)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid AWS_CREDENTIAL_EXPIRATION: %s", err)
+	}
+	if !expiry.After(time.Now()) {
+		return nil, ErrCredentialsExpired
+	}
+	return &expiringCredentials{CredentialsProvider: creds, expiry: expiry}, nil
+}
+
+var credentialsMtx sync.Mutex
+
+func loadCredentials() ([]*AWSCredentials, error) {
+	file, err := os.Open(credentialsPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var creds []*AWSCredentials
+	if err := json.NewDecoder(file).Decode(&creds); err != nil {
+		return nil, err
+	}
+
+	// decrypt the secrets, upgrading any stored in plaintext if a
+	// passphrase is now set, or encrypted with another salt so the file
+	// keeps to a single salt and derived key
+	passphrase := credentialsPassphrase()
+	upgrade := false
+	for _, c := range creds {
+		for _, secret := range []*string{&c.Secret, &c.Token} {
+			if isEncryptedSecret(*secret) {
+				encrypted := *secret
+				if *secret, err = decryptSecret(passphrase, encrypted); err != nil {
+					return nil, err
+				}
+				if staleSecret(passphrase, encrypted) {
+					upgrade = true
+				}
+			} else if passphrase != "" && *secret != "" {
+				upgrade = true
+			}
+		}
+	}
+	if upgrade {
+		if err := persistCredentials(creds); err != nil {
+			return nil, err
+		}
+	}
+	return creds, nil
+}
+
+// persistCredentials stores the credentials, encrypting their secrets if
+// the CredentialsKeyEnv passphrase is set.
+func persistCredentials(creds []*AWSCredentials) error {
+	if passphrase := credentialsPassphrase(); passphrase != "" {
+		encrypted := make([]*AWSCredentials, len(creds))
+		for i, c := range creds {
+			e := *c
+			for _, secret := range []*string{&e.Secret, &e.Token} {
+				if *secret == "" {
+					continue
+				}
+				var err error
+				if *secret, err = encryptSecret(passphrase, *secret); err != nil {
+					return err
+				}
+			}
+			encrypted[i] = &e
+		}
+		creds = encrypted
+	}
+
+	if err := os.MkdirAll(filepath.Dir(credentialsPath), 0755); err != nil {
+		return err
+	}
+	return writeJSONFile(credentialsPath, creds, 0600)
+}
+
+// SaveAWSCredentials stores the given credentials, replacing any existing
+// credentials with the same ID.
+func SaveAWSCredentials(name, id, secret string) error {
+	credentialsMtx.Lock()
+	defer credentialsMtx.Unlock()
+
+	creds, err := loadCredentials()
+	if err != nil {
+		return err
+	}
+	c := &AWSCredentials{Name: name, ID: id, Secret: secret}
+	for i, existing := range creds {
+		if existing.ID == id {
+			creds[i] = c
+			return persistCredentials(creds)
+		}
+	}
+	return persistCredentials(append(creds, c))
+}
+
+// SaveAWSRoleCredentials stores credentials which assume the IAM role with
+// the given ARN, replacing any existing credentials with the same ID. The
+// role is assumed with the credentials with ID sourceID, defaulting to the
+// environment credentials, passing externalID if it is set.
+func SaveAWSRoleCredentials(name, id, roleARN, externalID, sourceID string) error {
+	if id == "" || id == AWSEnvCredentialsID {
+		return fmt.Errorf("Invalid credentials ID %q", id)
+	}
+	if err := validateRoleARN(roleARN); err != nil {
+		return err
+	}
+	if sourceID == id {
+		return fmt.Errorf("Credentials %s can't be their own source", id)
+	}
+
+	credentialsMtx.Lock()
+	defer credentialsMtx.Unlock()
+
+	creds, err := loadCredentials()
+	if err != nil {
+		return err
+	}
+	c := &AWSCredentials{
+		Type:       CredentialsTypeAssumeRole,
+		Name:       name,
+		ID:         id,
+		RoleARN:    roleARN,
+		ExternalID: externalID,
+		SourceID:   sourceID,
+	}
+	for i, existing := range creds {
+		if existing.ID == id {
+			creds[i] = c
+			return persistCredentials(creds)
+		}
+	}
+	return persistCredentials(append(creds, c))
+}
+
+// ListAWSCredentials returns the sorted IDs of the stored credentials,
+// including AWSEnvCredentialsID if there are credentials in the environment.
+// Secrets aren't decrypted so it works without the passphrase.
+func ListAWSCredentials() ([]string, error) {
+	credentialsMtx.Lock()
+	defer credentialsMtx.Unlock()
+
+	file, err := os.Open(credentialsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var creds []*AWSCredentials
+	if err == nil {
+		defer file.Close()
+		if err := json.NewDecoder(file).Decode(&creds); err != nil {
+			return nil, err
+		}
+	}
+	ids := make([]string, 0, len(creds)+1)
+	for _, c := range creds {
+		ids = append(ids, c.ID)
+	}
+	if _, err := aws.EnvCreds(); err == nil {
+		ids = append(ids, AWSEnvCredentialsID)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+var ErrEnvCredentials = errors.New("installer: the environment credentials can't be deleted")
+
+// CredentialInUseError is returned when deleting credentials which clusters
+// were launched with.
+type CredentialInUseError struct {
+	ID       string
+	Clusters []string
+}
+
+func (e *CredentialInUseError) Error() string {
+	return fmt.Sprintf("Credentials %s are in use by clusters %s", e.ID, strings.Join(e.Clusters, ", "))
+}
+
+// DeleteAWSCredentials deletes the stored credentials with the given ID,
+// returning a *CredentialInUseError if a cluster which hasn't been deleted
+// was launched with them.
+func (api *httpAPI) DeleteAWSCredentials(id string) error {
+	if id == AWSEnvCredentialsID {
+		return ErrEnvCredentials
+	}
+	stacks, err := api.ListClusters()
+	if err != nil {
+		return err
+	}
+	var inUse []string
+	for _, s := range stacks {
+		if s.CredentialID == id && s.State != StateDeleted {
+			inUse = append(inUse, s.ID)
+		}
+	}
+	if len(inUse) > 0 {
+		return &CredentialInUseError{ID: id, Clusters: inUse}
+	}
+
+	credentialsMtx.Lock()
+	defer credentialsMtx.Unlock()
+
+	creds, err := loadCredentials()
+	if err != nil {
+		return err
+	}
+	for i, c := range creds {
+		if c.ID == id {
+			return persistCredentials(append(creds[:i], creds[i+1:]...))
+		}
+	}
+	return fmt.Errorf("No credentials found with ID %s", id)
+}
+
+// FindAWSCredentials returns a provider for the stored credentials with the
+// given ID, or for the environment credentials if the ID is "aws_env". It
+// returns ErrCredentialsExpired for temporary credentials which have expired.
+// The provider of assume_role credentials assumes the role when credentials
+// are first requested and again whenever they are about to expire.
+func FindAWSCredentials(id string) (aws.CredentialsProvider, error) {
+	if id == AWSEnvCredentialsID {
+		return envCredentials()
+	}
+
+	credentialsMtx.Lock()
+	defer credentialsMtx.Unlock()
+
+	creds, err := loadCredentials()
+	if err != nil {
+		return nil, err
+	}
+	return findCredentials(creds, id, nil)
+}
+
+// findCredentials returns a provider for the credentials with the given ID,
+// seen holding the IDs of the assume_role credentials whose source is being
+// looked up, to refuse a cycle of sources.
+func findCredentials(creds []*AWSCredentials, id string, seen map[string]bool) (aws.CredentialsProvider, error) {
+	if id == AWSEnvCredentialsID {
+		return envCredentials()
+	}
+	for _, c := range creds {
+		if c.ID != id {
+			continue
+		}
+		switch c.Type {
+		case "", CredentialsTypeStatic:
+		case CredentialsTypeAssumeRole:
+			if seen[c.ID] {
+				return nil, fmt.Errorf("Credentials %s are their own source", c.ID)
+			}
+			if seen == nil {
+				seen = make(map[string]bool)
+			}
+			seen[c.ID] = true
+			sourceID := c.SourceID
+			if sourceID == "" {
+				sourceID = AWSEnvCredentialsID
+			}
+			source, err := findCredentials(creds, sourceID, seen)
+			if err != nil {
+				return nil, fmt.Errorf("Source credentials of %s: %s", c.ID, err)
+			}
+			return newAssumeRoleProvider(source, c.RoleARN, c.ExternalID), nil
+		default:
+			return nil, fmt.Errorf("Credentials %s have unknown type %q", c.ID, c.Type)
+		}
+		creds := aws.Creds(c.ID, c.Secret, c.Token)
+		if c.Expiry == nil {
+			return creds, nil
+		}
+		if !c.Expiry.After(time.Now()) {
+			return nil, ErrCredentialsExpired
+		}
+		return &expiringCredentials{CredentialsProvider: creds, expiry: *c.Expiry}, nil
+	}
+	return nil, fmt.Errorf("No credentials found with ID %s", id)
+}
+
+// ImportError lists the entries which were skipped during an import.
+type ImportError struct {
+	Errors []string
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("%d invalid entries: %s", len(e.Errors), strings.Join(e.Errors, "; "))
+}
+
+// ImportCredentials reads a list of credentials, either as a JSON array of
+// objects with name, id and secret keys, or with the type assume_role and
+// role_arn, external_id and source_id keys, or as CSV rows of name, access
+// key ID and secret access key, and stores those which don't already exist. It
+// returns the number of credentials added. Invalid entries are skipped and
+// reported with an *ImportError.
+func ImportCredentials(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	var entries []*AWSCredentials
+	var importErr ImportError
+	if peek, err := nextByte(br); err == nil && peek == '[' {
+		if err := json.NewDecoder(br).Decode(&entries); err != nil {
+			return 0, err
+		}
+	} else {
+		reader := csv.NewReader(br)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		rows, err := reader.ReadAll()
+		if err != nil {
+			return 0, err
+		}
+		for i, row := range rows {
+			if i == 0 && len(row) > 1 && strings.EqualFold(row[1], "access_key_id") {
+				continue
+			}
+			if len(row) != 3 {
+				importErr.Errors = append(importErr.Errors, fmt.Sprintf("line %d: expected 3 fields, got %d", i+1, len(row)))
+				continue
+			}
+			entries = append(entries, &AWSCredentials{Name: row[0], ID: row[1], Secret: row[2]})
+		}
+	}
+
+	credentialsMtx.Lock()
+	defer credentialsMtx.Unlock()
+
+	creds, err := loadCredentials()
+	if err != nil {
+		return 0, err
+	}
+	existing := make(map[string]struct{}, len(creds))
+	for _, c := range creds {
+		existing[c.ID] = struct{}{}
+	}
+	added := 0
+	for i, c := range entries {
+		if c != nil && c.Type == CredentialsTypeAssumeRole {
+			if err := validateRoleARN(c.RoleARN); c.ID == "" || err != nil {
+				importErr.Errors = append(importErr.Errors, fmt.Sprintf("entry %d: missing id or invalid role_arn", i+1))
+				continue
+			}
+		} else if c == nil || c.ID == "" || c.Secret == "" {
+			importErr.Errors = append(importErr.Errors, fmt.Sprintf("entry %d: missing id or secret", i+1))
+			continue
+		}
+		if c.ID == AWSEnvCredentialsID {
+			importErr.Errors = append(importErr.Errors, fmt.Sprintf("entry %d: %s is reserved", i+1, AWSEnvCredentialsID))
+			continue
+		}
+		if _, ok := existing[c.ID]; ok {
+			continue
+		}
+		existing[c.ID] = struct{}{}
+		creds = append(creds, c)
+		added++
+	}
+	if added > 0 {
+		if err := persistCredentials(creds); err != nil {
+			return 0, err
+		}
+	}
+	if len(importErr.Errors) > 0 {
+		return added, &importErr
+	}
+	return added, nil
+}
+
+// nextByte returns the first non-whitespace byte without consuming it.
+func nextByte(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.ContainsAny(b, " \t\r\n") {
+			return b[0], nil
+		}
+		r.ReadByte()
+	}
+}
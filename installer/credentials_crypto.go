@@ -0,0 +1,202 @@
+package installer
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// CredentialsKeyEnv is the environment variable holding the passphrase the
+// stored credential secrets are encrypted with. If it isn't set secrets are
+// stored in plaintext.
+const CredentialsKeyEnv = "FLYNN_INSTALLER_KEY"
+
+var (
+	ErrCredentialsKeyMissing = errors.New("installer: stored credentials are encrypted but " + CredentialsKeyEnv + " is not set")
+	ErrCredentialsKeyInvalid = errors.New("installer: unable to decrypt stored credentials, " + CredentialsKeyEnv + " is incorrect")
+)
+
+// encryptedSecretPrefix marks an encrypted secret, which is followed by the
+// base64 encoded salt, nonce and AES-GCM ciphertext.
+const encryptedSecretPrefix = "encrypted:v1:"
+
+const (
+	credentialsSaltSize  = 16
+	credentialsKeyRounds = 100000
+	credentialsKeySize   = 32
+)
+
+// credentialsKey is a key derived from the passphrase and a salt.
+type credentialsKey struct {
+	salt []byte
+	key  []byte
+
+	// check is an HMAC of the passphrase, so whether the passphrase has
+	// changed can be told without keeping it
+	check []byte
+}
+
+var (
+	// currentKey is the key secrets are encrypted with, which is derived
+	// once per passphrase as deriving it is deliberately slow, so all the
+	// secrets saved with a passphrase share its salt.
+	currentKey    *credentialsKey
+	currentKeyMtx sync.Mutex
+)
+
+func credentialsPassphrase() string {
+	return os.Getenv(CredentialsKeyEnv)
+}
+
+func isEncryptedSecret(s string) bool {
+	return strings.HasPrefix(s, encryptedSecretPrefix)
+}
+
+func encryptSecret(passphrase, secret string) (string, error) {
+	k, err := credentialsKeyFor(passphrase, nil)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := k.cipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	data := append(append([]byte{}, k.salt...), nonce...)
+	data = gcm.Seal(data, nonce, []byte(secret), nil)
+	return encryptedSecretPrefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+func decryptSecret(passphrase, secret string) (string, error) {
+	if passphrase == "" {
+		return "", ErrCredentialsKeyMissing
+	}
+	salt, data := splitSecret(secret)
+	if salt == nil {
+		return "", ErrCredentialsKeyInvalid
+	}
+	k, err := credentialsKeyFor(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := k.cipher()
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", ErrCredentialsKeyInvalid
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", ErrCredentialsKeyInvalid
+	}
+	return string(plaintext), nil
+}
+
+// staleSecret reports whether the encrypted secret has a salt other than
+// the current key's, so should be encrypted again when next saved.
+func staleSecret(passphrase, secret string) bool {
+	salt, _ := splitSecret(secret)
+	currentKeyMtx.Lock()
+	defer currentKeyMtx.Unlock()
+	return !currentKey.matches(passphrase, salt)
+}
+
+// splitSecret returns the salt and the nonce and ciphertext of an encrypted
+// secret, or a nil salt if the secret is malformed.
+func splitSecret(secret string) ([]byte, []byte) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, encryptedSecretPrefix))
+	if err != nil || len(data) < credentialsSaltSize {
+		return nil, nil
+	}
+	return data[:credentialsSaltSize], data[credentialsSaltSize:]
+}
+
+// credentialsKeyFor returns the key for the passphrase and salt, or the
+// current key if salt is nil. A key for a new passphrase, or for the first
+// secret decrypted, replaces the current key.
+func credentialsKeyFor(passphrase string, salt []byte) (*credentialsKey, error) {
+	currentKeyMtx.Lock()
+	defer currentKeyMtx.Unlock()
+	if currentKey.matches(passphrase, salt) {
+		return currentKey, nil
+	}
+	if salt == nil {
+		salt = make([]byte, credentialsSaltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return nil, err
+		}
+	}
+	k := &credentialsKey{salt: salt, key: pbkdf2SHA256([]byte(passphrase), salt, credentialsKeyRounds, credentialsKeySize)}
+	k.check = k.passphraseCheck(passphrase)
+	if !currentKey.matches(passphrase, nil) {
+		currentKey = k
+	}
+	return k, nil
+}
+
+// matches reports whether k was derived from the passphrase and, unless it
+// is nil, the salt.
+func (k *credentialsKey) matches(passphrase string, salt []byte) bool {
+	if k == nil || salt != nil && !bytes.Equal(k.salt, salt) {
+		return false
+	}
+	return hmac.Equal(k.check, k.passphraseCheck(passphrase))
+}
+
+func (k *credentialsKey) passphraseCheck(passphrase string) []byte {
+	mac := hmac.New(sha256.New, k.key)
+	mac.Write([]byte(passphrase))
+	return mac.Sum(nil)
+}
+
+func (k *credentialsKey) cipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2SHA256 implements PBKDF2 (RFC 2898) with HMAC-SHA256. It derives
+// both the credentials keys and the backup keys.
+func pbkdf2SHA256(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+
+	var buf [4]byte
+	key := make([]byte, 0, blocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], uint32(block))
+		prf.Write(buf[:])
+		t := prf.Sum(nil)
+		copy(u, t)
+		for n := 1; n < iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
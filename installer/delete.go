@@ -0,0 +1,227 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/aws"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/cloudformation"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/ec2"
+)
+
+var ErrClusterNotFound = errors.New("installer: cluster not found")
+
+// DeleteCluster deletes the install with the given ID, cancelling it if it
+// is still running. The volumes of its instances are first snapshotted if
+// SnapshotBeforeDelete is set, then the stack is deleted and, once it is
+// gone, the files kept for the cluster are removed. If the stack can't be
+// deleted the cluster is left in the error state with its files intact and a
+// cluster_delete_failed event is sent. Clusters installed before the
+// installer was restarted are loaded from disk. ErrClusterBusy is returned
+// if the cluster is being changed by another operation.
+func (api *httpAPI) DeleteCluster(id string) error {
+	inst, err := api.savedInstaller(id)
+	if err != nil {
+		return err
+	}
+	s := inst.Stack
+	if err := s.beginOperation(); err != nil {
+		return err
+	}
+	defer s.endOperation()
+
+	if state := s.currentState(); state == StateProvisioning || state == StateBootstrapping {
+		s.cancelInstall()
+		<-s.doneChan()
+	}
+	if err := s.setState(StateDeleting); err != nil {
+		return err
+	}
+	// forward the stack events of the teardown now that the install is over
+	s.resetCancel()
+	defer inst.pumpEvents()()
+
+	inst.sendEvent(&httpEvent{
+		Type:        "cluster_deleting",
+		Description: id,
+	})
+	if err := api.teardown(inst); err != nil {
+		s.setState(StateError)
+		s.ErrorReason = fmt.Sprintf("Failed to delete cluster: %s", err)
+		s.persist()
+		inst.sendEvent(&httpEvent{
+			Type:        "cluster_delete_failed",
+			Description: err.Error(),
+		})
+		return err
+	}
+
+	s.setState(StateDeleted)
+	api.InstallerStackMtx.Lock()
+	delete(api.InstallerStacks, id)
+	api.InstallerStackMtx.Unlock()
+	if err := purgeCluster(id); err != nil {
+		inst.logger.Error("error removing cluster files", "err", err)
+	}
+	inst.sendEvent(&httpEvent{
+		Type:        "cluster_deleted",
+		Description: id,
+	})
+	return nil
+}
+
+func (api *httpAPI) teardown(inst *httpInstaller) error {
+	s := inst.Stack
+	if s.StackID == "" {
+		if err := s.cleanupStrayInstances(); err != nil {
+			return err
+		}
+		if err := s.deleteDNSZone(); err != nil {
+			return err
+		}
+		return s.cleanupKeyPair()
+	}
+	if s.SnapshotBeforeDelete {
+		ids, err := s.snapshotVolumes()
+		if err != nil {
+			return err
+		}
+		inst.sendEvent(&httpEvent{
+			Type:        "snapshot_created",
+			Description: strings.Join(ids, ","),
+		})
+	}
+	if err := s.deleteStack(); err != nil {
+		return err
+	}
+	if err := s.cleanupStrayInstances(); err != nil {
+		return err
+	}
+	if err := s.deleteDNSZone(); err != nil {
+		return err
+	}
+	return s.cleanupKeyPair()
+}
+
+// cleanupStrayInstances terminates instances tagged with the cluster's ID
+// which outlived its stack, if there is an EC2 client to find them with.
+func (s *Stack) cleanupStrayInstances() error {
+	if s.ec2 == nil {
+		return nil
+	}
+	return s.terminateStrayInstances()
+}
+
+// deleteStack deletes the CloudFormation stack and waits for it to be gone.
+// The records of the cluster's domain aren't part of the stack, so are
+// deleted first.
+func (s *Stack) deleteStack() error {
+	if s.cf == nil {
+		return errors.New("No CloudFormation client for the stack")
+	}
+	res, err := s.cf.DescribeStacks(&cloudformation.DescribeStacksInput{StackName: aws.String(s.StackID)})
+	if err != nil {
+		return err
+	}
+	if len(res.Stacks) == 0 || res.Stacks[0].StackStatus == nil || *res.Stacks[0].StackStatus == "DELETE_COMPLETE" {
+		return nil
+	}
+	if err := s.deleteDNSRecords(); err != nil {
+		return err
+	}
+	s.SendEvent(fmt.Sprintf("Deleting stack %s", s.StackName))
+	since := time.Now()
+	if err := s.cf.DeleteStack(&cloudformation.DeleteStackInput{StackName: aws.String(s.StackID)}); err != nil {
+		return err
+	}
+	if err := s.waitForStackCompletion("DELETE", since); err != nil {
+		return fmt.Errorf("Failed to delete stack %s", s.StackName)
+	}
+	return nil
+}
+
+// purgeCluster removes the files kept for the cluster with the given ID.
+func purgeCluster(id string) error {
+	defer clusterCache.Invalidate(id)
+	for _, path := range []string{
+		filepath.Join(clustersDir, filepath.Base(id)+".json"),
+		eventsPath(id),
+		clusterLogPath(id),
+		caCertPath(id),
+		clusterSSHKeyPath(id),
+	} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// the data file of an older installer may also hold the cluster
+	saved := &Stack{}
+	if err := saved.load(); err == nil && saved.ID == id {
+		if err := os.Remove(dataPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// stackInstances returns the EC2 instances created by the stack.
+func (s *Stack) stackInstances() ([]ec2.Instance, error) {
+	res, err := s.ec2.DescribeInstances(&ec2.DescribeInstancesRequest{
+		Filters: []ec2.Filter{
+			{
+				Name:   aws.String("tag:aws:cloudformation:stack-id"),
+				Values: []string{s.StackID},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var instances []ec2.Instance
+	for _, r := range res.Reservations {
+		instances = append(instances, r.Instances...)
+	}
+	return instances, nil
+}
+
+// snapshotVolumes creates a snapshot of every EBS volume attached to the
+// stack's instances and returns their IDs. The snapshots aren't part of the
+// stack so they survive it being deleted.
+func (s *Stack) snapshotVolumes() ([]string, error) {
+	instances, err := s.stackInstances()
+	if err != nil {
+		return nil, err
+	}
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+	var ids []string
+	for _, i := range instances {
+		for _, m := range i.BlockDeviceMappings {
+			if m.EBS == nil || m.EBS.VolumeID == nil {
+				continue
+			}
+			snapshot, err := s.ec2.CreateSnapshot(&ec2.CreateSnapshotRequest{
+				VolumeID:    m.EBS.VolumeID,
+				Description: aws.String(fmt.Sprintf("flynn cluster %s volume %s", s.ID, *m.EBS.VolumeID)),
+			})
+			if err != nil {
+				return ids, err
+			}
+			ids = append(ids, *snapshot.SnapshotID)
+			if err := s.ec2.CreateTags(&ec2.CreateTagsRequest{
+				Resources: []string{*snapshot.SnapshotID},
+				Tags: []ec2.Tag{
+					{Key: aws.String(ClusterIDTag), Value: aws.String(s.ID)},
+					{Key: aws.String("flynn-snapshot-time"), Value: aws.String(timestamp)},
+				},
+			}); err != nil {
+				return ids, err
+			}
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,90 @@
+package installer
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/golang.org/x/crypto/ssh"
+)
+
+// ImageRepositoryURL is where flynn-host downloads the images of the
+// bootstrapped components from.
+var ImageRepositoryURL = "https://dl.flynn.io"
+
+// dependencyEndpoint is an external service the instances must be able to
+// reach for the bootstrap to succeed.
+type dependencyEndpoint struct {
+	Name string
+	URL  string
+}
+
+func (e dependencyEndpoint) String() string {
+	return fmt.Sprintf("%s (%s)", e.Name, e.URL)
+}
+
+// ErrDependencyUnreachable is returned when the instances can't reach
+// external services the bootstrap depends on, usually because of a
+// firewall or a missing proxy.
+type ErrDependencyUnreachable struct {
+	Endpoints []string
+}
+
+func (e *ErrDependencyUnreachable) Error() string {
+	return "Unable to reach bootstrap dependencies from the instances: " + strings.Join(e.Endpoints, ", ")
+}
+
+// dependencyEndpoints returns the external services used by the bootstrap:
+// the image repository and the etcd discovery service. Only the scheme and
+// host of the discovery token are used as the token itself is a secret.
+func (s *Stack) dependencyEndpoints() []dependencyEndpoint {
+	endpoints := []dependencyEndpoint{{"image repository", ImageRepositoryURL}}
+	if u, err := url.Parse(s.DiscoveryToken); err == nil && u.Host != "" {
+		endpoints = append(endpoints, dependencyEndpoint{"discovery service", u.Scheme + "://" + u.Host})
+	}
+	return endpoints
+}
+
+// checkDependencies verifies that the first instance can reach the external
+// services the bootstrap depends on, sending a dependency_unreachable event
+// for each one which can't be reached and dependencies_ok if they all can.
+func (s *Stack) checkDependencies() error {
+	s.SendEvent("Checking connectivity to bootstrap dependencies")
+	sshConfig, err := s.sshConfig()
+	if err != nil {
+		return err
+	}
+	from := s.InstanceIPs[0]
+	conn, err := ssh.Dial("tcp", from+":22", sshConfig)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var unreachable []string
+	for _, e := range s.dependencyEndpoints() {
+		if err := probeURL(conn, e.URL); err != nil {
+			unreachable = append(unreachable, e.String())
+			s.sendTypedEvent("dependency_unreachable", fmt.Sprintf("%s can't reach the %s at %s, check the network's firewall and proxy settings", instanceName(0), e.Name, e.URL), map[string]string{
+				"dependency": e.Name,
+				"url":        e.URL,
+			})
+		}
+	}
+	if len(unreachable) > 0 {
+		return &ErrDependencyUnreachable{Endpoints: unreachable}
+	}
+	s.sendTypedEvent("dependencies_ok", "All bootstrap dependencies are reachable", nil)
+	return nil
+}
+
+// probeURL makes a request to the URL from the remote end of the SSH
+// connection, any HTTP response means it is reachable.
+func probeURL(conn *ssh.Client, u string) error {
+	sess, err := conn.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+	return sess.Run(fmt.Sprintf("curl -sS -o /dev/null --max-time 10 %s", shellQuote(u)))
+}
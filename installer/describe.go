@@ -0,0 +1,156 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+)
+
+// describeEventsLimit is the number of recent events included in a cluster
+// description.
+const describeEventsLimit = 20
+
+// ClusterDescription is everything known about a cluster. Sections whose
+// source couldn't be reached are left empty and the reason is recorded in
+// Errors, keyed by the section's JSON name.
+type ClusterDescription struct {
+	ID          string                 `json:"id"`
+	State       string                 `json:"state,omitempty"`
+	ErrorReason string                 `json:"error_reason,omitempty"`
+	Spec        map[string]interface{} `json:"spec"`
+	Instances   []*InstanceDescription `json:"instances,omitempty"`
+	Domain      string                 `json:"domain,omitempty"`
+
+	// CACert and ControllerPin identify the cluster's controller, neither
+	// of them is a secret.
+	CACert        string `json:"ca_cert,omitempty"`
+	ControllerPin string `json:"controller_pin,omitempty"`
+
+	MonthlyCost  float64           `json:"monthly_cost,omitempty"`
+	Timeline     []*PhaseTiming    `json:"timeline,omitempty"`
+	RecentEvents []*httpEvent      `json:"recent_events,omitempty"`
+	Errors       map[string]string `json:"errors,omitempty"`
+}
+
+type InstanceDescription struct {
+	Name       string `json:"name"`
+	IP         string `json:"ip,omitempty"`
+	InstanceID string `json:"instance_id,omitempty"`
+	State      string `json:"state,omitempty"`
+}
+
+// DescribeCluster returns a description of the cluster with the given ID
+// from its persisted spec and events. The instances are looked up in EC2 if
+// the install is running in this process, otherwise they are only listed by
+// IP. Secrets are excluded.
+func (api *httpAPI) DescribeCluster(id string) (*ClusterDescription, error) {
+	s, err := api.FindCluster(id)
+	if os.IsNotExist(err) {
+		return nil, ErrClusterNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	d := &ClusterDescription{
+		ID:            id,
+		State:         s.State,
+		ErrorReason:   s.ErrorReason,
+		CACert:        s.CACert,
+		ControllerPin: s.ControllerPin,
+		Timeline:      s.Timeline,
+		Errors:        make(map[string]string),
+	}
+	if s.Domain != nil {
+		d.Domain = s.Domain.Name
+	}
+	if d.Spec, err = redactedSpec(s); err != nil {
+		return nil, err
+	}
+	if d.MonthlyCost, err = EstimateMonthlyCost(s.InstanceType, s.NumInstances); err != nil {
+		d.Errors["monthly_cost"] = err.Error()
+	}
+
+	api.InstallerStackMtx.RLock()
+	inst := api.InstallerStacks[id]
+	api.InstallerStackMtx.RUnlock()
+
+	d.Instances = describeInstances(s)
+	if inst != nil && s.StackID != "" && s.ec2 != nil {
+		if err := s.describeLiveInstances(d.Instances); err != nil {
+			d.Errors["instances"] = err.Error()
+		}
+	}
+
+	var events []*httpEvent
+	if inst != nil {
+		inst.eventsMtx.Lock()
+		events = append(events, inst.events...)
+		inst.eventsMtx.Unlock()
+	} else if events, err = loadEvents(id); err != nil {
+		d.Errors["recent_events"] = err.Error()
+	}
+	if len(events) > describeEventsLimit {
+		events = events[len(events)-describeEventsLimit:]
+	}
+	redactor := newRedactor(s.secrets())
+	for _, e := range events {
+		if e.Type == "dashboard_login_token" {
+			continue
+		}
+		redacted := *e
+		redacted.Description = redactor.Replace(e.Description)
+		d.RecentEvents = append(d.RecentEvents, &redacted)
+	}
+
+	if len(d.Errors) == 0 {
+		d.Errors = nil
+	}
+	return d, nil
+}
+
+func describeInstances(s *Stack) []*InstanceDescription {
+	instances := make([]*InstanceDescription, s.NumInstances)
+	for i := range instances {
+		instances[i] = &InstanceDescription{Name: instanceName(i)}
+		if i < len(s.InstanceIPs) {
+			instances[i].IP = s.InstanceIPs[i]
+		}
+	}
+	return instances
+}
+
+// describeLiveInstances fills in the EC2 instance ID, state and IP address
+// of each of the stack's instances. The instances are matched by their
+// logical IDs, so their live IP addresses replace the saved ones, which
+// older installers saved in the order of the stack outputs rather than of
+// the instances.
+func (s *Stack) describeLiveInstances(instances []*InstanceDescription) error {
+	live, err := s.stackInstances()
+	if err != nil {
+		return err
+	}
+	if len(live) == 0 {
+		return fmt.Errorf("No instances found for stack %s", s.StackName)
+	}
+	for _, l := range live {
+		for _, t := range l.Tags {
+			if t.Key == nil || *t.Key != "aws:cloudformation:logical-id" || t.Value == nil {
+				continue
+			}
+			n := s.instanceIndex(*t.Value)
+			if n < 0 || n >= len(instances) {
+				continue
+			}
+			i := instances[n]
+			if l.InstanceID != nil {
+				i.InstanceID = *l.InstanceID
+			}
+			if l.State != nil && l.State.Name != nil {
+				i.State = *l.State.Name
+			}
+			if l.PublicIPAddress != nil {
+				i.IP = *l.PublicIPAddress
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package installer
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/aws"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/route53"
+)
+
+// DNSRecord is a record of a cluster's domain. A record with a SetIdentifier
+// is one of a set of records with the same name and type, which are served
+// in proportion to their Weight while their HealthCheckID passes.
+type DNSRecord struct {
+	Name          string
+	Type          string
+	Values        []string
+	TTL           int64
+	SetIdentifier string
+	Weight        int64
+	HealthCheckID string
+}
+
+// DNSProvider manages the records of the DNS zone a cluster domain is
+// delegated to.
+type DNSProvider interface {
+	// Nameservers returns the nameservers the zone is served from.
+	Nameservers() ([]string, error)
+
+	// EnsureRecord creates the record or replaces an existing record with
+	// the same name, type and set identifier.
+	EnsureRecord(record *DNSRecord) error
+
+	// DeleteRecord deletes the record with the name, type and set
+	// identifier of the given one, it is not an error if it doesn't exist.
+	DeleteRecord(record *DNSRecord) error
+
+	// VerifyDelegation checks that the domain is delegated to the zone's
+	// nameservers.
+	VerifyDelegation(domain string) error
+}
+
+// DNSProviderRoute53 is the default DNS provider, and the only one which
+// can serve custom domains as their hosted zones are in Route53.
+const DNSProviderRoute53 = "route53"
+
+func (s *Stack) dnsProvider() (DNSProvider, error) {
+	switch s.DNSProvider {
+	case "", DNSProviderRoute53:
+		if s.dns != nil {
+			return s.dns, nil
+		}
+		return &route53DNSProvider{
+			r53:    s.route53(),
+			zoneID: s.DNSZoneID,
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unknown DNS provider %s", s.DNSProvider)
+	}
+}
+
+func (s *Stack) route53() *route53.Route53 {
+	// Set region to us-east-1, since any other region will fail for global services like Route53
+	return route53.New(s.Creds, "us-east-1", nil)
+}
+
+type route53DNSProvider struct {
+	r53    *route53.Route53
+	zoneID string
+}
+
+func (p *route53DNSProvider) Nameservers() ([]string, error) {
+	res, err := p.r53.GetHostedZone(&route53.GetHostedZoneRequest{ID: aws.String(p.zoneID)})
+	if err != nil {
+		return nil, err
+	}
+	return res.DelegationSet.NameServers, nil
+}
+
+func (p *route53DNSProvider) EnsureRecord(record *DNSRecord) error {
+	records := make([]route53.ResourceRecord, len(record.Values))
+	for i, v := range record.Values {
+		records[i] = route53.ResourceRecord{Value: aws.String(v)}
+	}
+	set := &route53.ResourceRecordSet{
+		Name:            aws.String(fqdn(record.Name)),
+		Type:            aws.String(record.Type),
+		TTL:             aws.Long(record.TTL),
+		ResourceRecords: records,
+	}
+	if record.SetIdentifier != "" {
+		set.SetIdentifier = aws.String(record.SetIdentifier)
+		set.Weight = aws.Long(record.Weight)
+	}
+	if record.HealthCheckID != "" {
+		set.HealthCheckID = aws.String(record.HealthCheckID)
+	}
+	return p.change(route53.ChangeActionUpsert, set)
+}
+
+func (p *route53DNSProvider) DeleteRecord(record *DNSRecord) error {
+	name := fqdn(record.Name)
+	req := &route53.ListResourceRecordSetsRequest{
+		HostedZoneID:    aws.String(p.zoneID),
+		StartRecordName: aws.String(name),
+		StartRecordType: aws.String(record.Type),
+		MaxItems:        aws.String("1"),
+	}
+	if record.SetIdentifier != "" {
+		req.StartRecordIdentifier = aws.String(record.SetIdentifier)
+	}
+	res, err := p.r53.ListResourceRecordSets(req)
+	if err != nil {
+		return err
+	}
+	for _, rs := range res.ResourceRecordSets {
+		if rs.Name == nil || !strings.EqualFold(*rs.Name, name) || rs.Type == nil || *rs.Type != record.Type {
+			continue
+		}
+		if record.SetIdentifier != "" && (rs.SetIdentifier == nil || *rs.SetIdentifier != record.SetIdentifier) {
+			continue
+		}
+		// a record set is deleted by giving all of its current values
+		set := rs
+		return p.change(route53.ChangeActionDelete, &set)
+	}
+	return nil
+}
+
+func (p *route53DNSProvider) change(action string, set *route53.ResourceRecordSet) error {
+	_, err := p.r53.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsRequest{
+		HostedZoneID: aws.String(p.zoneID),
+		ChangeBatch: &route53.ChangeBatch{
+			Changes: []route53.Change{{
+				Action:            aws.String(action),
+				ResourceRecordSet: set,
+			}},
+		},
+	})
+	return err
+}
+
+func (p *route53DNSProvider) VerifyDelegation(domain string) error {
+	nameservers, err := p.Nameservers()
+	if err != nil {
+		return err
+	}
+	return verifyDelegation(domain, nameservers)
+}
+
+// dnsRecords returns the records of the cluster's domain: an A record for
+// each instance, served while the instance's health check passes, and a
+// wildcard CNAME for the cluster's apps.
+func (s *Stack) dnsRecords() []*DNSRecord {
+	records := make([]*DNSRecord, 0, len(s.InstanceIPs)+1)
+	for i, ip := range s.InstanceIPs {
+		records = append(records, &DNSRecord{
+			Name:          s.Domain.Name,
+			Type:          "A",
+			Values:        []string{ip},
+			TTL:           60,
+			SetIdentifier: fmt.Sprintf("frontend%d", i),
+			Weight:        10,
+			HealthCheckID: s.healthCheckIDs[i],
+		})
+	}
+	return append(records, &DNSRecord{
+		Name:   "*." + s.Domain.Name,
+		Type:   "CNAME",
+		Values: []string{fqdn(s.Domain.Name)},
+		TTL:    3600,
+	})
+}
+
+// ensureDNSRecords creates or updates the records of the cluster's domain
+// for its current instances.
+func (s *Stack) ensureDNSRecords() error {
+	if s.Domain == nil {
+		return nil
+	}
+	dns, err := s.dnsProvider()
+	if err != nil {
+		return err
+	}
+	for _, r := range s.dnsRecords() {
+		if err := s.retryAWS("EnsureRecord", func() error { return dns.EnsureRecord(r) }); err != nil {
+			return fmt.Errorf("Unable to create DNS record %s %s: %s", r.Type, r.Name, err)
+		}
+	}
+	return nil
+}
+
+// deleteDNSRecords deletes the records of the cluster's domain, which must
+// be done before the stack is deleted as a hosted zone with records in it
+// can't be.
+func (s *Stack) deleteDNSRecords() error {
+	if s.Domain == nil || s.DNSZoneID == "" || (s.Creds == nil && s.dns == nil) {
+		return nil
+	}
+	dns, err := s.dnsProvider()
+	if err != nil {
+		return err
+	}
+	for _, r := range s.dnsRecords() {
+		if err := s.retryAWS("DeleteRecord", func() error { return dns.DeleteRecord(r) }); err != nil {
+			return fmt.Errorf("Unable to delete DNS record %s %s: %s", r.Type, r.Name, err)
+		}
+	}
+	return nil
+}
+
+// deleteInstanceDNSRecord stops the cluster's domain resolving to the
+// instance with the given index, before it is removed.
+func (s *Stack) deleteInstanceDNSRecord(i int) error {
+	if s.Domain == nil || i >= len(s.InstanceIPs) {
+		return nil
+	}
+	dns, err := s.dnsProvider()
+	if err != nil {
+		return err
+	}
+	r := s.dnsRecords()[i]
+	if err := s.retryAWS("DeleteRecord", func() error { return dns.DeleteRecord(r) }); err != nil {
+		return fmt.Errorf("Unable to delete DNS record %s %s: %s", r.Type, r.Name, err)
+	}
+	return nil
+}
+
+func verifyDelegation(domain string, nameservers []string) error {
+	records, err := net.LookupNS(domain)
+	if err != nil {
+		return err
+	}
+	for _, ns := range nameservers {
+		for _, r := range records {
+			if strings.EqualFold(fqdn(ns), fqdn(r.Host)) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%s is not delegated to any of %s", domain, strings.Join(nameservers, ", "))
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
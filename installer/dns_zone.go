@@ -0,0 +1,192 @@
+package installer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/aws"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/route53"
+)
+
+var domainNamePattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)+[a-z][a-z0-9-]*[a-z0-9]$`)
+
+func (s *Stack) validateDomainName() error {
+	if s.DomainName == "" {
+		if s.DNSZoneID != "" {
+			return fmt.Errorf("DNSZoneID requires a DomainName")
+		}
+		return nil
+	}
+	s.DomainName = strings.TrimSuffix(strings.ToLower(s.DomainName), ".")
+	if !domainNamePattern.MatchString(s.DomainName) {
+		return fmt.Errorf("Invalid domain name %s", s.DomainName)
+	}
+	if s.DNSProvider != "" && s.DNSProvider != DNSProviderRoute53 {
+		return fmt.Errorf("A DomainName requires the %s DNS provider", DNSProviderRoute53)
+	}
+	s.DNSZoneID = hostedZoneID(s.DNSZoneID)
+	return nil
+}
+
+// externalDNSZoneID returns the ID of the hosted zone the cluster's records
+// are created in if it isn't part of the stack, which is the case for custom
+// domains.
+func (s *Stack) externalDNSZoneID() string {
+	if s.DomainName == "" {
+		return ""
+	}
+	return s.DNSZoneID
+}
+
+// hostedZoneID strips the /hostedzone/ prefix Route53 returns zone IDs with.
+func hostedZoneID(id string) string {
+	return strings.TrimPrefix(id, "/hostedzone/")
+}
+
+// ensureDNSZone finds the hosted zone the custom domain is served from:
+// either the given DNSZoneID or the account's public zone for the domain,
+// creating one if there isn't a zone. As the nameservers of the zone are
+// only known once it exists, a dns_delegation_required event lists the NS
+// records the user must add to the domain's registrar.
+func (s *Stack) ensureDNSZone() error {
+	r53 := s.route53()
+	name := fqdn(s.DomainName)
+
+	if s.DNSZoneID == "" {
+		zone, err := s.findDNSZone(r53, name)
+		if err != nil {
+			return err
+		}
+		if zone != nil {
+			s.DNSZoneID = hostedZoneID(*zone.ID)
+			s.SendEvent(fmt.Sprintf("Using existing hosted zone %s for %s", s.DNSZoneID, s.DomainName))
+		} else {
+			var res *route53.CreateHostedZoneResponse
+			err := s.retryAWS("CreateHostedZone", func() (err error) {
+				res, err = r53.CreateHostedZone(&route53.CreateHostedZoneRequest{
+					Name:            aws.String(name),
+					CallerReference: aws.String(s.ID),
+					HostedZoneConfig: &route53.HostedZoneConfig{
+						Comment: aws.String(fmt.Sprintf("Flynn cluster %s", s.ID)),
+					},
+				})
+				return
+			})
+			if err != nil {
+				return err
+			}
+			s.DNSZoneID = hostedZoneID(*res.HostedZone.ID)
+			s.CreatedDNSZone = true
+			s.SendEvent(fmt.Sprintf("Created hosted zone %s for %s", s.DNSZoneID, s.DomainName))
+		}
+		s.persist()
+	}
+
+	nameservers, err := s.checkDNSZone(r53, name)
+	if err != nil {
+		return err
+	}
+	s.sendDelegationEvent(nameservers)
+	return nil
+}
+
+// checkDNSZone verifies that the DNSZoneID hosted zone is the public zone
+// for the domain with the given name, returning its nameservers.
+func (s *Stack) checkDNSZone(r53 *route53.Route53, name string) ([]string, error) {
+	var res *route53.GetHostedZoneResponse
+	err := s.retryAWS("GetHostedZone", func() (err error) {
+		res, err = r53.GetHostedZone(&route53.GetHostedZoneRequest{ID: aws.String(s.DNSZoneID)})
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.HostedZone == nil || res.HostedZone.Name == nil || !strings.EqualFold(*res.HostedZone.Name, name) {
+		return nil, fmt.Errorf("Hosted zone %s is not for %s", s.DNSZoneID, s.DomainName)
+	}
+	if res.DelegationSet == nil {
+		return nil, fmt.Errorf("Hosted zone %s is a private zone", s.DNSZoneID)
+	}
+	return res.DelegationSet.NameServers, nil
+}
+
+// findDNSZone returns the account's public hosted zone with the given name,
+// or nil if there isn't one.
+func (s *Stack) findDNSZone(r53 *route53.Route53, name string) (*route53.HostedZone, error) {
+	var marker aws.StringValue
+	for {
+		var res *route53.ListHostedZonesResponse
+		err := s.retryAWS("ListHostedZones", func() (err error) {
+			res, err = r53.ListHostedZones(&route53.ListHostedZonesRequest{Marker: marker})
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, zone := range res.HostedZones {
+			if zone.Name == nil || !strings.EqualFold(*zone.Name, name) {
+				continue
+			}
+			if zone.Config != nil && zone.Config.PrivateZone != nil && *zone.Config.PrivateZone {
+				continue
+			}
+			z := zone
+			return &z, nil
+		}
+		if res.IsTruncated == nil || !*res.IsTruncated || res.NextMarker == nil {
+			return nil, nil
+		}
+		marker = res.NextMarker
+	}
+}
+
+func (s *Stack) sendDelegationEvent(nameservers []string) {
+	s.sendTypedEvent("dns_delegation_required", fmt.Sprintf(
+		"Delegate %s to the cluster by adding these NS records at its registrar: %s",
+		s.DomainName, strings.Join(nameservers, ", "),
+	), map[string]string{
+		"domain":      s.DomainName,
+		"zone_id":     s.DNSZoneID,
+		"nameservers": strings.Join(nameservers, ","),
+	})
+}
+
+// checkDelegation warns if the custom domain isn't yet delegated to its
+// zone, which the cluster works without but is unreachable by name until it
+// is.
+func (s *Stack) checkDelegation() {
+	dns, err := s.dnsProvider()
+	if err != nil {
+		return
+	}
+	if err := dns.VerifyDelegation(s.DomainName); err != nil {
+		s.SendEvent(fmt.Sprintf("WARNING: %s is not yet delegated to its hosted zone, add its NS records at the registrar: %s", s.DomainName, err))
+		return
+	}
+	s.SendEvent("DNS is live")
+}
+
+// deleteDNSZone deletes the hosted zone created for the custom domain, once
+// the stack and so the cluster's records in it are gone. A zone which the
+// user has since added records to is left alone.
+func (s *Stack) deleteDNSZone() error {
+	if !s.CreatedDNSZone || s.DNSZoneID == "" || s.Creds == nil {
+		return nil
+	}
+	s.SendEvent(fmt.Sprintf("Deleting hosted zone %s", s.DNSZoneID))
+	err := s.retryAWS("DeleteHostedZone", func() error {
+		_, err := s.route53().DeleteHostedZone(&route53.DeleteHostedZoneRequest{ID: aws.String(s.DNSZoneID)})
+		return err
+	})
+	if apiErr, ok := err.(aws.APIError); ok {
+		switch apiErr.Code {
+		case "NoSuchHostedZone":
+			return nil
+		case "HostedZoneNotEmpty":
+			s.SendEvent(fmt.Sprintf("WARNING: Not deleting hosted zone %s as it has records other than the cluster's", s.DNSZoneID))
+			return nil
+		}
+	}
+	return err
+}
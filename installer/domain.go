@@ -7,6 +7,8 @@ import (
 	"github.com/flynn/flynn/pkg/httpclient"
 )
 
+var ErrDomainTokenMismatch = errors.New("installer: domain token is not valid for the domain")
+
 func AllocateDomain() (*Domain, error) {
 	domain := &Domain{}
 	return domain, domain.client().Post("/domains", nil, domain)
@@ -39,6 +41,23 @@ func (d *Domain) path() string {
 	return "/domains/" + d.Name
 }
 
+// Validate checks with the domain service that the token is valid for the
+// domain, returning ErrDomainTokenMismatch if it isn't.
+func (d *Domain) Validate() error {
+	if d.Name == "" || d.Token == "" {
+		return ErrDomainTokenMismatch
+	}
+	res, err := d.client().RawReq("GET", d.path()+"/status", d.authHeader(), nil, nil)
+	if res != nil && (res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden) {
+		return ErrDomainTokenMismatch
+	}
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
 func (d *Domain) Configure(nameservers []string) error {
 	data := struct {
 		Nameservers []string `json:"nameservers"`
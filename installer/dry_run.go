@@ -0,0 +1,230 @@
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/aws"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/ec2"
+)
+
+// DryRunResource is a resource an install would create, as reported by a
+// dry run.
+type DryRunResource struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Size string `json:"size,omitempty"`
+}
+
+// DryRunSummary lists everything a dry run found the install would create,
+// along with the estimated monthly cost of the cluster.
+type DryRunSummary struct {
+	Resources            []*DryRunResource `json:"resources"`
+	EstimatedMonthlyCost float64           `json:"estimated_monthly_cost,omitempty"`
+}
+
+// runDryRun runs the checks of an install without creating anything or
+// saving the stack, sending a would_create event for each resource the
+// install would create and a dry_run_complete event summarising them. AWS
+// calls which would make changes are made with their DryRun flag, if they
+// have one, so that missing permissions are reported.
+func (s *Stack) runDryRun() {
+	s.dryRunResources = nil
+	for _, step := range s.dryRunSteps() {
+		if s.cancelled() {
+			return
+		}
+		if err := classifyInstallError(step.Name, step.Run()); err != nil {
+			s.sendTypedEvent("cluster_install_failed", err.Error(), map[string]string{
+				"error_type": InstallErrorType(err),
+			})
+			s.SendError(err)
+			return
+		}
+	}
+	s.finishDryRun()
+}
+
+func (s *Stack) dryRunSteps() []installStep {
+	return []installStep{
+		{"key_pair", s.dryRunKeyPair},
+		{"domain", s.dryRunDomain},
+		{"image", s.fetchImageID},
+		{"launch_check", s.dryRunLaunchCheck},
+		{"stack", s.dryRunStack},
+	}
+}
+
+// wouldCreate records a resource the install would create and sends a
+// would_create event for it.
+func (s *Stack) wouldCreate(r *DryRunResource) {
+	s.dryRunResources = append(s.dryRunResources, r)
+	desc := fmt.Sprintf("Would create %s %s", r.Type, r.Name)
+	if r.Size != "" {
+		desc += fmt.Sprintf(" (%s)", r.Size)
+	}
+	s.sendTypedEvent("would_create", desc, map[string]string{
+		"resource_type": r.Type,
+		"name":          r.Name,
+		"size":          r.Size,
+	})
+}
+
+// dryRunKeyPair checks the SSHKeyName key pair exists, the key pair would
+// otherwise be imported. There is no dry run of the import as it needs the
+// public key, which would have to be generated.
+func (s *Stack) dryRunKeyPair() error {
+	name := s.SSHKeyName
+	if name == "" || s.GeneratedSSHKey {
+		name = clusterKeyPairName(s.ID)
+	} else {
+		var res *ec2.DescribeKeyPairsResult
+		err := s.retryAWS("DescribeKeyPairs", func() (err error) {
+			res, err = s.ec2.DescribeKeyPairs(&ec2.DescribeKeyPairsRequest{KeyNames: []string{name}})
+			return
+		})
+		if apiErr, ok := err.(aws.APIError); ok && apiErr.Code == "InvalidKeyPair.NotFound" {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		if res != nil && len(res.KeyPairs) > 0 {
+			s.SendEvent(fmt.Sprintf("Using existing key pair (%s)", name))
+			return nil
+		}
+	}
+	s.wouldCreate(&DryRunResource{Type: "AWS::EC2::KeyPair", Name: name})
+	return nil
+}
+
+// dryRunDomain checks the hosted zone of a custom domain, or reports that a
+// domain would be allocated for the cluster.
+func (s *Stack) dryRunDomain() error {
+	if s.DomainName == "" {
+		s.wouldCreate(&DryRunResource{Type: "Flynn::Domain", Name: "flynnhub.com subdomain"})
+		return nil
+	}
+	r53 := s.route53()
+	name := fqdn(s.DomainName)
+	if s.DNSZoneID == "" {
+		zone, err := s.findDNSZone(r53, name)
+		if err != nil {
+			return err
+		}
+		if zone == nil {
+			s.wouldCreate(&DryRunResource{Type: "AWS::Route53::HostedZone", Name: s.DomainName})
+			return nil
+		}
+		s.DNSZoneID = hostedZoneID(*zone.ID)
+	}
+	if _, err := s.checkDNSZone(r53, name); err != nil {
+		return err
+	}
+	s.SendEvent(fmt.Sprintf("Using existing hosted zone %s for %s", s.DNSZoneID, s.DomainName))
+	return nil
+}
+
+// dryRunLaunchCheck checks instances can be launched from the image, which
+// can't be done if it would first be copied from CopyImageFromRegion.
+func (s *Stack) dryRunLaunchCheck() error {
+	if s.ImageID == "" {
+		s.SendEvent("Skipping launch check as the image would first be copied")
+		return nil
+	}
+	return s.checkLaunchable()
+}
+
+func (s *Stack) dryRunStack() error {
+	resources, err := s.stackResources()
+	if err != nil {
+		return err
+	}
+	s.wouldCreate(&DryRunResource{Type: "AWS::CloudFormation::Stack", Name: fmt.Sprintf("flynn-%d", s.now().Unix())})
+	for _, r := range resources {
+		s.wouldCreate(r)
+	}
+	return nil
+}
+
+// stackResources returns the resources of the stack template, ordered by
+// type and name, with the instances named by their Name tag.
+func (s *Stack) stackResources() ([]*DryRunResource, error) {
+	body, err := s.stackTemplateBody()
+	if err != nil {
+		return nil, err
+	}
+	var template struct {
+		Resources map[string]struct{ Type string }
+	}
+	if err := json.Unmarshal([]byte(body), &template); err != nil {
+		return nil, err
+	}
+	instances := make(map[string]*stackTemplateInstance, s.NumInstances)
+	for _, i := range s.stackTemplateInstances() {
+		instances[i.LogicalID] = i
+	}
+	resources := make([]*DryRunResource, 0, len(template.Resources))
+	for id, r := range template.Resources {
+		res := &DryRunResource{Type: r.Type, Name: id}
+		if i, ok := instances[id]; ok {
+			res.Name = i.Name
+			res.Size = fmt.Sprintf("%s, %s", s.InstanceType, volumeSizeString(s.VolumeSize))
+		}
+		resources = append(resources, res)
+	}
+	sort.Sort(dryRunResourceSort(resources))
+	return resources, nil
+}
+
+// finishDryRun sets DryRunSummary and sends the dry_run_complete event,
+// whose metadata has the number of resources of each type.
+func (s *Stack) finishDryRun() {
+	summary := &DryRunSummary{Resources: s.dryRunResources}
+	if cost, err := s.EstimateCost(); err == nil {
+		summary.EstimatedMonthlyCost = cost.Monthly
+	}
+	s.DryRunSummary = summary
+
+	counts := make(map[string]int)
+	var types []string
+	for _, r := range summary.Resources {
+		if counts[r.Type] == 0 {
+			types = append(types, r.Type)
+		}
+		counts[r.Type]++
+	}
+	metadata := map[string]string{"resources": strconv.Itoa(len(summary.Resources))}
+	parts := make([]string, len(types))
+	for i, t := range types {
+		metadata[t] = strconv.Itoa(counts[t])
+		parts[i] = fmt.Sprintf("%d %s", counts[t], t)
+	}
+	desc := fmt.Sprintf("Dry run complete, the install would create %d resources: %s", len(summary.Resources), strings.Join(parts, ", "))
+	if summary.EstimatedMonthlyCost > 0 {
+		metadata["estimated_monthly_cost"] = fmt.Sprintf("%.2f", summary.EstimatedMonthlyCost)
+		desc += fmt.Sprintf(", costing about $%.2f a month", summary.EstimatedMonthlyCost)
+	}
+	s.sendTypedEvent("dry_run_complete", desc, metadata)
+}
+
+func volumeSizeString(size int) string {
+	if size == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%dGB volume", size)
+}
+
+type dryRunResourceSort []*DryRunResource
+
+func (r dryRunResourceSort) Len() int      { return len(r) }
+func (r dryRunResourceSort) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r dryRunResourceSort) Less(i, j int) bool {
+	if r[i].Type != r[j].Type {
+		return r[i].Type < r[j].Type
+	}
+	return r[i].Name < r[j].Name
+}
@@ -0,0 +1,72 @@
+package installer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var ErrConfigNotReady = errors.New("installer: cluster config is not available until the install has finished")
+
+func caCertPath(clusterID string) string {
+	return filepath.Join(clustersDir, filepath.Base(clusterID)+".ca.pem")
+}
+
+// ClusterEnv returns the environment variables which point the flynn CLI at
+// the cluster with the given ID, see EnvExports. The CA certificate is
+// written next to the cluster's config and FLYNN_CA_CERT set to its path for
+// tools which verify the controller's certificate rather than its pin.
+func (api *httpAPI) ClusterEnv(id string) (map[string]string, error) {
+	s, err := api.FindCluster(id)
+	if os.IsNotExist(err) {
+		return nil, ErrClusterNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if s.State != StateRunning || s.ControllerKey == "" || s.Domain == nil || s.Domain.Name == "" {
+		return nil, ErrConfigNotReady
+	}
+
+	conf := s.ClusterConfig()
+	env := map[string]string{
+		"FLYNN_CONTROLLER_URL": conf.URL,
+		"FLYNN_CONTROLLER_KEY": conf.Key,
+		"FLYNN_TLS_PIN":        conf.TLSPin,
+		"FLYNN_GIT_HOST":       conf.GitHost,
+	}
+	if s.CACert != "" {
+		if err := os.MkdirAll(clustersDir, 0755); err != nil {
+			return nil, err
+		}
+		path := caCertPath(id)
+		if err := ioutil.WriteFile(path, []byte(s.CACert), 0644); err != nil {
+			return nil, err
+		}
+		env["FLYNN_CA_CERT"] = path
+	}
+	return env, nil
+}
+
+// EnvExports renders the environment as export lines suitable for
+// `eval $(...)` in a POSIX shell.
+func EnvExports(env map[string]string) string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "export %s=%s\n", k, shellQuote(env[k]))
+	}
+	return buf.String()
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
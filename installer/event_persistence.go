@@ -0,0 +1,156 @@
+package installer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type EventPolicy int
+
+const (
+	// EventDurable events are written to disk with the cluster.
+	EventDurable EventPolicy = iota
+	// EventEphemeral events are only kept in memory.
+	EventEphemeral
+)
+
+// EventPolicies maps event types to whether they are persisted, types not
+// listed are durable. Stack events are sent for every change to every
+// resource of the stack, they are still written to the cluster log.
+var EventPolicies = map[string]EventPolicy{
+	"stack_event": EventEphemeral,
+	"prompt":      EventEphemeral,
+
+	// sent once the cluster's event log has been removed
+	"cluster_deleted": EventEphemeral,
+}
+
+func eventPolicy(eventType string) EventPolicy {
+	if p, ok := EventPolicies[eventType]; ok {
+		return p
+	}
+	return EventDurable
+}
+
+var eventsMtx sync.Mutex
+
+func eventsPath(clusterID string) string {
+	return filepath.Join(clustersDir, filepath.Base(clusterID)+".events.json")
+}
+
+// persistEvent appends the event to the cluster's event log if its type is
+// durable.
+func persistEvent(clusterID string, event *httpEvent) error {
+	if clusterID == "" || eventPolicy(event.Type) != EventDurable {
+		return nil
+	}
+
+	eventsMtx.Lock()
+	defer eventsMtx.Unlock()
+
+	if err := os.MkdirAll(clustersDir, 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(eventsPath(clusterID), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// end a line left partially written by a crash so the event isn't
+	// appended to it
+	if info, err := file.Stat(); err != nil {
+		return err
+	} else if size := info.Size(); size > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, size-1); err != nil {
+			return err
+		}
+		if last[0] != '\n' {
+			if _, err := file.Write([]byte("\n")); err != nil {
+				return err
+			}
+		}
+	}
+	return json.NewEncoder(file).Encode(event)
+}
+
+// loadEvents reads the saved events of the cluster, one per line. Lines
+// which can't be decoded, as one partially written by a crash, are skipped.
+func loadEvents(clusterID string) ([]*httpEvent, error) {
+	eventsMtx.Lock()
+	defer eventsMtx.Unlock()
+
+	file, err := os.Open(eventsPath(clusterID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var events []*httpEvent
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			event := &httpEvent{}
+			if json.Unmarshal(line, event) == nil {
+				events = append(events, event)
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+type eventSort []*httpEvent
+
+func (e eventSort) Len() int           { return len(e) }
+func (e eventSort) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e eventSort) Less(i, j int) bool { return e[i].Timestamp.Before(e[j].Timestamp) }
+
+// EventsBetween returns the events of the cluster with timestamps in the
+// range [from, to] in chronological order. All events of an install in
+// progress are included, otherwise only durable ones.
+func (api *httpAPI) EventsBetween(clusterID string, from, to time.Time) ([]*httpEvent, error) {
+	events, err := api.clusterEvents(clusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*httpEvent, 0, len(events))
+	for _, e := range events {
+		if e.Timestamp.Before(from) || e.Timestamp.After(to) {
+			continue
+		}
+		res = append(res, e)
+	}
+	sort.Stable(eventSort(res))
+	return res, nil
+}
+
+// clusterEvents returns the events of the cluster in ID order, all of them
+// for an install in progress, otherwise the durable ones.
+func (api *httpAPI) clusterEvents(clusterID string) ([]*httpEvent, error) {
+	api.InstallerStackMtx.RLock()
+	s := api.InstallerStacks[clusterID]
+	api.InstallerStackMtx.RUnlock()
+	if s == nil {
+		return loadEvents(clusterID)
+	}
+	s.eventsMtx.Lock()
+	defer s.eventsMtx.Unlock()
+	events := make([]*httpEvent, len(s.events))
+	copy(events, s.events)
+	return events, nil
+}
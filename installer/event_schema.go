@@ -0,0 +1,190 @@
+package installer
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// EventTypes lists every value of the "type" field of events sent to
+// subscribers of an install. It is maintained by hand, a test checks that
+// every type the package sends is listed.
+var EventTypes = []string{
+	"status",
+	"stack_event",
+	"error",
+	"prompt",
+	"domain",
+	"dashboard_login_token",
+	"ca_cert",
+	"done",
+	"snapshot_created",
+	"cluster_deleting",
+	"cluster_deleted",
+	"cluster_delete_failed",
+	"sink_lagging",
+	"cluster_timeout",
+	"instance_ready",
+	"instance_failed",
+	"validation_warning",
+	"node_draining",
+	"node_removed",
+	"node_joining",
+	"node_added",
+	"retrying_resources",
+	"ami_copying",
+	"ami_ready",
+	"connectivity_ok",
+	"connectivity_failed",
+	"host_resources_ok",
+	"host_resources_insufficient",
+	"instance_replacing",
+	"instance_replaced",
+	"dependencies_ok",
+	"dependency_unreachable",
+	"cluster_install_aborted",
+	"cluster_state",
+	"cluster_resumed",
+	"retrying",
+	"vpc_created",
+	"security_group_created",
+	"instance_launching",
+	"instance_running",
+	"bootstrap_started",
+	"bootstrap_complete",
+	"cluster_install_failed",
+	"cluster_ready",
+	"cluster_unhealthy",
+	"dns_delegation_required",
+	"cluster_queued",
+	"would_create",
+	"dry_run_complete",
+	"spot_request_pending",
+	"spot_request_fulfilled",
+}
+
+// eventFields are the fields besides the type which events of a type always
+// carry, and the keys always set in their metadata.
+type eventFields struct {
+	fields   []string
+	metadata []string
+}
+
+// describedEvent is the eventFields of the types not listed in
+// requiredEventFields, which only carry a description.
+var describedEvent = eventFields{fields: []string{"description"}}
+
+// progressEvent is the eventFields of the events marking a provisioning
+// milestone, see sendProgressEvent.
+var progressEvent = eventFields{fields: []string{"description", "percent", "metadata"}, metadata: []string{"cluster_id"}}
+
+// requiredEventFields maps the types of EventTypes which carry more than a
+// description to their eventFields.
+var requiredEventFields = map[string]eventFields{
+	"prompt":                 {fields: []string{"prompt"}},
+	"done":                   {},
+	"cluster_state":          {fields: []string{"description", "metadata"}, metadata: []string{"from", "to"}},
+	"vpc_created":            progressEvent,
+	"security_group_created": progressEvent,
+	"instance_launching":     progressEvent,
+	"instance_running":       progressEvent,
+	"bootstrap_started":      progressEvent,
+	"bootstrap_complete":     progressEvent,
+	"instance_ready":         {fields: []string{"description", "metadata"}, metadata: []string{"instance", "ip"}},
+	"instance_failed":        {fields: []string{"description", "metadata"}, metadata: []string{"instance", "ip"}},
+	"node_joining":           {fields: []string{"description", "metadata"}, metadata: []string{"instance"}},
+	"node_added":             {fields: []string{"description", "metadata"}, metadata: []string{"instance", "ip"}},
+	"node_draining":          {fields: []string{"description", "metadata"}, metadata: []string{"instance", "ip"}},
+	"node_removed":           {fields: []string{"description", "metadata"}, metadata: []string{"instance", "ip"}},
+	"instance_replacing":     {fields: []string{"description", "metadata"}, metadata: []string{"instance", "instance_id", "ip"}},
+	"instance_replaced":      {fields: []string{"description", "metadata"}, metadata: []string{"instance", "instance_id", "ip", "new_ip"}},
+}
+
+var eventSchema = marshalEventSchema()
+
+// EventSchema returns a JSON Schema describing the events streamed from the
+// installer. The fields are generated from the httpEvent struct, with the
+// type limited to EventTypes, and an event must match the entry of oneOf for
+// its type, which lists the fields and metadata keys it carries. The
+// returned slice is shared and must not be modified.
+func EventSchema() []byte {
+	return eventSchema
+}
+
+func marshalEventSchema() []byte {
+	schema := typeSchema(reflect.TypeOf(httpEvent{}))
+	schema["$schema"] = "http://json-schema.org/draft-04/schema#"
+	schema["title"] = "Event"
+	schema["properties"].(map[string]interface{})["type"].(map[string]interface{})["enum"] = EventTypes
+	schema["required"] = []string{"type"}
+
+	oneOf := make([]interface{}, len(EventTypes))
+	for i, t := range EventTypes {
+		fields, ok := requiredEventFields[t]
+		if !ok {
+			fields = describedEvent
+		}
+		properties := map[string]interface{}{
+			"type": map[string]interface{}{"enum": []string{t}},
+		}
+		if len(fields.metadata) > 0 {
+			properties["metadata"] = map[string]interface{}{"required": fields.metadata}
+		}
+		oneOf[i] = map[string]interface{}{
+			"properties": properties,
+			"required":   append([]string{"type"}, fields.fields...),
+		}
+	}
+	schema["oneOf"] = oneOf
+
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name := f.Name
+			if tag := f.Tag.Get("json"); tag != "" {
+				if tag == "-" {
+					continue
+				}
+				if n := strings.Split(tag, ",")[0]; n != "" {
+					name = n
+				}
+			}
+			properties[name] = typeSchema(f.Type)
+		}
+		return map[string]interface{}{"type": "object", "properties": properties}
+	}
+	return map[string]interface{}{}
+}
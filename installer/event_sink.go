@@ -0,0 +1,206 @@
+package installer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/flynn/flynn/Godeps/_workspace/src/gopkg.in/inconshreveable/log15.v2"
+)
+
+// EventSink receives a copy of every event sent by the installer, for
+// example to publish cluster lifecycle events to a message bus.
+type EventSink interface {
+	// Publish sends the JSON encoded event for the given cluster.
+	Publish(clusterID string, event []byte) error
+}
+
+// eventSinkBufferSize is the number of events buffered for each sink before
+// events for it are dropped.
+const eventSinkBufferSize = 1000
+
+type eventSinkMsg struct {
+	clusterID string
+	data      []byte
+}
+
+// bufferedEventSink delivers events to a sink in a separate goroutine so a
+// slow or unavailable sink never blocks subscribers.
+type bufferedEventSink struct {
+	name    string
+	sink    EventSink
+	ch      chan *eventSinkMsg
+	stop    chan struct{}
+	lagging bool
+	mtx     sync.Mutex
+}
+
+func newBufferedEventSink(name string, sink EventSink) *bufferedEventSink {
+	b := &bufferedEventSink{
+		name: name,
+		sink: sink,
+		ch:   make(chan *eventSinkMsg, eventSinkBufferSize),
+		stop: make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (b *bufferedEventSink) run() {
+	for {
+		select {
+		case msg := <-b.ch:
+			if err := b.sink.Publish(msg.clusterID, msg.data); err != nil {
+				log.Error("error publishing event", "sink", b.name, "err", err)
+			}
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// send queues the message, returning true if the sink has just started
+// dropping events.
+func (b *bufferedEventSink) send(msg *eventSinkMsg) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	select {
+	case b.ch <- msg:
+		b.lagging = false
+		return false
+	default:
+		started := !b.lagging
+		b.lagging = true
+		return started
+	}
+}
+
+type eventSinks struct {
+	mtx   sync.RWMutex
+	sinks map[string]*bufferedEventSink
+}
+
+func newEventSinks() *eventSinks {
+	return &eventSinks{sinks: make(map[string]*bufferedEventSink)}
+}
+
+func (e *eventSinks) Add(name string, sink EventSink) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if existing, ok := e.sinks[name]; ok {
+		close(existing.stop)
+	}
+	e.sinks[name] = newBufferedEventSink(name, sink)
+}
+
+func (e *eventSinks) Remove(name string) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if existing, ok := e.sinks[name]; ok {
+		close(existing.stop)
+		delete(e.sinks, name)
+	}
+}
+
+// send queues the event for every sink and returns the names of the sinks
+// which have just started lagging behind.
+func (e *eventSinks) send(clusterID string, event *httpEvent) []string {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	if len(e.sinks) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil
+	}
+	msg := &eventSinkMsg{clusterID: clusterID, data: data}
+	var lagging []string
+	for name, sink := range e.sinks {
+		if sink.send(msg) {
+			lagging = append(lagging, name)
+		}
+	}
+	return lagging
+}
+
+// AddEventSink sends the events of all installs to sink as well as to
+// subscribers, replacing any sink with the same name.
+func (api *httpAPI) AddEventSink(name string, sink EventSink) {
+	api.eventSinks.Add(name, sink)
+}
+
+func (api *httpAPI) RemoveEventSink(name string) {
+	api.eventSinks.Remove(name)
+}
+
+// NATSEventSink publishes events to a NATS server on the subject
+// "<Prefix>.<cluster id>".
+type NATSEventSink struct {
+	Addr   string
+	Prefix string
+
+	conn net.Conn
+	w    *bufio.Writer
+}
+
+func (n *NATSEventSink) Publish(clusterID string, event []byte) error {
+	if n.conn == nil {
+		if err := n.connect(); err != nil {
+			return err
+		}
+	}
+	prefix := n.Prefix
+	if prefix == "" {
+		prefix = "flynn.installer"
+	}
+	n.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	fmt.Fprintf(n.w, "PUB %s.%s %d\r\n", prefix, clusterID, len(event))
+	n.w.Write(event)
+	n.w.WriteString("\r\n")
+	if err := n.w.Flush(); err != nil {
+		n.conn.Close()
+		n.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (n *NATSEventSink) connect() error {
+	conn, err := net.DialTimeout("tcp", n.Addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	// Read the INFO message and discard server messages (PING, +OK, etc.)
+	// in the background, answering PINGs to keep the connection alive.
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := r.ReadString('\n'); err != nil {
+		conn.Close()
+		return err
+	}
+	conn.SetReadDeadline(time.Time{})
+	w := bufio.NewWriter(conn)
+	w.WriteString("CONNECT {\"verbose\":false,\"pedantic\":false}\r\n")
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return err
+	}
+	n.conn = conn
+	n.w = w
+	go func() {
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if len(line) >= 4 && line[:4] == "PING" {
+				conn.Write([]byte("PONG\r\n"))
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,171 @@
+package installer
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/julienschmidt/httprouter"
+	log "github.com/flynn/flynn/Godeps/_workspace/src/gopkg.in/inconshreveable/log15.v2"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/sse"
+)
+
+// EventID implements the identifier interface of pkg/sse. Event IDs are
+// only unique within a cluster, so are prefixed with the cluster ID.
+func (e *ClusterEvent) EventID() string {
+	return e.ClusterID + ":" + strconv.Itoa(e.Event.ID)
+}
+
+// eventCursor is the position in the event stream given by a Last-Event-ID
+// header, the last event of ClusterID which was received.
+type eventCursor struct {
+	ClusterID string
+	ID        int
+}
+
+// parseEventCursor parses a Last-Event-ID of the form <cluster>:<id>, or
+// just <id> when streaming the events of a single cluster.
+func parseEventCursor(lastEventID, clusterID string) *eventCursor {
+	if lastEventID == "" {
+		return nil
+	}
+	cursor := &eventCursor{ClusterID: clusterID}
+	if i := strings.LastIndex(lastEventID, ":"); i >= 0 {
+		cursor.ClusterID = lastEventID[:i]
+		lastEventID = lastEventID[i+1:]
+	}
+	id, err := strconv.Atoi(lastEventID)
+	if err != nil || cursor.ClusterID == "" {
+		return nil
+	}
+	cursor.ID = id
+	return cursor
+}
+
+// EventStreamHandler streams the events of every install, or only of the
+// one given by the cluster query parameter, as server-sent events for a
+// browser to consume with EventSource. The events so far are replayed
+// first, or those since the Last-Event-ID a reconnecting EventSource sends,
+// followed by the live ones until the client disconnects.
+func (api *httpAPI) EventStreamHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	clusterID := req.URL.Query().Get("cluster")
+	if clusterID != "" {
+		if _, err := api.FindCluster(clusterID); err != nil {
+			httphelper.ObjectNotFoundError(w, "install instance not found")
+			return
+		}
+	}
+	cursor := parseEventCursor(req.Header.Get("Last-Event-ID"), clusterID)
+
+	// subscribe before replaying so no event is missed in between, those
+	// received twice are skipped
+	live := make(chan *ClusterEvent)
+	sub := api.Subscribe(clusterID, live)
+	defer api.Unsubscribe(sub)
+	replay, err := api.replayClusterEvents(clusterID, cursor)
+	if err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+
+	events := make(chan *ClusterEvent)
+	stream := sse.NewStream(w, events, log.New("events", clusterID))
+	stream.Serve()
+	go func() {
+		sent := make(map[string]int)
+		if cursor != nil {
+			sent[cursor.ClusterID] = cursor.ID
+		}
+		send := func(e *ClusterEvent) bool {
+			if id, ok := sent[e.ClusterID]; ok && e.Event.ID <= id {
+				return true
+			}
+			select {
+			case events <- e:
+				sent[e.ClusterID] = e.Event.ID
+				return true
+			case <-stream.Done:
+			}
+			return false
+		}
+		for _, e := range replay {
+			if !send(e) {
+				return
+			}
+		}
+		for {
+			select {
+			case e := <-live:
+				if !send(e) {
+					return
+				}
+			case <-stream.Done:
+				// the stream closes itself once the client is gone
+				return
+			}
+		}
+	}()
+	stream.Wait()
+}
+
+// replayClusterEvents returns the events of the given cluster, or of every
+// cluster if it is empty, which come after the cursor in chronological
+// order. When streaming every cluster the cursor's position in the others
+// is that of the timestamp of its event.
+func (api *httpAPI) replayClusterEvents(clusterID string, cursor *eventCursor) ([]*ClusterEvent, error) {
+	ids := []string{clusterID}
+	if clusterID == "" {
+		stacks, err := api.ListClusters()
+		if err != nil {
+			return nil, err
+		}
+		ids = make([]string, len(stacks))
+		for i, s := range stacks {
+			ids[i] = s.ID
+		}
+	}
+
+	events := make(map[string][]*httpEvent, len(ids))
+	for _, id := range ids {
+		e, err := api.clusterEvents(id)
+		if err != nil {
+			return nil, err
+		}
+		events[id] = e
+	}
+	var res []*ClusterEvent
+	var since *httpEvent
+	if cursor != nil {
+		for _, e := range events[cursor.ClusterID] {
+			if e.ID <= cursor.ID {
+				since = e
+			}
+		}
+	}
+	for _, id := range ids {
+		for _, e := range events[id] {
+			switch {
+			case cursor == nil:
+			case id == cursor.ClusterID:
+				if e.ID <= cursor.ID {
+					continue
+				}
+			case since != nil && !e.Timestamp.After(since.Timestamp):
+				continue
+			}
+			res = append(res, &ClusterEvent{ClusterID: id, Event: e})
+		}
+	}
+	sort.Stable(clusterEventSort(res))
+	return res, nil
+}
+
+type clusterEventSort []*ClusterEvent
+
+func (e clusterEventSort) Len() int      { return len(e) }
+func (e clusterEventSort) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e clusterEventSort) Less(i, j int) bool {
+	return e[i].Event.Timestamp.Before(e[j].Event.Timestamp)
+}
@@ -0,0 +1,78 @@
+package installer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// clusterExportVersion is the version of the ClusterExport format.
+const clusterExportVersion = 1
+
+// ClusterExport is the configuration of a cluster as exported by
+// ExportCluster. Config is the input which launches an equivalent cluster,
+// the other fields record details of the exported cluster for auditing.
+// FlynnVersion is the version the cluster was installed with, which is only
+// the Config version if that was pinned.
+type ClusterExport struct {
+	Version      int        `json:"version"`
+	Provider     string     `json:"provider"`
+	ID           string     `json:"id"`
+	Domain       string     `json:"domain,omitempty"`
+	SSHKeyName   string     `json:"ssh_key_name,omitempty"`
+	StackName    string     `json:"stack_name,omitempty"`
+	Channel      string     `json:"channel,omitempty"`
+	FlynnVersion string     `json:"flynn_version,omitempty"`
+	Config       *jsonInput `json:"config"`
+}
+
+// ExportCluster returns the configuration of the cluster with the given ID
+// as indented JSON, for the cluster to be recreated with ImportCluster or to
+// be kept for auditing. Secrets such as the controller key and the discovery
+// token are left out, as are credentials other than the ID of the stored
+// credentials the cluster was launched with.
+func (api *httpAPI) ExportCluster(id string) ([]byte, error) {
+	s, err := api.FindCluster(id)
+	if os.IsNotExist(err) {
+		return nil, ErrClusterNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	export := &ClusterExport{
+		Version:      clusterExportVersion,
+		Provider:     "aws",
+		ID:           s.ID,
+		SSHKeyName:   s.SSHKeyName,
+		StackName:    s.StackName,
+		Channel:      s.Channel,
+		FlynnVersion: s.InstalledVersion,
+		Config:       cloneInput(s),
+	}
+	export.Config.CredentialID = s.CredentialID
+	if s.Domain != nil {
+		export.Domain = s.Domain.Name
+	}
+	return json.MarshalIndent(export, "", "  ")
+}
+
+// ImportCluster returns the install input of a cluster exported by
+// ExportCluster, ready to be launched.
+func (api *httpAPI) ImportCluster(data []byte) (*jsonInput, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	export := &ClusterExport{}
+	if err := dec.Decode(export); err != nil {
+		return nil, validationErr("", err.Error())
+	}
+	if export.Version != clusterExportVersion {
+		return nil, validationErr("version", fmt.Sprintf("must be %d", clusterExportVersion))
+	}
+	if export.Provider != "aws" {
+		return nil, validationErr("provider", fmt.Sprintf("%q is not supported", export.Provider))
+	}
+	if export.Config == nil {
+		return nil, validationErr("config", "is required")
+	}
+	return export.Config, nil
+}
@@ -0,0 +1,47 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Feature is an optional capability of a Flynn version, listed for each
+// version in the published image manifest.
+type Feature string
+
+var ErrFeatureUnavailable = errors.New("installer: feature unavailable in the chosen Flynn version")
+
+// VersionFeatures returns the features supported by the given Flynn version
+// on the default release channel.
+func VersionFeatures(version string) ([]Feature, error) {
+	manifest, err := fetchManifest(DefaultChannel)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range manifest.Versions {
+		if v.Version == version {
+			features := make([]Feature, len(v.Features))
+			for i, f := range v.Features {
+				features[i] = Feature(f)
+			}
+			return features, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown version %s", version)
+}
+
+// unavailableFeatures returns those of the requested features which are not
+// in the given list.
+func unavailableFeatures(requested []string, available []string) []string {
+	var missing []string
+outer:
+	for _, r := range requested {
+		for _, a := range available {
+			if r == a {
+				continue outer
+			}
+		}
+		missing = append(missing, r)
+	}
+	return missing
+}
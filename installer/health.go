@@ -0,0 +1,182 @@
+package installer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/golang.org/x/crypto/ssh"
+	"github.com/flynn/flynn/pkg/attempt"
+	"github.com/flynn/flynn/pkg/pinned"
+)
+
+// clusterHealthAttempts is how long a newly bootstrapped cluster is given to
+// become healthy.
+var clusterHealthAttempts = attempt.Strategy{
+	Total: 5 * time.Minute,
+	Delay: 5 * time.Second,
+}
+
+// clusterHealth is the result of checking the cluster's health.
+type clusterHealth struct {
+	// Controller is the error from calling the controller API, if any.
+	Controller error
+
+	// Hosts is the number of hosts registered with the cluster leader, or
+	// HostsErr the error finding out.
+	Hosts    int
+	HostsErr error
+}
+
+func (h *clusterHealth) healthy(expected int) bool {
+	return h.Controller == nil && h.HostsErr == nil && h.Hosts >= expected
+}
+
+func (h *clusterHealth) String() string {
+	var problems []string
+	if h.Controller != nil {
+		problems = append(problems, fmt.Sprintf("the controller API is unavailable (%s)", h.Controller))
+	}
+	if h.HostsErr != nil {
+		problems = append(problems, fmt.Sprintf("unable to list the hosts (%s)", h.HostsErr))
+	}
+	return strings.Join(problems, ", ")
+}
+
+// diagnostics returns the result of each check, as the metadata of the
+// cluster_unhealthy event.
+func (h *clusterHealth) diagnostics(expected int) map[string]string {
+	metadata := map[string]string{
+		"controller":     "ok",
+		"hosts":          strconv.Itoa(h.Hosts),
+		"expected_hosts": strconv.Itoa(expected),
+	}
+	if h.Controller != nil {
+		metadata["controller"] = h.Controller.Error()
+	}
+	if h.HostsErr != nil {
+		metadata["hosts_error"] = h.HostsErr.Error()
+	}
+	return metadata
+}
+
+// verifyClusterHealth waits for the bootstrapped cluster to be usable: the
+// controller API must respond to the controller key over a connection
+// matching the controller pin and every instance must have registered as a
+// host. It sends cluster_ready once it is, or cluster_unhealthy with the
+// result of the checks and fails the install if it isn't in time.
+func (s *Stack) verifyClusterHealth() error {
+	s.SendEvent("Verifying cluster health")
+	sshConfig, err := s.sshConfig()
+	if err != nil {
+		return err
+	}
+	privateIPs, err := s.instancePrivateIPs()
+	if err != nil {
+		s.SendEvent(fmt.Sprintf("WARNING: Unable to find the instances' private IPs: %s", err))
+	}
+
+	var health *clusterHealth
+	clusterHealthAttempts.Run(func() error {
+		if s.cancelled() {
+			return nil
+		}
+		health = &clusterHealth{
+			Controller: checkController(s.InstanceIPs[0]+":443", "controller."+s.Domain.Name, s.ControllerKey, s.ControllerPin),
+		}
+		health.Hosts, health.HostsErr = countHosts(sshConfig, s.InstanceIPs[0], privateIPs)
+		if !health.healthy(s.NumInstances) {
+			return fmt.Errorf("cluster unhealthy")
+		}
+		return nil
+	})
+	if s.cancelled() {
+		return ErrCancelled
+	}
+	if !health.healthy(s.NumInstances) {
+		msg := fmt.Sprintf("%d of %d hosts are registered", health.Hosts, s.NumInstances)
+		if problems := health.String(); problems != "" {
+			msg += ", " + problems
+		}
+		s.sendTypedEvent("cluster_unhealthy", "Cluster is unhealthy: "+msg, health.diagnostics(s.NumInstances))
+		return fmt.Errorf("Cluster is unhealthy: %s", msg)
+	}
+	s.sendTypedEvent("cluster_ready", fmt.Sprintf("Cluster is ready with %d hosts", health.Hosts), map[string]string{
+		"hosts": strconv.Itoa(health.Hosts),
+	})
+	return nil
+}
+
+// checkController calls the controller API at addr with the given key,
+// verifying its certificate against the base64 encoded pin.
+func checkController(addr, serverName, key, pin string) error {
+	pinBytes, err := base64.StdEncoding.DecodeString(pin)
+	if err != nil {
+		return fmt.Errorf("invalid controller pin: %s", err)
+	}
+	dialer := &pinned.Config{Pin: pinBytes, Config: &tls.Config{ServerName: serverName}}
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			// the cluster domain may not resolve yet, so dial the instance
+			DialTLS: func(network, _ string) (net.Conn, error) {
+				return dialer.Dial(network, addr)
+			},
+		},
+	}
+	req, err := http.NewRequest("GET", "https://"+serverName+"/apps", nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("", key)
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// countHosts returns the number of hosts registered with the cluster leader,
+// asking each instance in turn from the given one as only the leader serves
+// the cluster API.
+func countHosts(sshConfig *ssh.ClientConfig, ip string, privateIPs []string) (int, error) {
+	var script bytes.Buffer
+	script.WriteString("for ip in localhost")
+	for _, ip := range privateIPs {
+		if ip != "" {
+			script.WriteString(" " + ip)
+		}
+	}
+	script.WriteString("; do curl -fsS http://$ip:1113/cluster/hosts && exit 0; done; exit 1")
+
+	conn, err := ssh.Dial("tcp", ip+":22", sshConfig)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	sess, err := conn.NewSession()
+	if err != nil {
+		return 0, err
+	}
+	defer sess.Close()
+	out, err := sess.Output(script.String())
+	if err != nil {
+		return 0, fmt.Errorf("no instance is serving the cluster API: %s", err)
+	}
+	var hosts []json.RawMessage
+	if err := json.Unmarshal(out, &hosts); err != nil {
+		return 0, err
+	}
+	return len(hosts), nil
+}
@@ -8,7 +8,9 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -40,12 +42,45 @@ type installerJSConfig struct {
 }
 
 type jsonInput struct {
-	Creds        jsonInputCreds `json:"creds"`
-	Region       string         `json:"region"`
-	InstanceType string         `json:"instance_type"`
-	NumInstances int            `json:"num_instances"`
-	VpcCidr      string         `json:"vpc_cidr,omitempty"`
-	SubnetCidr   string         `json:"subnet_cidr,omitempty"`
+	// ClusterID is the ID to give the cluster, so that a launch can be
+	// retried safely, it is generated if not set.
+	ClusterID            string            `json:"cluster_id,omitempty"`
+	Creds                jsonInputCreds    `json:"creds"`
+	CredentialID         string            `json:"credential_id,omitempty"`
+	Region               string            `json:"region"`
+	InstanceType         string            `json:"instance_type"`
+	NumInstances         int               `json:"num_instances"`
+	VpcCidr              string            `json:"vpc_cidr,omitempty"`
+	SubnetCidr           string            `json:"subnet_cidr,omitempty"`
+	VpcID                string            `json:"vpc_id,omitempty"`
+	SubnetID             string            `json:"subnet_id,omitempty"`
+	BootstrapManifest    string            `json:"bootstrap_manifest,omitempty"`
+	DNSProvider          string            `json:"dns_provider,omitempty"`
+	DomainName           string            `json:"domain_name,omitempty"`
+	DNSZoneID            string            `json:"dns_zone_id,omitempty"`
+	Metadata             map[string]string `json:"metadata,omitempty"`
+	Tags                 map[string]string `json:"tags,omitempty"`
+	SSHKeyName           string            `json:"ssh_key_name,omitempty"`
+	DiscoveryToken       string            `json:"discovery_token,omitempty"`
+	RestoreFromSnapshots map[string]string `json:"restore_from_snapshots,omitempty"`
+	LogLevel             string            `json:"log_level,omitempty"`
+	SnapshotBeforeDelete bool              `json:"snapshot_before_delete,omitempty"`
+	VolumeSize           int               `json:"volume_size,omitempty"`
+	EncryptVolumes       bool              `json:"encrypt_volumes,omitempty"`
+	Timeout              string            `json:"timeout,omitempty"`
+	PostBootWait         string            `json:"post_boot_wait,omitempty"`
+	CopyImageFromRegion  string            `json:"copy_image_from_region,omitempty"`
+	Features             []string          `json:"features,omitempty"`
+	PlacementGroup       string            `json:"placement_group,omitempty"`
+	CreatePlacementGroup bool              `json:"create_placement_group,omitempty"`
+	InstanceNameTemplate string            `json:"instance_name_template,omitempty"`
+	NotifyURL            string            `json:"notify_url,omitempty"`
+	UseSpotInstances     bool              `json:"use_spot_instances,omitempty"`
+	SpotMaxPrice         string            `json:"spot_max_price,omitempty"`
+	SpotFallback         bool              `json:"spot_fallback,omitempty"`
+	Channel              string            `json:"channel,omitempty"`
+	Version              string            `json:"version,omitempty"`
+	DryRun               bool              `json:"dry_run,omitempty"`
 }
 
 type jsonInputCreds struct {
@@ -65,9 +100,21 @@ type httpPrompt struct {
 }
 
 type httpEvent struct {
-	Type        string      `json:"type"`
-	Description string      `json:"description,omitempty"`
-	Prompt      *httpPrompt `json:"prompt,omitempty"`
+	// ID orders the events of an install, increasing by one with each
+	// event, it is used to resume a subscription.
+	ID          int               `json:"id"`
+	Type        string            `json:"type"`
+	Description string            `json:"description,omitempty"`
+	Prompt      *httpPrompt       `json:"prompt,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Percent     int               `json:"percent,omitempty"`
+	Timestamp   time.Time         `json:"timestamp"`
+}
+
+// EventID implements the identifier interface of pkg/sse so that a
+// reconnecting EventSource sends the ID it last received as Last-Event-ID.
+func (e *httpEvent) EventID() string {
+	return strconv.Itoa(e.ID)
 }
 
 type httpInstaller struct {
@@ -76,31 +123,55 @@ type httpInstaller struct {
 	PromptOutChan chan *httpPrompt `json:"-"`
 	PromptInChan  chan *httpPrompt `json:"-"`
 	logger        log.Logger
-	subscribeMtx  sync.Mutex
+	subscribeMtx  sync.RWMutex
 	subscriptions []*httpInstallerSubscription
 	eventsMtx     sync.Mutex
 	events        []*httpEvent
 	done          bool
 	api           *httpAPI
+
+	// installErr is the error the install failed with, if any.
+	installErr error
+
+	// logBuffer is the tail of the install's log, see ClusterLog.
+	logBuffer *clusterLog
 }
 
 type httpInstallerSubscription struct {
+	// EventIndex is the ID of the last event sent to the subscriber.
 	EventIndex int
 	EventChan  chan *httpEvent
 	DoneChan   chan struct{}
 	done       bool
+
+	// stop is closed by Unsubscribe so a send in progress doesn't block
+	// forever on an EventChan which is no longer read.
+	stop chan struct{}
+
+	// sendMtx serializes sendEvents, which is called in a new goroutine for
+	// every event, so that events are sent in order and only once.
+	sendMtx sync.Mutex
 }
 
 func (sub *httpInstallerSubscription) sendEvents(s *httpInstaller) {
+	sub.sendMtx.Lock()
+	defer sub.sendMtx.Unlock()
 	if sub.done {
 		return
 	}
-	for index, event := range s.events {
-		if index <= sub.EventIndex {
+	s.eventsMtx.Lock()
+	events := s.events
+	s.eventsMtx.Unlock()
+	for _, event := range events {
+		if event.ID <= sub.EventIndex {
 			continue
 		}
-		sub.EventIndex = index
-		sub.EventChan <- event
+		select {
+		case sub.EventChan <- event:
+			sub.EventIndex = event.ID
+		case <-sub.stop:
+			return
+		}
 	}
 }
 
@@ -120,39 +191,32 @@ func (prompt *httpPrompt) Resolve(res *httpPrompt) {
 	prompt.resChan <- res
 }
 
-func (s *httpInstaller) YesNoPrompt(msg string) bool {
-	prompt := &httpPrompt{
-		ID:      random.Hex(16),
-		Type:    "yes_no",
-		Message: msg,
-		resChan: make(chan *httpPrompt),
-		api:     s.api,
+func (s *httpInstaller) YesNoPrompt(msg string) (bool, error) {
+	res, err := s.prompt("yes_no", msg)
+	if err != nil {
+		return false, err
 	}
-	prompt.api.InstallerPromptsMtx.Lock()
-	prompt.api.InstallerPrompts[prompt.ID] = prompt
-	prompt.api.InstallerPromptsMtx.Unlock()
-
-	s.sendEvent(&httpEvent{
-		Type:   "prompt",
-		Prompt: prompt,
-	})
-
-	res := <-prompt.resChan
-
-	s.sendEvent(&httpEvent{
-		Type:   "prompt",
-		Prompt: prompt,
-	})
+	return res.Yes, nil
+}
 
-	return res.Yes
+func (s *httpInstaller) PromptInput(msg string) (string, error) {
+	res, err := s.prompt("input", msg)
+	if err != nil {
+		return "", err
+	}
+	return res.Input, nil
 }
 
-func (s *httpInstaller) PromptInput(msg string) string {
+// prompt sends a prompt to subscribers and waits for it to be resolved, or
+// returns ErrCancelled if the install is cancelled first.
+func (s *httpInstaller) prompt(typ, msg string) (*httpPrompt, error) {
 	prompt := &httpPrompt{
 		ID:      random.Hex(16),
-		Type:    "input",
+		Type:    typ,
 		Message: msg,
-		resChan: make(chan *httpPrompt),
+		// buffered so a prompt resolved as the install is cancelled
+		// doesn't block
+		resChan: make(chan *httpPrompt, 1),
 		api:     s.api,
 	}
 	s.api.InstallerPromptsMtx.Lock()
@@ -164,24 +228,39 @@ func (s *httpInstaller) PromptInput(msg string) string {
 		Prompt: prompt,
 	})
 
-	res := <-prompt.resChan
+	var res *httpPrompt
+	select {
+	case res = <-prompt.resChan:
+	case <-s.Stack.cancelChan():
+		s.api.InstallerPromptsMtx.Lock()
+		delete(s.api.InstallerPrompts, prompt.ID)
+		s.api.InstallerPromptsMtx.Unlock()
+		return nil, ErrCancelled
+	}
 
 	s.sendEvent(&httpEvent{
 		Type:   "prompt",
 		Prompt: prompt,
 	})
 
-	return res.Input
+	return res, nil
 }
 
 func (s *httpInstaller) Subscribe(eventChan chan *httpEvent) <-chan struct{} {
+	return s.subscribeSince(eventChan, -1)
+}
+
+// subscribeSince subscribes to the events after the one with the given ID,
+// or to all of them if it is -1.
+func (s *httpInstaller) subscribeSince(eventChan chan *httpEvent, since int) <-chan struct{} {
 	s.subscribeMtx.Lock()
 	defer s.subscribeMtx.Unlock()
 
 	subscription := &httpInstallerSubscription{
-		EventIndex: -1,
+		EventIndex: since,
 		EventChan:  eventChan,
 		DoneChan:   make(chan struct{}),
+		stop:       make(chan struct{}),
 	}
 
 	go func() {
@@ -196,17 +275,85 @@ func (s *httpInstaller) Subscribe(eventChan chan *httpEvent) <-chan struct{} {
 	return subscription.DoneChan
 }
 
+func (s *httpInstaller) Unsubscribe(eventChan chan *httpEvent) {
+	s.subscribeMtx.Lock()
+	defer s.subscribeMtx.Unlock()
+
+	for i, sub := range s.subscriptions {
+		if sub.EventChan == eventChan {
+			s.subscriptions = append(s.subscriptions[:i], s.subscriptions[i+1:]...)
+			close(sub.stop)
+			return
+		}
+	}
+}
+
+func (s *httpInstaller) HasSubscribers() bool {
+	s.subscribeMtx.RLock()
+	defer s.subscribeMtx.RUnlock()
+	return len(s.subscriptions) > 0
+}
+
+// snapshotSubscriptions returns a copy of the subscriptions so they can be
+// iterated without holding subscribeMtx.
+func (s *httpInstaller) snapshotSubscriptions() []*httpInstallerSubscription {
+	s.subscribeMtx.RLock()
+	defer s.subscribeMtx.RUnlock()
+	subs := make([]*httpInstallerSubscription, len(s.subscriptions))
+	copy(subs, s.subscriptions)
+	return subs
+}
+
 func (s *httpInstaller) sendEvent(event *httpEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = s.Stack.now()
+	}
+	if len(s.Stack.Metadata) > 0 {
+		metadata := make(map[string]string, len(s.Stack.Metadata)+len(event.Metadata))
+		for k, v := range s.Stack.Metadata {
+			metadata[k] = v
+		}
+		for k, v := range event.Metadata {
+			metadata[k] = v
+		}
+		event.Metadata = metadata
+	}
+
+	s.logger.Debug("sending event", "type", event.Type)
+	// events are persisted while holding eventsMtx so concurrent events are
+	// stored in ID order. An install loaded from disk only has its durable
+	// events, so IDs follow on from the last event rather than being
+	// positions.
 	s.eventsMtx.Lock()
+	event.ID = 0
+	if n := len(s.events); n > 0 {
+		event.ID = s.events[n-1].ID + 1
+	}
 	s.events = append(s.events, event)
+	if err := persistEvent(s.ID, event); err != nil {
+		s.logger.Error("error persisting event", "type", event.Type, "err", err)
+	}
+	if s.api != nil {
+		s.api.publish(s.ID, event)
+	}
 	s.eventsMtx.Unlock()
 
-	for _, sub := range s.subscriptions {
+	for _, sub := range s.snapshotSubscriptions() {
 		go sub.sendEvents(s)
 	}
+	if s.api != nil && s.api.eventSinks != nil {
+		for _, name := range s.api.eventSinks.send(s.ID, event) {
+			s.logger.Warn("event sink is lagging, dropping events", "sink", name)
+			s.sendEvent(&httpEvent{
+				Type:        "sink_lagging",
+				Description: fmt.Sprintf("WARNING: Event sink %s is lagging, events are being dropped", name),
+			})
+		}
+	}
 }
 
 func (s *httpInstaller) handleError(err error) {
+	s.installErr = err
 	s.sendEvent(&httpEvent{
 		Type:        "error",
 		Description: err.Error(),
@@ -236,30 +383,78 @@ func (s *httpInstaller) handleDone() {
 		Type: "done",
 	})
 
-	for _, sub := range s.subscriptions {
+	for _, sub := range s.snapshotSubscriptions() {
 		go sub.handleDone()
 	}
+	s.saveLogTail()
+
+	if s.Stack.NotifyURL != "" && !s.Stack.DryRun {
+		go func() {
+			if err := s.notify(); err != nil {
+				s.logger.Error("error calling notify URL", "url", s.Stack.NotifyURL, "err", err)
+			}
+		}()
+	}
+}
+
+// forwardEvent sends an event from the stack to subscribers.
+func (s *httpInstaller) forwardEvent(event *Event) {
+	s.logger.Info(event.Description)
+	eventType := event.Type
+	if eventType == "" {
+		eventType = "status"
+	}
+	s.sendEvent(&httpEvent{
+		Type:        eventType,
+		Description: event.Description,
+		Metadata:    event.Metadata,
+		Percent:     event.Percent,
+	})
+}
+
+// pumpEvents forwards the events sent by the stack until the returned
+// function is called, for operations on an install which has finished and
+// so is no longer running handleEvents. The function returns once the
+// events already sent have been forwarded.
+func (s *httpInstaller) pumpEvents() func() {
+	eventChan := make(chan *Event)
+	s.Stack.EventChan = eventChan
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case e := <-eventChan:
+				s.forwardEvent(e)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
 }
 
 func (s *httpInstaller) handleEvents() {
+	// the channels are those of the install being run, a retry of it
+	// replaces them
+	events, errs, done := s.Stack.EventChan, s.Stack.ErrChan, s.Stack.doneChan()
 	for {
 		select {
-		case event := <-s.Stack.EventChan:
-			s.logger.Info(event.Description)
-			s.sendEvent(&httpEvent{
-				Type:        "status",
-				Description: event.Description,
-			})
-		case err := <-s.Stack.ErrChan:
+		case event := <-events:
+			s.forwardEvent(event)
+		case err := <-errs:
 			s.logger.Error(err.Error())
 			s.handleError(err)
-		case <-s.Stack.Done:
+		case <-done:
 			s.handleDone()
-			msg, err := s.Stack.DashboardLoginMsg()
-			if err != nil {
-				panic(err)
+			// there is no dashboard to log in to if the install failed
+			if msg, err := s.Stack.DashboardLoginMsg(); err == nil {
+				s.logger.Info(msg)
 			}
-			s.logger.Info(msg)
 			return
 		}
 	}
@@ -269,15 +464,41 @@ type httpAPI struct {
 	InstallerPrompts    map[string]*httpPrompt
 	InstallerPromptsMtx sync.Mutex
 	InstallerStacks     map[string]*httpInstaller
-	InstallerStackMtx   sync.Mutex
+	InstallerStackMtx   sync.RWMutex
 	AWSEnvCreds         aws.CredentialsProvider
+	logSinks            *logSinks
+	eventSinks          *eventSinks
+	subscriptions       []*ClusterSubscription
+	subscriptionsMtx    sync.RWMutex
+	queue               JobQueue
+	tracer              Tracer
+
+	// webhookClient calls the NotifyURL of installs, if nil a client with
+	// webhookTimeout is used.
+	webhookClient *http.Client
+
+	launchSlots     chan struct{}
+	launchSlotsOnce sync.Once
+
+	// launching has the IDs of the installs being launched, whose inputs
+	// are validated without holding InstallerStackMtx as that calls AWS,
+	// mapped to a channel closed once the launch has started or failed. It
+	// is guarded by InstallerStackMtx.
+	launching map[string]chan struct{}
 }
 
 func ServeHTTP() error {
 	api := &httpAPI{
 		InstallerPrompts: make(map[string]*httpPrompt),
 		InstallerStacks:  make(map[string]*httpInstaller),
+		logSinks:         newLogSinks(),
+		eventSinks:       newEventSinks(),
+		queue:            newFileJobQueue(queueDir),
 	}
+	if err := api.ResumeClusters(); err != nil {
+		log.Error("error resuming clusters", "err", err)
+	}
+	api.resumeJobs()
 
 	if creds, err := aws.EnvCreds(); err == nil {
 		api.AWSEnvCreds = creds
@@ -290,8 +511,14 @@ func ServeHTTP() error {
 	httpRouter.GET("/install/:id", api.ServeTemplate)
 	httpRouter.DELETE("/install/:id", api.AbortInstallHandler)
 	httpRouter.POST("/install", api.InstallHandler)
+	httpRouter.POST("/install/:id/clone", api.CloneHandler)
+	httpRouter.POST("/install/:id/cancel", api.CancelInstallHandler)
+	httpRouter.GET("/events", api.EventStreamHandler)
 	httpRouter.GET("/events/:id", api.EventsHandler)
+	httpRouter.GET("/ws", api.WebSocketHandler)
 	httpRouter.POST("/prompt/:id", api.PromptHandler)
+	httpRouter.POST("/backup", api.BackupHandler)
+	httpRouter.POST("/restore", api.RestoreHandler)
 	httpRouter.GET("/assets/*assetPath", api.ServeAsset)
 
 	l, err := net.Listen("tcp", "127.0.0.1:0")
@@ -304,6 +531,33 @@ func ServeHTTP() error {
 	return http.Serve(l, api.CorsHandler(httpRouter, addr))
 }
 
+// installLogger returns a logger for an install which only logs messages at
+// or above the given level, defaulting to info, along with the tail of the
+// install's log at every level.
+func (api *httpAPI) installLogger(id, level string) (log.Logger, *clusterLog, error) {
+	lvl := log.LvlInfo
+	if level != "" {
+		var err error
+		lvl, err = log.LvlFromString(level)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	logger := log.New("install", id)
+	// a resumed install carries on from the log of its previous run
+	previous, _ := readClusterLogTail(id)
+	buffer := newClusterLog(previous)
+	handler := log.MultiHandler(log.LvlFilterHandler(lvl, api.logSinks), buffer)
+	// keep a log of each install for diagnostic bundles
+	if err := os.MkdirAll(clustersDir, 0755); err == nil {
+		if fh, err := log.FileHandler(clusterLogPath(id), log.LogfmtFormat()); err == nil {
+			handler = log.MultiHandler(handler, log.LvlFilterHandler(log.LvlDebug, fh))
+		}
+	}
+	logger.SetHandler(handler)
+	return logger, buffer, nil
+}
+
 func (api *httpAPI) CorsHandler(main http.Handler, addr string) http.Handler {
 	corsHandler := cors.Allow(&cors.Options{
 		AllowOrigins:     []string{addr},
@@ -346,78 +600,201 @@ func (api *httpAPI) InstallHandler(w http.ResponseWriter, req *http.Request, par
 		httphelper.Error(w, err)
 		return
 	}
-	api.InstallerStackMtx.Lock()
-	defer api.InstallerStackMtx.Unlock()
-
-	if len(api.InstallerStacks) > 0 {
-		httphelper.ObjectExistsError(w, "install already started")
+	s, err := api.launch(input)
+	if e, ok := err.(*AlreadyExistsError); ok {
+		err = httphelper.JSONError{Code: httphelper.ObjectExistsErrorCode, Message: e.Error()}
+	}
+	if err != nil {
+		httphelper.Error(w, err)
 		return
 	}
+	httphelper.JSON(w, 200, s)
+}
 
-	var id = random.Hex(16)
-	var creds aws.CredentialsProvider
-	if input.Creds.AccessKeyID != "" && input.Creds.SecretAccessKey != "" {
-		creds = aws.Creds(input.Creds.AccessKeyID, input.Creds.SecretAccessKey, "")
-	} else {
-		var err error
-		creds, err = aws.EnvCreds()
+func validationErr(field, message string) error {
+	err := httphelper.JSONError{Code: httphelper.ValidationErrorCode, Message: message}
+	if field != "" {
+		err.Message = fmt.Sprintf("%s %s", field, message)
+		err.Detail, _ = json.Marshal(map[string]string{"field": field})
+	}
+	return err
+}
+
+// launchCluster starts an install with the given ID from the given input.
+// If a concurrent launch with the same ID has already started the install,
+// that install is returned along with true.
+func (api *httpAPI) launchCluster(id string, input *jsonInput) (*httpInstaller, bool, error) {
+	// reserve the ID, waiting for a concurrent launch with it to finish
+	for {
+		api.InstallerStackMtx.Lock()
+		if inst, err := api.existingLaunch(id); err != nil || inst != nil {
+			api.InstallerStackMtx.Unlock()
+			return inst, inst != nil, err
+		}
+		wait, ok := api.launching[id]
+		if !ok {
+			break
+		}
+		api.InstallerStackMtx.Unlock()
+		<-wait
+	}
+	if api.launching == nil {
+		api.launching = make(map[string]chan struct{})
+	}
+	launched := make(chan struct{})
+	api.launching[id] = launched
+	api.InstallerStackMtx.Unlock()
+	defer func() {
+		api.InstallerStackMtx.Lock()
+		delete(api.launching, id)
+		api.InstallerStackMtx.Unlock()
+		close(launched)
+	}()
+
+	logger, logBuffer, err := api.installLogger(id, input.LogLevel)
+	if err != nil {
+		return nil, false, validationErr("log_level", err.Error())
+	}
+	var timeout time.Duration
+	if input.Timeout != "" {
+		timeout, err = time.ParseDuration(input.Timeout)
 		if err != nil {
-			httphelper.ValidationError(w, "", err.Error())
-			return
+			return nil, false, validationErr("timeout", err.Error())
+		}
+	}
+	var postBootWait time.Duration
+	if input.PostBootWait != "" {
+		postBootWait, err = time.ParseDuration(input.PostBootWait)
+		if err != nil {
+			return nil, false, validationErr("post_boot_wait", err.Error())
 		}
 	}
+	creds, err := inputCredentials(input)
+	if err != nil {
+		return nil, false, err
+	}
 	s := &httpInstaller{
 		ID:            id,
 		PromptOutChan: make(chan *httpPrompt),
 		PromptInChan:  make(chan *httpPrompt),
-		logger:        log.New(),
+		logger:        logger,
+		logBuffer:     logBuffer,
 		api:           api,
 	}
 	s.Stack = &Stack{
-		Creds:        creds,
-		Region:       input.Region,
-		InstanceType: input.InstanceType,
-		NumInstances: input.NumInstances,
-		VpcCidr:      input.VpcCidr,
-		SubnetCidr:   input.SubnetCidr,
-		PromptInput:  s.PromptInput,
-		YesNoPrompt:  s.YesNoPrompt,
+		ID:                   id,
+		Creds:                creds,
+		CredentialID:         input.CredentialID,
+		Region:               input.Region,
+		InstanceType:         input.InstanceType,
+		NumInstances:         input.NumInstances,
+		VpcCidr:              input.VpcCidr,
+		SubnetCidr:           input.SubnetCidr,
+		VpcID:                input.VpcID,
+		SubnetID:             input.SubnetID,
+		BootstrapManifest:    input.BootstrapManifest,
+		DNSProvider:          input.DNSProvider,
+		DomainName:           input.DomainName,
+		DNSZoneID:            input.DNSZoneID,
+		Metadata:             input.Metadata,
+		Tags:                 input.Tags,
+		SSHKeyName:           input.SSHKeyName,
+		DiscoveryToken:       input.DiscoveryToken,
+		RestoreFromSnapshots: input.RestoreFromSnapshots,
+		LogLevel:             input.LogLevel,
+		SnapshotBeforeDelete: input.SnapshotBeforeDelete,
+		VolumeSize:           input.VolumeSize,
+		EncryptVolumes:       input.EncryptVolumes,
+		Timeout:              timeout,
+		PostBootWait:         postBootWait,
+		CopyImageFromRegion:  input.CopyImageFromRegion,
+		Features:             input.Features,
+		PlacementGroup:       input.PlacementGroup,
+		CreatePlacementGroup: input.CreatePlacementGroup,
+		InstanceNameTemplate: input.InstanceNameTemplate,
+		NotifyURL:            input.NotifyURL,
+		UseSpotInstances:     input.UseSpotInstances,
+		SpotMaxPrice:         input.SpotMaxPrice,
+		SpotFallback:         input.SpotFallback,
+		Channel:              input.Channel,
+		Version:              input.Version,
+		DryRun:               input.DryRun,
+		Tracer:               api.tracer,
+		launchSlots:          api.launchSemaphore(),
+		PromptInput:          s.PromptInput,
+		YesNoPrompt:          s.YesNoPrompt,
+		HasSubscribers:       s.HasSubscribers,
 	}
 	if err := s.Stack.RunAWS(); err != nil {
-		httphelper.Error(w, err)
-		return
+		return nil, false, err
 	}
+	api.InstallerStackMtx.Lock()
 	api.InstallerStacks[id] = s
+	api.InstallerStackMtx.Unlock()
 	go s.handleEvents()
-	httphelper.JSON(w, 200, s)
+	return s, false, nil
+}
+
+// inputCredentials returns the AWS credentials an install is launched with,
+// given either in the input, by the ID of stored credentials or by the
+// environment.
+func inputCredentials(input *jsonInput) (aws.CredentialsProvider, error) {
+	if input.Creds.AccessKeyID != "" && input.Creds.SecretAccessKey != "" {
+		return aws.Creds(input.Creds.AccessKeyID, input.Creds.SecretAccessKey, ""), nil
+	}
+	if input.CredentialID != "" {
+		creds, err := FindAWSCredentials(input.CredentialID)
+		if err != nil {
+			return nil, validationErr("credential_id", err.Error())
+		}
+		return creds, nil
+	}
+	creds, err := envCredentials()
+	if err != nil {
+		return nil, validationErr("", err.Error())
+	}
+	return creds, nil
 }
 
 func (api *httpAPI) AbortInstallHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
-	api.InstallerStackMtx.Lock()
-	defer api.InstallerStackMtx.Unlock()
-	id := params.ByName("id")
-	s := api.InstallerStacks[id]
-	if s == nil {
+	if err := api.delete(params.ByName("id")); err == ErrClusterNotFound {
 		w.WriteHeader(404)
 		return
+	} else if err != nil {
+		httphelper.Error(w, err)
+		return
 	}
-	// TODO(jvatic): Cleanup stack
-	delete(api.InstallerStacks, params.ByName("id"))
 	w.WriteHeader(200)
 }
 
+// EventsHandler streams the events of an install, starting after the event
+// with the ID given by the Last-Event-ID header or the since parameter. The
+// events of an install which isn't running in this process are replayed from
+// disk before the stream is closed.
 func (api *httpAPI) EventsHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
-	api.InstallerStackMtx.Lock()
+	since := -1
+	if id := req.Header.Get("Last-Event-ID"); id != "" {
+		since, _ = strconv.Atoi(id)
+	} else if id := req.URL.Query().Get("since"); id != "" {
+		since, _ = strconv.Atoi(id)
+	}
+
+	api.InstallerStackMtx.RLock()
 	s := api.InstallerStacks[params.ByName("id")]
-	api.InstallerStackMtx.Unlock()
+	api.InstallerStackMtx.RUnlock()
 	if s == nil {
-		httphelper.ObjectNotFoundError(w, "install instance not found")
+		api.replayEvents(w, req, params.ByName("id"), since)
 		return
 	}
 
 	eventChan := make(chan *httpEvent)
-	doneChan := s.Subscribe(eventChan)
+	doneChan := s.subscribeSince(eventChan, since)
 
+	if acceptsGzip(req) {
+		gw := newGzipResponseWriter(w)
+		defer gw.Close()
+		w = gw
+	}
 	stream := sse.NewStream(w, eventChan, s.logger)
 	stream.Serve()
 
@@ -434,6 +811,60 @@ func (api *httpAPI) EventsHandler(w http.ResponseWriter, req *http.Request, para
 	}()
 
 	stream.Wait()
+	s.Unsubscribe(eventChan)
+}
+
+func (api *httpAPI) replayEvents(w http.ResponseWriter, req *http.Request, id string, since int) {
+	if _, err := api.FindCluster(id); err != nil {
+		httphelper.ObjectNotFoundError(w, "install instance not found")
+		return
+	}
+	events, err := loadEvents(id)
+	if err != nil {
+		httphelper.Error(w, err)
+		return
+	}
+	eventChan := make(chan *httpEvent, len(events))
+	for _, e := range events {
+		if e.ID > since {
+			eventChan <- e
+		}
+	}
+	close(eventChan)
+	sse.ServeStream(w, eventChan, log.New("install", id))
+}
+
+// snapshotClusters returns the installs in progress, taking the lock only
+// for as long as it takes to copy them.
+func (api *httpAPI) snapshotClusters() []*httpInstaller {
+	api.InstallerStackMtx.RLock()
+	defer api.InstallerStackMtx.RUnlock()
+	clusters := make([]*httpInstaller, 0, len(api.InstallerStacks))
+	for _, s := range api.InstallerStacks {
+		clusters = append(clusters, s)
+	}
+	return clusters
+}
+
+// FindCluster returns the stack of the install with the given ID, either one
+// in progress or a previous one loaded from disk.
+func (api *httpAPI) FindCluster(id string) (*Stack, error) {
+	api.InstallerStackMtx.RLock()
+	s := api.InstallerStacks[id]
+	api.InstallerStackMtx.RUnlock()
+	if s != nil {
+		return s.Stack, nil
+	}
+	return clusterCache.Load(id, func() (*Stack, error) {
+		return loadCluster(id)
+	})
+}
+
+func (api *httpAPI) HasSubscribers(id string) bool {
+	api.InstallerStackMtx.RLock()
+	s := api.InstallerStacks[id]
+	api.InstallerStackMtx.RUnlock()
+	return s != nil && s.HasSubscribers()
 }
 
 func (api *httpAPI) PromptHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
@@ -496,15 +927,9 @@ func (api *httpAPI) ServeAsset(w http.ResponseWriter, req *http.Request, params
 
 func (api *httpAPI) ServeTemplate(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
 	if req.Header.Get("Accept") == "application/json" {
-		api.InstallerStackMtx.Lock()
+		api.InstallerStackMtx.RLock()
 		s := api.InstallerStacks[params.ByName("id")]
-		if s == nil && len(api.InstallerStacks) > 0 {
-			for id := range api.InstallerStacks {
-				s = api.InstallerStacks[id]
-				break
-			}
-		}
-		api.InstallerStackMtx.Unlock()
+		api.InstallerStackMtx.RUnlock()
 		if s == nil {
 			w.WriteHeader(404)
 			return
@@ -0,0 +1,130 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/aws"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/ec2"
+	"github.com/flynn/flynn/pkg/attempt"
+)
+
+// imageCopyAttempts is how long to wait for an image to be copied between
+// regions, which typically takes 10-20 minutes.
+var imageCopyAttempts = attempt.Strategy{
+	Total: 45 * time.Minute,
+	Delay: 15 * time.Second,
+}
+
+// copyImage copies the image with the given ID from CopyImageFromRegion to
+// the stack's region, waits for it to become available and sets ImageID to
+// the copy. A copy made by a previous install is reused.
+func (s *Stack) copyImage(sourceID string) error {
+	source := ec2.New(s.Creds, s.CopyImageFromRegion, nil)
+	res, err := source.DescribeImages(&ec2.DescribeImagesRequest{ImageIDs: []string{sourceID}})
+	if err != nil {
+		return fmt.Errorf("Unable to find image %s in %s: %s", sourceID, s.CopyImageFromRegion, err)
+	}
+	if len(res.Images) == 0 || res.Images[0].Name == nil {
+		return fmt.Errorf("Image %s does not exist in %s", sourceID, s.CopyImageFromRegion)
+	}
+	name := *res.Images[0].Name
+	metadata := map[string]string{
+		"source_region": s.CopyImageFromRegion,
+		"source_image":  sourceID,
+	}
+
+	var existing *ec2.DescribeImagesResult
+	err = s.retryAWS("DescribeImages", func() (err error) {
+		existing, err = s.ec2.DescribeImages(&ec2.DescribeImagesRequest{
+			Owners:  []string{"self"},
+			Filters: []ec2.Filter{{Name: aws.String("name"), Values: []string{name}}},
+		})
+		return
+	})
+	if err != nil {
+		return err
+	}
+	var imageID string
+	for _, i := range existing.Images {
+		if i.ImageID != nil && i.State != nil && *i.State != "failed" {
+			imageID = *i.ImageID
+			break
+		}
+	}
+
+	if imageID == "" {
+		req := &ec2.CopyImageRequest{
+			ClientToken:   aws.String(s.ID),
+			Description:   aws.String(fmt.Sprintf("Copy of %s from %s", sourceID, s.CopyImageFromRegion)),
+			Name:          aws.String(name),
+			SourceImageID: aws.String(sourceID),
+			SourceRegion:  aws.String(s.CopyImageFromRegion),
+		}
+		// a dry run fails with DryRunOperation if the copy is permitted
+		req.DryRun = aws.Boolean(true)
+		_, err := s.ec2.CopyImage(req)
+		if apiErr, ok := err.(aws.APIError); !ok || apiErr.Code != "DryRunOperation" {
+			if err == nil {
+				err = errors.New("unexpected success")
+			}
+			return fmt.Errorf("Unable to copy image %s from %s: %s", sourceID, s.CopyImageFromRegion, err)
+		}
+		if s.DryRun {
+			s.wouldCreate(&DryRunResource{
+				Type: "AWS::EC2::Image",
+				Name: name,
+				Size: volumeSizeString(imageRootVolumeSize(res.Images[0])),
+			})
+			return nil
+		}
+		req.DryRun = nil
+		var copied *ec2.CopyImageResult
+		err = s.retryAWS("CopyImage", func() (err error) {
+			copied, err = s.ec2.CopyImage(req)
+			return
+		})
+		if err != nil {
+			return err
+		}
+		imageID = *copied.ImageID
+	}
+	metadata["image"] = imageID
+
+	s.sendTypedEvent("ami_copying", fmt.Sprintf("Copying image %s from %s to %s (%s)", sourceID, s.CopyImageFromRegion, s.Region, imageID), metadata)
+	if err := s.waitForImage(imageID); err != nil {
+		return err
+	}
+	s.ImageID = imageID
+	s.sendTypedEvent("ami_ready", fmt.Sprintf("Image %s is available in %s", imageID, s.Region), metadata)
+	return nil
+}
+
+func (s *Stack) waitForImage(imageID string) error {
+	var err error
+	for a := imageCopyAttempts.Start(); a.Next(); {
+		if s.cancelled() {
+			return ErrCancelled
+		}
+		var res *ec2.DescribeImagesResult
+		res, err = s.ec2.DescribeImages(&ec2.DescribeImagesRequest{ImageIDs: []string{imageID}})
+		if err != nil || len(res.Images) == 0 || res.Images[0].State == nil {
+			continue
+		}
+		switch image := res.Images[0]; *image.State {
+		case "available":
+			return nil
+		case "failed":
+			reason := "unknown reason"
+			if image.StateReason != nil && image.StateReason.Message != nil {
+				reason = *image.StateReason.Message
+			}
+			return fmt.Errorf("Copying image %s failed: %s", imageID, reason)
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("still pending after %s", imageCopyAttempts.Total)
+	}
+	return fmt.Errorf("Timed out waiting for image %s: %s", imageID, err)
+}
@@ -0,0 +1,154 @@
+package installer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/aws"
+)
+
+// The types of install failure, as given by InstallErrorType.
+const (
+	InstallErrorCredentials   = "credentials"
+	InstallErrorQuota         = "quota"
+	InstallErrorStackRollback = "stack_rollback"
+	InstallErrorSpot          = "spot_unavailable"
+	InstallErrorBootstrap     = "bootstrap"
+	InstallErrorTimeout       = "timeout"
+	InstallErrorCancelled     = "cancelled"
+	InstallErrorUnknown       = "unknown"
+)
+
+// CredentialError is returned when an install fails because AWS rejected
+// its credentials, or they have expired.
+type CredentialError struct {
+	Err error
+}
+
+func (e *CredentialError) Error() string {
+	return fmt.Sprintf("AWS rejected the credentials: %s", e.Err)
+}
+
+// QuotaError is returned when an install fails because it would exceed one
+// of the account's AWS limits, such as the number of instances or VPCs.
+type QuotaError struct {
+	Err error
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("AWS account limit exceeded: %s", e.Err)
+}
+
+// StackRollbackError is returned when the CloudFormation stack fails to be
+// created or updated. Reason is that of the first resource which failed, if
+// known.
+type StackRollbackError struct {
+	StackName string
+	Status    string
+	Reason    string
+}
+
+func (e *StackRollbackError) Error() string {
+	msg := fmt.Sprintf("Failed to create stack %s", e.StackName)
+	if e.Status != "" {
+		msg += fmt.Sprintf(" (%s)", e.Status)
+	}
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// BootstrapError is returned when bootstrapping the cluster on its instances
+// fails.
+type BootstrapError struct {
+	Err error
+}
+
+func (e *BootstrapError) Error() string {
+	return e.Err.Error()
+}
+
+// awsCredentialErrorCodes and awsQuotaErrorCodes are the AWS error codes of
+// rejected credentials and exceeded limits.
+var (
+	awsCredentialErrorCodes = map[string]bool{
+		"AuthFailure":                 true,
+		"InvalidClientTokenId":        true,
+		"SignatureDoesNotMatch":       true,
+		"UnrecognizedClientException": true,
+		"ExpiredToken":                true,
+		"ExpiredTokenException":       true,
+		"RequestExpired":              true,
+		"AccessDenied":                true,
+		"UnauthorizedOperation":       true,
+		"OptInRequired":               true,
+	}
+	awsQuotaErrorCodes = map[string]bool{
+		"InstanceLimitExceeded":        true,
+		"VcpuLimitExceeded":            true,
+		"LimitExceeded":                true,
+		"LimitExceededException":       true,
+		"AddressLimitExceeded":         true,
+		"VpcLimitExceeded":             true,
+		"SecurityGroupLimitExceeded":   true,
+		"InternetGatewayLimitExceeded": true,
+		"VolumeLimitExceeded":          true,
+		"MaxSpotInstanceCountExceeded": true,
+	}
+)
+
+// classifyInstallError returns the typed error for an install which failed
+// with err in the given step, or err itself if it has no specific type.
+func classifyInstallError(step string, err error) error {
+	switch e := err.(type) {
+	case nil, *CredentialError, *QuotaError, *BootstrapError:
+		return err
+	case aws.APIError:
+		if awsCredentialErrorCodes[e.Code] {
+			return &CredentialError{Err: err}
+		}
+		if awsQuotaErrorCodes[e.Code] {
+			return &QuotaError{Err: err}
+		}
+	case *StackRollbackError:
+		// the stack rolls back when a resource exceeds a limit
+		for code := range awsQuotaErrorCodes {
+			if strings.Contains(e.Reason, code) {
+				return &QuotaError{Err: err}
+			}
+		}
+		return err
+	}
+	switch {
+	case err == ErrCredentialsExpired:
+		return &CredentialError{Err: err}
+	case step == "bootstrap" && err != ErrCancelled && err != ErrTimeout:
+		return &BootstrapError{Err: err}
+	}
+	return err
+}
+
+// InstallErrorType returns the type of the install failure err, one of the
+// InstallError constants, for clients to show remediation advice for it.
+func InstallErrorType(err error) string {
+	switch err.(type) {
+	case *CredentialError:
+		return InstallErrorCredentials
+	case *QuotaError:
+		return InstallErrorQuota
+	case *StackRollbackError:
+		return InstallErrorStackRollback
+	case *SpotRequestError:
+		return InstallErrorSpot
+	case *BootstrapError:
+		return InstallErrorBootstrap
+	}
+	switch err {
+	case ErrTimeout:
+		return InstallErrorTimeout
+	case ErrCancelled:
+		return InstallErrorCancelled
+	}
+	return InstallErrorUnknown
+}
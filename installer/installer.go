@@ -7,6 +7,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -20,7 +22,6 @@ import (
 	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/aws"
 	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/cloudformation"
 	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/ec2"
-	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/route53"
 	"github.com/flynn/flynn/Godeps/_workspace/src/golang.org/x/crypto/ssh"
 	cfg "github.com/flynn/flynn/cli/config"
 	"github.com/flynn/flynn/pkg/awsutil"
@@ -30,19 +31,96 @@ import (
 )
 
 type Event struct {
+	// Type is the type of the event sent to subscribers, it defaults to
+	// "status".
+	Type        string
 	Description string
+	Metadata    map[string]string
+
+	// Percent is the progress of the install, set on the events marking
+	// provisioning milestones.
+	Percent int
 }
 
+var ErrTimeout = errors.New("installer: install timed out")
+var ErrCancelled = errors.New("installer: install cancelled")
+
 var DisallowedEC2InstanceTypes = []string{"t1.micro", "t2.micro", "t2.small", "m1.small"}
 var DefaultInstanceType = "m3.medium"
 
+// DeprecatedInstanceFamilies maps previous generation instance families to
+// the current generation family which replaces them, it should be updated as
+// AWS retires families.
+var DeprecatedInstanceFamilies = map[string]string{
+	"c1":  "c5",
+	"c3":  "c5",
+	"cc2": "c5",
+	"cg1": "g3",
+	"cr1": "r5",
+	"g2":  "g3",
+	"hi1": "i3",
+	"hs1": "d2",
+	"i2":  "i3",
+	"m1":  "m5",
+	"m2":  "r5",
+	"m3":  "m5",
+	"r3":  "r5",
+	"t1":  "t3",
+}
+
+// DefaultTimeout is the time an install may take before it is cancelled, and
+// MinTimeout the shortest timeout which may be requested.
+var DefaultTimeout = time.Hour
+var MinTimeout = 10 * time.Minute
+
+// ContainerIPsPerInstance is the estimated number of container IP addresses
+// allocated from the subnet for each instance.
+var ContainerIPsPerInstance = 100
+
+// ErrSubnetTooSmall is returned when the subnet doesn't have enough usable
+// addresses for the instances and their containers.
+type ErrSubnetTooSmall struct {
+	Subnet    string
+	Required  int
+	Available int
+}
+
+func (e *ErrSubnetTooSmall) Error() string {
+	return fmt.Sprintf("Subnet %s has %d usable addresses, %d are required", e.Subnet, e.Available, e.Required)
+}
+
+// ErrVpcCidrOverlap is returned when the VPC CIDR overlaps that of an
+// existing VPC in the region.
+type ErrVpcCidrOverlap struct {
+	Cidr    string
+	VpcID   string
+	VpcCidr string
+}
+
+func (e *ErrVpcCidrOverlap) Error() string {
+	return fmt.Sprintf("VPC CIDR %s overlaps %s of existing VPC %s", e.Cidr, e.VpcCidr, e.VpcID)
+}
+
 type Stack struct {
-	Region       string                  `json:"region,omitempty"`
-	NumInstances int                     `json:"num_instances,omitempty"`
-	InstanceType string                  `json:"instance_type,omitempty"`
-	Creds        aws.CredentialsProvider `json:"-"`
-	YesNoPrompt  func(string) bool       `json:"-"`
-	PromptInput  func(string) string     `json:"-"`
+	ID           string                       `json:"id,omitempty"`
+	Region       string                       `json:"region,omitempty"`
+	NumInstances int                          `json:"num_instances,omitempty"`
+	InstanceType string                       `json:"instance_type,omitempty"`
+	Creds        aws.CredentialsProvider      `json:"-"`
+	YesNoPrompt  func(string) (bool, error)   `json:"-"`
+	PromptInput  func(string) (string, error) `json:"-"`
+
+	// CredentialID is the ID of the stored credentials the cluster was
+	// launched with, if any.
+	CredentialID string `json:"credential_id,omitempty"`
+
+	// HasSubscribers reports whether anyone is watching the install, if nil
+	// it is assumed that someone is.
+	HasSubscribers func() bool `json:"-"`
+
+	// Clock stamps events and times the install phases, if nil the wall
+	// clock is used.
+	Clock Clock `json:"-"`
 
 	ControllerKey       string  `json:"controller_key,omitempty"`
 	ControllerPin       string  `json:"controller_pin,omitempty"`
@@ -50,6 +128,14 @@ type Stack struct {
 	Domain              *Domain `json:"domain"`
 	CACert              string  `json:"ca_cert"`
 
+	// MalformedControllerPin is set when a saved cluster's controller pin
+	// or CA certificate is malformed, the controller can't be verified
+	// until it is reinstalled.
+	MalformedControllerPin bool `json:"malformed_controller_pin,omitempty"`
+
+	// Done is closed once the install has finished. It is replaced when a
+	// failed install is retried, so is read with doneChan once the stack
+	// has been added to InstallerStacks.
 	EventChan chan *Event   `json:"-"`
 	ErrChan   chan error    `json:"-"`
 	Done      chan struct{} `json:"-"`
@@ -65,46 +151,256 @@ type Stack struct {
 	DiscoveryToken string                `json:"discovery_token"`
 	InstanceIPs    []string              `json:"instance_ips,omitempty"`
 	DNSZoneID      string                `json:"dns_zone_id,omitempty"`
+	DNSProvider    string                `json:"dns_provider,omitempty"`
+
+	// GeneratedSSHKey is set if the SSHKeyName key pair was generated for
+	// the cluster, rather than given, so is deleted along with it.
+	GeneratedSSHKey bool `json:"generated_ssh_key,omitempty"`
+
+	// DomainName is a domain of the user's to use instead of allocating
+	// one. It is served from the Route53 hosted zone DNSZoneID, or the zone
+	// for it in the account, which is created if there isn't one.
+	// CreatedDNSZone is set if the zone was created for the cluster, so is
+	// deleted along with it.
+	DomainName     string `json:"domain_name,omitempty"`
+	CreatedDNSZone bool   `json:"created_dns_zone,omitempty"`
+
+	// VpcID and SubnetID are an existing VPC and subnet of it to launch the
+	// instances into, instead of the stack creating them from VpcCidr and
+	// SubnetCidr. They aren't deleted with the stack.
+	VpcID    string `json:"vpc_id,omitempty"`
+	SubnetID string `json:"subnet_id,omitempty"`
+
+	// RestoreFromSnapshots maps instance names (Instance0, Instance1, ...)
+	// to the EBS snapshot their volume is restored from.
+	RestoreFromSnapshots map[string]string `json:"restore_from_snapshots,omitempty"`
+
+	// InstanceGenerations counts how many times each instance has been
+	// replaced by ReplaceInstance, keyed by index, see instanceLogicalID.
+	InstanceGenerations map[int]int `json:"instance_generations,omitempty"`
+
+	// VolumeSize is the size in GB of each instance's root volume, which is
+	// encrypted if EncryptVolumes is set.
+	VolumeSize     int  `json:"volume_size,omitempty"`
+	EncryptVolumes bool `json:"encrypt_volumes,omitempty"`
+
+	// SnapshotBeforeDelete causes the instance volumes to be snapshotted
+	// before the cluster is deleted.
+	SnapshotBeforeDelete bool `json:"snapshot_before_delete,omitempty"`
+
+	// BootstrapManifest is either a path to or the JSON content of a
+	// bootstrap manifest which replaces the default one on the instances.
+	BootstrapManifest string `json:"bootstrap_manifest,omitempty"`
+	bootstrapManifest []byte
+
+	Timeline []*PhaseTiming `json:"timeline,omitempty"`
+	State    string         `json:"state,omitempty"`
+	LogLevel string         `json:"log_level,omitempty"`
+
+	// Metadata is attached to every event sent for the install, e.g. to
+	// correlate them with a trace ID.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Tags are applied to every AWS resource created for the cluster along
+	// with ClusterIDTag.
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Timeout is the maximum duration of the install, after which it is
+	// cancelled and any partially created infrastructure is removed.
+	Timeout     time.Duration `json:"timeout,omitempty"`
+	ErrorReason string        `json:"error_reason,omitempty"`
+
+	// FailedStep is the name of the install step the last install failed
+	// at, the step RetryFailedResources carries on from.
+	FailedStep string `json:"failed_step,omitempty"`
+
+	// cancel is closed to cancel the install, it is replaced by operations
+	// run on the stack once the install is over, so is guarded by
+	// cancelMtx.
+	cancel    chan struct{}
+	cancelMtx sync.Mutex
+
+	// CopyImageFromRegion is the region the image is copied from if it
+	// isn't published in Region.
+	CopyImageFromRegion string `json:"copy_image_from_region,omitempty"`
+
+	// PostBootWait is an additional time to wait once the instances have
+	// passed their readiness probe before bootstrapping them, for images
+	// which need longer to settle. By default only the probe is waited for.
+	PostBootWait time.Duration `json:"post_boot_wait,omitempty"`
+
+	// PlacementGroup is the name of an existing cluster placement group to
+	// launch the instances into, or if CreatePlacementGroup is set a group
+	// is created for them along with the stack.
+	PlacementGroup       string `json:"placement_group,omitempty"`
+	CreatePlacementGroup bool   `json:"create_placement_group,omitempty"`
+
+	// InstanceNameTemplate is the pattern of the Name tag of each instance,
+	// see DefaultInstanceNameTemplate.
+	InstanceNameTemplate string `json:"instance_name_template,omitempty"`
+
+	// UseSpotInstances launches the instances as spot instances, bid for at
+	// up to SpotMaxPrice USD an hour or the on-demand price if it isn't set.
+	// If the spot requests can't be fulfilled the install fails, unless
+	// SpotFallback is set in which case on-demand instances are launched
+	// instead and LaunchedOnDemand is set.
+	UseSpotInstances bool   `json:"use_spot_instances,omitempty"`
+	SpotMaxPrice     string `json:"spot_max_price,omitempty"`
+	SpotFallback     bool   `json:"spot_fallback,omitempty"`
+	LaunchedOnDemand bool   `json:"launched_on_demand,omitempty"`
+
+	// NotifyURL, if set, is called with the outcome of the install once it
+	// has finished, see webhookPayload.
+	NotifyURL string `json:"notify_url,omitempty"`
+
+	// Tracer, if set, receives a span for the install and each of its
+	// phases.
+	Tracer Tracer `json:"-"`
+
+	// Features lists the optional features the cluster requires, the
+	// install fails if the Flynn version being installed lacks any of them.
+	Features []string `json:"features,omitempty"`
+
+	// Channel is the release channel the Flynn version is installed from,
+	// one of ReleaseChannels, and Version pins the version rather than
+	// installing the latest on the channel. InstalledVersion is the version
+	// the instances were launched with.
+	Channel          string `json:"channel,omitempty"`
+	Version          string `json:"version,omitempty"`
+	InstalledVersion string `json:"installed_version,omitempty"`
+
+	// DryRun causes the install to only validate its configuration and
+	// report what it would create, see runDryRun. DryRunSummary is filled
+	// in once the dry run has finished.
+	DryRun          bool           `json:"dry_run,omitempty"`
+	DryRunSummary   *DryRunSummary `json:"dry_run_summary,omitempty"`
+	dryRunResources []*DryRunResource
+
+	validationWarnings  []string
+	defaultVpcCidr      bool
+	defaultInstanceType bool
+	defaultNumInstances bool
 
 	persistMutex sync.Mutex
 
+	// operating is set while the cluster is being resized, repaired,
+	// rolled back or deleted, see beginOperation. It is guarded by
+	// persistMutex.
+	operating bool
+
+	// launchSlots limits the installs running at once, see
+	// MaxConcurrentLaunches.
+	launchSlots chan struct{}
+
+	// healthCheckIDs are the IDs of the instances' health checks from the
+	// stack outputs, keyed by index, which the DNS records are served with.
+	healthCheckIDs map[int]string
+
 	cf  *cloudformation.CloudFormation
 	ec2 *ec2.EC2
+	dns DNSProvider
 }
 
 func (s *Stack) setDefaults() {
 	if s.NumInstances == 0 {
 		s.NumInstances = 1
+		s.defaultNumInstances = true
 	}
 
 	if s.InstanceType == "" {
 		s.InstanceType = DefaultInstanceType
+		s.defaultInstanceType = true
 	}
 
-	if s.VpcCidr == "" {
+	// the CIDRs of an existing subnet are found when validating it
+	if s.VpcCidr == "" && s.SubnetID == "" {
 		s.VpcCidr = "10.0.0.0/16"
+		s.defaultVpcCidr = true
 	}
 
-	if s.SubnetCidr == "" {
+	if s.SubnetCidr == "" && s.SubnetID == "" {
 		s.SubnetCidr = "10.0.0.0/21"
 	}
+
+	if s.Timeout == 0 {
+		s.Timeout = DefaultTimeout
+	}
+
+	if s.VolumeSize == 0 {
+		s.VolumeSize = defaultVolumeSize
+	}
+
+	if s.InstanceNameTemplate == "" {
+		s.InstanceNameTemplate = DefaultInstanceNameTemplate
+	}
+
+	if s.Channel == "" {
+		s.Channel = DefaultChannel
+	}
 }
 
-func (s *Stack) validateInputs() error {
-	if s.NumInstances <= 0 {
-		return fmt.Errorf("You must specify at least one instance")
+// validateSubnetSize checks that the subnet has an address for each instance
+// and the containers it is expected to run.
+func (s *Stack) validateSubnetSize() error {
+	_, subnet, err := net.ParseCIDR(s.SubnetCidr)
+	if err != nil {
+		return fmt.Errorf("Invalid subnet %s: %s", s.SubnetCidr, err)
+	}
+	ones, bits := subnet.Mask.Size()
+	// AWS reserves the first four and the last address of every subnet
+	available := (1 << uint(bits-ones)) - 5
+	if available < 0 {
+		available = 0
+	}
+	required := s.NumInstances * (1 + ContainerIPsPerInstance)
+	if available < required {
+		return &ErrSubnetTooSmall{Subnet: s.SubnetCidr, Required: required, Available: available}
 	}
+	return nil
+}
 
-	if s.Region == "" {
-		return fmt.Errorf("No region specified")
+// SetDefaultsAndValidate fills in defaults for any unset fields and validates
+// the result. Fields which are already set are left alone so it is safe to
+// call more than once.
+func (s *Stack) SetDefaultsAndValidate() error {
+	s.validationWarnings = nil
+	s.setDefaults()
+	return s.validateInputs()
+}
+
+// validateNumInstances checks that n instances can form a stable consensus
+// quorum. An even number of instances tolerates no more failures than one
+// fewer would, while risking a split vote, so only odd numbers are allowed.
+func validateNumInstances(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("You must specify at least one instance")
 	}
 
-	if s.NumInstances > 5 {
+	if n > 5 {
 		return fmt.Errorf("Maximum of 5 instances exceeded")
 	}
 
-	if s.NumInstances == 2 {
-		return fmt.Errorf("You must specify 1 or 3+ instances, not 2")
+	if n%2 == 0 {
+		return fmt.Errorf("You must specify an odd number of instances for a healthy quorum, not %d, try %d or %d", n, n-1, n+1)
+	}
+	return nil
+}
+
+func (s *Stack) validateInputs() error {
+	if err := validateNumInstances(s.NumInstances); err != nil {
+		return err
+	}
+	// like the instance type, installs which don't choose a size aren't
+	// warned about the default
+	if s.NumInstances == 1 && !s.defaultNumInstances {
+		s.warn("A single instance cluster has no fault tolerance, use 3 or more instances for production")
+	}
+
+	if s.Region == "" {
+		return fmt.Errorf("No region specified")
+	}
+	if err := validateRegion(s.Region); err != nil {
+		return err
 	}
 
 	for _, t := range DisallowedEC2InstanceTypes {
@@ -113,6 +409,372 @@ func (s *Stack) validateInputs() error {
 		}
 	}
 
+	// the default is left alone so that installs which don't choose an
+	// instance type aren't warned about it
+	if !s.defaultInstanceType {
+		family := strings.SplitN(s.InstanceType, ".", 2)[0]
+		if replacement, ok := DeprecatedInstanceFamilies[family]; ok {
+			s.warn("Instance type %s is a previous generation type, consider the %s family instead", s.InstanceType, replacement)
+		}
+	}
+
+	if (s.VpcID == "") != (s.SubnetID == "") {
+		return fmt.Errorf("VpcID and SubnetID must be specified together")
+	}
+	if s.VpcID != "" && !strings.HasPrefix(s.VpcID, "vpc-") {
+		return fmt.Errorf("Invalid VPC ID %s", s.VpcID)
+	}
+	if s.SubnetID != "" && !strings.HasPrefix(s.SubnetID, "subnet-") {
+		return fmt.Errorf("Invalid subnet ID %s", s.SubnetID)
+	}
+
+	// an existing subnet is checked for free addresses by validateAWS
+	if s.SubnetID == "" {
+		if err := s.validateSubnetSize(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.validatePlacementGroup(); err != nil {
+		return err
+	}
+
+	if err := validateTags(s.Tags); err != nil {
+		return err
+	}
+
+	if s.VolumeSize < MinVolumeSize {
+		return fmt.Errorf("VolumeSize must be at least %dGB", MinVolumeSize)
+	}
+	if s.VolumeSize > MaxVolumeSize {
+		return fmt.Errorf("VolumeSize must be at most %dGB", MaxVolumeSize)
+	}
+
+	if err := s.validateInstanceNameTemplate(); err != nil {
+		return err
+	}
+
+	if err := s.validateRelease(); err != nil {
+		return err
+	}
+
+	if err := s.validateDomainName(); err != nil {
+		return err
+	}
+
+	if err := s.validateNotifyURL(); err != nil {
+		return err
+	}
+
+	if err := s.validateSpot(); err != nil {
+		return err
+	}
+
+	if s.Timeout < MinTimeout {
+		return fmt.Errorf("Timeout must be at least %s", MinTimeout)
+	}
+
+	if s.CopyImageFromRegion == s.Region {
+		return fmt.Errorf("CopyImageFromRegion must be a different region to %s", s.Region)
+	}
+	if s.CopyImageFromRegion != "" {
+		if err := validateRegion(s.CopyImageFromRegion); err != nil {
+			return err
+		}
+	}
+
+	if s.PostBootWait < 0 {
+		return fmt.Errorf("PostBootWait must not be negative")
+	}
+	if s.PostBootWait >= s.Timeout {
+		return fmt.Errorf("PostBootWait must be shorter than the timeout (%s)", s.Timeout)
+	}
+
+	if _, err := s.dnsProvider(); err != nil {
+		return err
+	}
+
+	if s.BootstrapManifest != "" {
+		if err := s.loadBootstrapManifest(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// warn records a problem found during validation which doesn't prevent the
+// install, it is sent as an event once the install starts.
+func (s *Stack) warn(format string, v ...interface{}) {
+	s.validationWarnings = append(s.validationWarnings, fmt.Sprintf(format, v...))
+}
+
+// validateAWS validates the inputs against the AWS account and the discovery
+// service, it is run after SetDefaultsAndValidate once the API clients are
+// created so that SetDefaultsAndValidate makes no remote calls.
+func (s *Stack) validateAWS() error {
+	if err := s.validateCredentialsExpiry(); err != nil {
+		return err
+	}
+	if s.DiscoveryToken != "" {
+		if err := s.validateDiscoveryToken(); err != nil {
+			return err
+		}
+	}
+	if s.SubnetID != "" {
+		if err := s.validateExistingSubnet(); err != nil {
+			return err
+		}
+	} else if err := s.validateVpcCidr(); err != nil {
+		return err
+	}
+	if len(s.RestoreFromSnapshots) > 0 {
+		if err := s.validateSnapshots(); err != nil {
+			return err
+		}
+	}
+	if s.PlacementGroup != "" {
+		if err := s.validateExistingPlacementGroup(); err != nil {
+			return err
+		}
+	}
+	if s.UseSpotInstances {
+		if err := s.validateSpotServiceRole(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClusterPlacementInstanceFamilies are the instance families which can be
+// launched into a cluster placement group.
+var ClusterPlacementInstanceFamilies = []string{"c3", "c4", "cc2", "cr1", "d2", "g2", "i2", "m4", "r3"}
+
+// maxReliablePlacementGroupSize is the number of instances above which
+// launching them one at a time into a cluster placement group is likely to
+// fail with insufficient capacity.
+const maxReliablePlacementGroupSize = 3
+
+func (s *Stack) validatePlacementGroup() error {
+	if s.PlacementGroup == "" && !s.CreatePlacementGroup {
+		return nil
+	}
+	if s.PlacementGroup != "" && s.CreatePlacementGroup {
+		return fmt.Errorf("Either specify an existing placement group or create one, not both")
+	}
+	family := strings.SplitN(s.InstanceType, ".", 2)[0]
+	supported := false
+	for _, f := range ClusterPlacementInstanceFamilies {
+		if f == family {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return fmt.Errorf("Instance type %s does not support cluster placement groups", s.InstanceType)
+	}
+	if s.NumInstances > maxReliablePlacementGroupSize {
+		s.warn("%d instances may not all fit in a single placement group, launching them may fail with insufficient capacity", s.NumInstances)
+	}
+	return nil
+}
+
+func (s *Stack) validateExistingPlacementGroup() error {
+	res, err := s.ec2.DescribePlacementGroups(&ec2.DescribePlacementGroupsRequest{
+		GroupNames: []string{s.PlacementGroup},
+	})
+	if err != nil {
+		return fmt.Errorf("Placement group %s not found: %s", s.PlacementGroup, err)
+	}
+	for _, g := range res.PlacementGroups {
+		if g.GroupName != nil && *g.GroupName == s.PlacementGroup {
+			if g.Strategy == nil || *g.Strategy != "cluster" {
+				return fmt.Errorf("Placement group %s does not use the cluster strategy", s.PlacementGroup)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("Placement group %s not found", s.PlacementGroup)
+}
+
+// validateVpcCidr checks the VPC CIDR doesn't overlap any existing VPC in
+// the region. Overlaps with the default CIDR are only warned about as every
+// cluster uses it unless told otherwise.
+func (s *Stack) validateVpcCidr() error {
+	res, err := s.ec2.DescribeVPCs(&ec2.DescribeVPCsRequest{})
+	if err != nil {
+		return err
+	}
+	for _, vpc := range res.VPCs {
+		if vpc.CIDRBlock == nil || vpc.VPCID == nil {
+			continue
+		}
+		if !cidrsOverlap(s.VpcCidr, *vpc.CIDRBlock) {
+			continue
+		}
+		overlap := &ErrVpcCidrOverlap{Cidr: s.VpcCidr, VpcID: *vpc.VPCID, VpcCidr: *vpc.CIDRBlock}
+		if s.defaultVpcCidr {
+			s.warn("%s", overlap)
+			continue
+		}
+		return overlap
+	}
+	return nil
+}
+
+// validateExistingSubnet checks that SubnetID exists in VpcID and has a free
+// address for each instance and the containers it is expected to run.
+func (s *Stack) validateExistingSubnet() error {
+	res, err := s.ec2.DescribeSubnets(&ec2.DescribeSubnetsRequest{SubnetIDs: []string{s.SubnetID}})
+	if apiErr, ok := err.(aws.APIError); ok && apiErr.Code == "InvalidSubnetID.NotFound" {
+		return fmt.Errorf("Subnet %s does not exist in %s", s.SubnetID, s.Region)
+	} else if err != nil {
+		return err
+	}
+	if len(res.Subnets) == 0 {
+		return fmt.Errorf("Subnet %s does not exist in %s", s.SubnetID, s.Region)
+	}
+	subnet := res.Subnets[0]
+	if subnet.VPCID == nil || *subnet.VPCID != s.VpcID {
+		return fmt.Errorf("Subnet %s is not in VPC %s", s.SubnetID, s.VpcID)
+	}
+	if subnet.CIDRBlock != nil {
+		s.SubnetCidr = *subnet.CIDRBlock
+	}
+	available := 0
+	if subnet.AvailableIPAddressCount != nil {
+		available = *subnet.AvailableIPAddressCount
+	}
+	required := s.NumInstances * (1 + ContainerIPsPerInstance)
+	if available < required {
+		return &ErrSubnetTooSmall{Subnet: s.SubnetID, Required: required, Available: available}
+	}
+	return nil
+}
+
+func cidrsOverlap(a, b string) bool {
+	_, netA, err := net.ParseCIDR(a)
+	if err != nil {
+		return false
+	}
+	_, netB, err := net.ParseCIDR(b)
+	if err != nil {
+		return false
+	}
+	return netA.Contains(netB.IP) || netB.Contains(netA.IP)
+}
+
+// expectedInstallDuration is used to check temporary credentials last long
+// enough when there is no install history.
+const expectedInstallDuration = 30 * time.Minute
+
+// validateCredentialsExpiry refuses to start an install with temporary
+// credentials which are likely to expire before it finishes.
+func (s *Stack) validateCredentialsExpiry() error {
+	expiry := credentialsExpiry(s.Creds)
+	if expiry.IsZero() {
+		return nil
+	}
+	expected := expectedInstallDuration
+	if stats, err := InstallDurationStats("aws"); err == nil && stats.Installs > 0 {
+		expected = stats.Total.P90
+	}
+	if remaining := expiry.Sub(time.Now()); remaining <= 0 {
+		return ErrCredentialsExpired
+	} else if remaining < expected {
+		return fmt.Errorf("Credentials expire in %s, installs usually take up to %s", remaining/time.Second*time.Second, expected)
+	}
+	return nil
+}
+
+const defaultVolumeSize = 50
+
+// MinVolumeSize and MaxVolumeSize are the limits in GB of VolumeSize, the
+// minimum being the disk space flynn-host needs, see MinHostDiskMB.
+var (
+	MinVolumeSize = MinHostDiskMB / 1024
+	MaxVolumeSize = 16384
+)
+
+func (s *Stack) validateSnapshots() error {
+	ids := make([]string, 0, len(s.RestoreFromSnapshots))
+	for name, id := range s.RestoreFromSnapshots {
+		found := false
+		for i := 0; i < s.NumInstances; i++ {
+			if name == instanceName(i) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("Unknown instance %s to restore snapshot %s to", name, id)
+		}
+		ids = append(ids, id)
+	}
+	res, err := s.ec2.DescribeSnapshots(&ec2.DescribeSnapshotsRequest{SnapshotIDs: ids})
+	if err != nil {
+		if apiErr, ok := err.(aws.APIError); ok && apiErr.Code == "InvalidSnapshot.NotFound" {
+			return fmt.Errorf("Snapshot not found in region %s: %s", s.Region, apiErr.Message)
+		}
+		return err
+	}
+	for _, id := range ids {
+		var snapshot *ec2.Snapshot
+		for i := range res.Snapshots {
+			if res.Snapshots[i].SnapshotID != nil && *res.Snapshots[i].SnapshotID == id {
+				snapshot = &res.Snapshots[i]
+				break
+			}
+		}
+		if snapshot == nil {
+			return fmt.Errorf("Snapshot %s not found in region %s", id, s.Region)
+		}
+		if snapshot.VolumeSize != nil && *snapshot.VolumeSize > s.VolumeSize {
+			return fmt.Errorf("Snapshot %s is %dGB, larger than the %dGB instance volumes", id, *snapshot.VolumeSize, s.VolumeSize)
+		} else if snapshot.VolumeSize != nil && *snapshot.VolumeSize != s.VolumeSize {
+			s.warn("Snapshot %s is %dGB but the instance volumes are %dGB", id, *snapshot.VolumeSize, s.VolumeSize)
+		}
+		// volumes restored from a snapshot are only encrypted if it is
+		if s.EncryptVolumes && (snapshot.Encrypted == nil || !*snapshot.Encrypted) {
+			return fmt.Errorf("Snapshot %s is not encrypted, so volumes restored from it can't be", id)
+		}
+	}
+	return nil
+}
+
+// requiredBootstrapSteps are the bootstrap steps the installer reads the
+// cluster credentials from.
+var requiredBootstrapSteps = []string{"controller", "controller-key", "controller-cert", "dashboard-login-token"}
+
+func (s *Stack) loadBootstrapManifest() error {
+	data := []byte(s.BootstrapManifest)
+	if !strings.HasPrefix(strings.TrimSpace(s.BootstrapManifest), "[") {
+		var err error
+		data, err = ioutil.ReadFile(s.BootstrapManifest)
+		if err != nil {
+			return fmt.Errorf("Unable to read bootstrap manifest: %s", err)
+		}
+	}
+	var steps []struct {
+		ID     string `json:"id"`
+		Action string `json:"action"`
+	}
+	if err := json.Unmarshal(data, &steps); err != nil {
+		return fmt.Errorf("Invalid bootstrap manifest: %s", err)
+	}
+	for _, id := range requiredBootstrapSteps {
+		found := false
+		for _, step := range steps {
+			if step.ID == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("Bootstrap manifest is missing required step %s", id)
+		}
+	}
+	s.bootstrapManifest = data
 	return nil
 }
 
@@ -140,84 +802,314 @@ func (s *Stack) DashboardLoginMsg() (string, error) {
 	return fmt.Sprintf("The built-in dashboard can be accessed at http://dashboard.%s with login token %s", s.Domain.Name, s.DashboardLoginToken), nil
 }
 
-func (s *Stack) promptUseExistingStack(savedStack *Stack) bool {
+// previousInstall returns the stack of the previous install, whose
+// CloudFormation stack and key pair are reused. Only a stack without an ID
+// has one, as stacks with an ID are saved to their own files rather than to
+// the shared data file, which may otherwise hold the stack of any other
+// install. It is loaded once the install has its launch slot, as the data
+// file may change while it waits for one.
+func (s *Stack) previousInstall() *Stack {
+	savedStack := &Stack{}
+	if s.ID != "" || savedStack.load() != nil {
+		return &Stack{}
+	}
+	// the saved stack may be that of another install still in progress
+	if savedStack.State == StateProvisioning || savedStack.State == StateBootstrapping {
+		return &Stack{}
+	}
+	s.StackID = savedStack.StackID
+	s.StackName = savedStack.StackName
+	// a key pair generated for the previous install is deleted with it
+	if s.SSHKeyName == "" && !savedStack.GeneratedSSHKey {
+		s.SSHKeyName = savedStack.SSHKeyName
+	}
+	return savedStack
+}
+
+func (s *Stack) promptUseExistingStack(savedStack *Stack) (bool, error) {
 	if s.StackID == "" || s.StackName == "" || savedStack.NumInstances != s.NumInstances || savedStack.InstanceType != s.InstanceType || savedStack.Region != s.Region {
-		return false
+		return false, nil
 	}
 
 	if err := s.fetchStack(); err != nil {
-		return false
+		return false, nil
 	}
 
-	if !s.YesNoPrompt(fmt.Sprintf("It appears you already have a cluster of this configuration (stack %s), would you like to continue?", s.StackName)) {
+	cont, err := s.YesNoPrompt(fmt.Sprintf("It appears you already have a cluster of this configuration (stack %s), would you like to continue?", s.StackName))
+	if err != nil {
+		return false, err
+	}
+	if !cont {
 		s.Domain = savedStack.Domain
 		s.DashboardLoginToken = savedStack.DashboardLoginToken
+		s.ControllerKey = savedStack.ControllerKey
+		s.ControllerPin = savedStack.ControllerPin
 		s.CACert = savedStack.CACert
-		return true
+		return true, nil
 	}
-	return false
+	return false, nil
 }
 
 func (s *Stack) RunAWS() error {
-	s.setDefaults()
-	if err := s.validateInputs(); err != nil {
+	if err := s.SetDefaultsAndValidate(); err != nil {
 		return err
 	}
 
 	s.EventChan = make(chan *Event)
 	s.ErrChan = make(chan error)
 	s.Done = make(chan struct{})
+	s.resetCancel()
 	s.InstanceIPs = make([]string, 0, s.NumInstances)
 	s.ec2 = ec2.New(s.Creds, s.Region, nil)
 	s.cf = cloudformation.New(s.Creds, s.Region, nil)
+	if err := s.validateAWS(); err != nil {
+		return classifyInstallError("", err)
+	}
+
+	// a dry run neither changes nor saves anything, so doesn't need a launch
+	// slot or the stack of a previous install
+	if s.DryRun {
+		go func() {
+			defer close(s.Done)
+			s.sendValidationWarnings()
+			s.runDryRun()
+		}()
+		return nil
+	}
 
-	savedStack := &Stack{}
-	savedStack.load()
-	s.StackID = savedStack.StackID
-	s.StackName = savedStack.StackName
-	s.SSHKeyName = savedStack.SSHKeyName
+	if err := s.setState(StateProvisioning); err != nil {
+		return err
+	}
 
 	go func() {
 		defer close(s.Done)
 
-		if s.promptUseExistingStack(savedStack) {
+		s.sendValidationWarnings()
+
+		if !s.acquireLaunchSlot() {
+			s.setState(StateError)
+			s.persist()
 			return
 		}
+		defer s.releaseLaunchSlot()
 
-		steps := []func() error{
-			s.createKeyPair,
-			s.allocateDomain,
-			s.fetchImageID,
-			s.createStack,
-			s.fetchStackOutputs,
-			s.configureDNS,
-			s.bootstrap,
+		savedStack := s.previousInstall()
+		useExisting, err := s.promptUseExistingStack(savedStack)
+		if err != nil {
+			s.setState(StateError)
+			s.persist()
+			return
 		}
-
-		for _, step := range steps {
-			if err := step(); err != nil {
-				s.persist()
-				s.SendError(err)
-				return
-			}
-			if err := s.persist(); err != nil {
+		if useExisting {
+			// the cluster from the previous install is already running
+			if err := s.setState(StateRunning); err != nil {
 				s.SendError(err)
 			}
+			s.persist()
+			return
 		}
 
-		if err := s.configureCLI(); err != nil {
-			s.SendEvent(fmt.Sprintf("WARNING: Failed to configure CLI: %s", err))
-		}
+		s.runInstall(s.installSteps())
 	}()
 	return nil
 }
 
+func (s *Stack) sendValidationWarnings() {
+	for _, w := range s.validationWarnings {
+		s.sendTypedEvent("validation_warning", "WARNING: "+w, nil)
+	}
+}
+
+func (s *Stack) installSteps() []installStep {
+	return []installStep{
+		{"key_pair", s.createKeyPair},
+		{"domain", s.allocateDomain},
+		{"image", s.fetchImageID},
+		{"launch_check", s.checkLaunchable},
+		{"stack", s.createStack},
+		{"stack_outputs", s.fetchStackOutputs},
+		{"tags", s.tagVolumes},
+		{"dns", s.configureDNS},
+		{"instances", s.probeInstances},
+		{"resources", s.checkResources},
+		{"dependencies", s.checkDependencies},
+		{"bootstrap", s.bootstrap},
+		{"connectivity", s.checkConnectivity},
+		{"health", s.verifyClusterHealth},
+	}
+}
+
+// runInstall runs the given install steps, moving the stack to the running
+// state once they have all succeeded or to the error state if one fails or
+// the install times out.
+func (s *Stack) runInstall(steps []installStep) {
+	s.Timeline = nil
+	s.FailedStep = ""
+	span := s.startInstallSpan()
+	defer span.End()
+	errChan := make(chan error, 1)
+	go func() { errChan <- s.runSteps(steps, span) }()
+	timeout := time.NewTimer(s.Timeout)
+	defer timeout.Stop()
+	select {
+	case err := <-errChan:
+		if err != nil {
+			span.RecordError(err)
+			s.setState(StateError)
+			s.persist()
+			s.sendTypedEvent("cluster_install_failed", err.Error(), map[string]string{
+				"error_type": InstallErrorType(err),
+			})
+			s.SendError(err)
+			return
+		}
+	case <-timeout.C:
+		span.RecordError(ErrTimeout)
+		s.cancelInstall()
+		// the running step must stop changing the stack before it is
+		// rolled back
+		<-errChan
+		s.handleTimeout()
+		return
+	}
+	if err := s.setState(StateRunning); err != nil {
+		s.SendError(err)
+	}
+	s.persist()
+	if err := s.saveTimeline(); err != nil {
+		s.SendEvent(fmt.Sprintf("WARNING: Failed to save install timeline: %s", err))
+	}
+
+	if err := s.configureCLI(); err != nil {
+		s.SendEvent(fmt.Sprintf("WARNING: Failed to configure CLI: %s", err))
+	}
+}
+
+// runSteps runs each step in turn, stopping at the first error or once the
+// install has been cancelled.
+func (s *Stack) runSteps(steps []installStep, parent Span) error {
+	for _, step := range steps {
+		if s.cancelled() {
+			return ErrCancelled
+		}
+		startedAt := s.now()
+		span := s.startSpan(step.Name, parent, nil)
+		err := classifyInstallError(step.Name, step.Run())
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+		s.Timeline = append(s.Timeline, &PhaseTiming{
+			Phase:     step.Name,
+			StartedAt: startedAt,
+			Duration:  s.now().Sub(startedAt),
+		})
+		if err != nil {
+			s.FailedStep = step.Name
+			return err
+		}
+		if err := s.persist(); err != nil {
+			s.SendError(err)
+		}
+	}
+	return nil
+}
+
+func (s *Stack) cancelled() bool {
+	select {
+	case <-s.cancelChan():
+		return true
+	default:
+		return false
+	}
+}
+
+// cancelChan returns the channel which is closed once the install has been
+// cancelled.
+func (s *Stack) cancelChan() <-chan struct{} {
+	s.cancelMtx.Lock()
+	defer s.cancelMtx.Unlock()
+	return s.cancel
+}
+
+// doneChan returns the channel which is closed once the install has
+// finished.
+func (s *Stack) doneChan() <-chan struct{} {
+	s.persistMutex.Lock()
+	defer s.persistMutex.Unlock()
+	return s.Done
+}
+
+// cancelInstall stops a running install after its current step, or while it
+// waits for the stack, and causes any events it sends from then on to be
+// dropped.
+func (s *Stack) cancelInstall() {
+	s.cancelMtx.Lock()
+	defer s.cancelMtx.Unlock()
+	if s.cancel == nil {
+		return
+	}
+	select {
+	case <-s.cancel:
+	default:
+		close(s.cancel)
+	}
+}
+
+// resetCancel replaces the cancel channel of a finished install, so that
+// the events of an operation run on the stack afterwards aren't dropped.
+func (s *Stack) resetCancel() {
+	s.cancelMtx.Lock()
+	defer s.cancelMtx.Unlock()
+	s.cancel = make(chan struct{})
+}
+
+// handleTimeout deletes the CloudFormation stack of an install which timed
+// out, if one has been created, once the install has been cancelled and its
+// running step has stopped.
+func (s *Stack) handleTimeout() {
+	// the install is over, so its events are no longer dropped
+	s.resetCancel()
+	s.setState(StateError)
+	s.ErrorReason = fmt.Sprintf("Install timed out after %s", s.Timeout)
+	s.sendTypedEvent("cluster_timeout", s.ErrorReason, nil)
+	if s.StackID != "" {
+		if err := s.deleteStack(); err != nil {
+			s.SendEvent(fmt.Sprintf("WARNING: Failed to delete stack %s: %s", s.StackName, err))
+		} else {
+			s.StackID = ""
+			s.StackName = ""
+		}
+	}
+	s.persist()
+	s.SendError(ErrTimeout)
+}
+
+func (s *Stack) sendTypedEvent(eventType, description string, metadata map[string]string) {
+	select {
+	case s.EventChan <- &Event{Type: eventType, Description: description, Metadata: metadata}:
+	case <-s.cancelChan():
+	}
+}
+
+// SendEvent sends an event to subscribers, it is dropped once the install
+// has been cancelled.
 func (s *Stack) SendEvent(description string) {
-	s.EventChan <- &Event{description}
+	select {
+	case s.EventChan <- &Event{Description: description}:
+	case <-s.cancelChan():
+	}
 }
 
 func (s *Stack) SendError(err error) {
-	s.ErrChan <- err
+	select {
+	case s.ErrChan <- err:
+	case <-s.cancelChan():
+	}
+}
+
+func (s *Stack) hasSubscribers() bool {
+	return s.HasSubscribers == nil || s.HasSubscribers()
 }
 
 func (s *Stack) fetchImageID() (err error) {
@@ -226,7 +1118,8 @@ func (s *Stack) fetchImageID() (err error) {
 			return
 		}
 		s.SendEvent(err.Error())
-		if s.ImageID != "" {
+		// the saved image may be of another version than the pinned one
+		if s.ImageID != "" && err != ErrFeatureUnavailable && err != ErrVersionUnavailable {
 			s.SendEvent("Falling back to saved Image ID")
 			err = nil
 			return
@@ -236,17 +1129,38 @@ func (s *Stack) fetchImageID() (err error) {
 
 	s.SendEvent("Fetching image manifest")
 
-	latestVersion, err := fetchLatestVersion()
+	manifest, err := fetchManifest(s.Channel)
+	if err != nil {
+		return err
+	}
+	version, err := findVersion(manifest, s.Version)
+	if err == ErrVersionUnavailable {
+		s.SendEvent(fmt.Sprintf("Flynn %s is not available on the %s channel", s.Version, s.Channel))
+	}
 	if err != nil {
 		return err
 	}
+	if missing := unavailableFeatures(s.Features, version.Features); len(missing) > 0 {
+		s.SendEvent(fmt.Sprintf("Flynn %s does not support %s", version.Version, strings.Join(missing, ", ")))
+		return ErrFeatureUnavailable
+	}
+	s.SendEvent(fmt.Sprintf("Installing Flynn %s from the %s channel", version.Version, s.Channel))
+	s.InstalledVersion = version.Version
 	var imageID string
-	for _, i := range latestVersion.Images {
+	for _, i := range version.Images {
 		if i.Region == s.Region {
 			imageID = i.ID
 			break
 		}
 	}
+	if imageID == "" && s.CopyImageFromRegion != "" {
+		for _, i := range version.Images {
+			if i.Region == s.CopyImageFromRegion {
+				return s.copyImage(i.ID)
+			}
+		}
+		return errors.New(fmt.Sprintf("No image found for region %s or %s", s.Region, s.CopyImageFromRegion))
+	}
 	if imageID == "" {
 		return errors.New(fmt.Sprintf("No image found for region %s", s.Region))
 	}
@@ -255,13 +1169,17 @@ func (s *Stack) fetchImageID() (err error) {
 }
 
 func (s *Stack) allocateDomain() error {
+	if s.DomainName != "" {
+		s.Domain = &Domain{Name: s.DomainName}
+		return s.ensureDNSZone()
+	}
 	s.SendEvent("Allocating domain")
 	domain, err := AllocateDomain()
 	if err != nil {
 		return err
 	}
 	s.Domain = domain
-	return nil
+	return s.Domain.Validate()
 }
 
 func (s *Stack) loadKeyPair(name string) error {
@@ -273,13 +1191,17 @@ func (s *Stack) loadKeyPair(name string) error {
 	if err != nil {
 		return err
 	}
-	res, err := s.ec2.DescribeKeyPairs(&ec2.DescribeKeyPairsRequest{
-		Filters: []ec2.Filter{
-			{
-				Name:   aws.String("fingerprint"),
-				Values: []string{fingerprint},
+	var res *ec2.DescribeKeyPairsResult
+	err = s.retryAWS("DescribeKeyPairs", func() (err error) {
+		res, err = s.ec2.DescribeKeyPairs(&ec2.DescribeKeyPairsRequest{
+			Filters: []ec2.Filter{
+				{
+					Name:   aws.String("fingerprint"),
+					Values: []string{fingerprint},
+				},
 			},
-		},
+		})
+		return
 	})
 	if err != nil {
 		return err
@@ -298,11 +1220,13 @@ func (s *Stack) loadKeyPair(name string) error {
 	return saveSSHKey(s.SSHKeyName, keypair)
 }
 
+// createKeyPair loads or creates the SSHKeyName key pair, or generates one
+// for the cluster if SSHKeyName isn't set.
 func (s *Stack) createKeyPair() error {
-	keypairName := "flynn"
-	if s.SSHKeyName != "" {
-		keypairName = s.SSHKeyName
+	if s.SSHKeyName == "" || s.GeneratedSSHKey {
+		return s.generateKeyPair()
 	}
+	keypairName := s.SSHKeyName
 	if err := s.loadKeyPair(keypairName); err == nil {
 		s.SendEvent(fmt.Sprintf("Using saved key pair (%s)", s.SSHKeyName))
 		return nil
@@ -318,12 +1242,20 @@ func (s *Stack) createKeyPair() error {
 	publicKeyBytes := make([]byte, enc.EncodedLen(len(keypair.PublicKey)))
 	enc.Encode(publicKeyBytes, keypair.PublicKey)
 
-	res, err := s.ec2.ImportKeyPair(&ec2.ImportKeyPairRequest{
-		KeyName:           aws.String(keypairName),
-		PublicKeyMaterial: publicKeyBytes,
+	var res *ec2.ImportKeyPairResult
+	err = s.retryAWS("ImportKeyPair", func() (err error) {
+		res, err = s.ec2.ImportKeyPair(&ec2.ImportKeyPairRequest{
+			KeyName:           aws.String(keypairName),
+			PublicKeyMaterial: publicKeyBytes,
+		})
+		return
 	})
 	if apiErr, ok := err.(aws.APIError); ok && apiErr.Code == "InvalidKeyPair.Duplicate" {
-		if s.YesNoPrompt(fmt.Sprintf("Key pair %s already exists, would you like to delete it?", keypairName)) {
+		deleteKeyPair, err := s.YesNoPrompt(fmt.Sprintf("Key pair %s already exists, would you like to delete it?", keypairName))
+		if err != nil {
+			return err
+		}
+		if deleteKeyPair {
 			s.SendEvent("Deleting key pair")
 			if err := s.ec2.DeleteKeyPair(&ec2.DeleteKeyPairRequest{
 				KeyName: aws.String(keypairName),
@@ -333,7 +1265,10 @@ func (s *Stack) createKeyPair() error {
 			return s.createKeyPair()
 		} else {
 			for {
-				keypairName = s.PromptInput("Please enter a new key pair name")
+				keypairName, err = s.PromptInput("Please enter a new key pair name")
+				if err != nil {
+					return err
+				}
 				if keypairName != "" {
 					s.SSHKeyName = keypairName
 					return s.createKeyPair()
@@ -356,28 +1291,100 @@ func (s *Stack) createKeyPair() error {
 }
 
 type stackTemplateData struct {
-	Instances           []struct{}
-	DefaultInstanceType string
+	Instances            []*stackTemplateInstance
+	DefaultInstanceType  string
+	PlacementGroup       string
+	CreatePlacementGroup bool
+	VpcID                string
+	SubnetID             string
+	EncryptVolumes       bool
+	DNSZoneID            string
+	SpotInstances        bool
+	SpotMaxPrice         string
+}
+
+type stackTemplateInstance struct {
+	LogicalID  string
+	Name       string
+	SnapshotID string
+	Last       bool
+}
+
+func (s *Stack) stackTemplateInstances() []*stackTemplateInstance {
+	instances := make([]*stackTemplateInstance, s.NumInstances)
+	for i := range instances {
+		instances[i] = &stackTemplateInstance{
+			LogicalID:  s.instanceLogicalID(i),
+			Name:       s.instanceNameTag(s.clusterName(), i),
+			SnapshotID: s.RestoreFromSnapshots[instanceName(i)],
+			Last:       i == len(instances)-1,
+		}
+	}
+	return instances
+}
+
+func instanceName(i int) string {
+	return fmt.Sprintf("Instance%d", i)
+}
+
+// instanceLogicalID returns the logical ID of the instance's stack resource,
+// which is its name until it has been replaced. Giving a replacement a new
+// logical ID makes CloudFormation create it before deleting the old one.
+func (s *Stack) instanceLogicalID(i int) string {
+	if gen := s.InstanceGenerations[i]; gen > 0 {
+		return fmt.Sprintf("%sR%d", instanceName(i), gen)
+	}
+	return instanceName(i)
+}
+
+// instanceIndex returns the index of the instance with the given logical ID,
+// or -1 if it isn't one of the stack's instances.
+func (s *Stack) instanceIndex(logicalID string) int {
+	for i := 0; i < s.NumInstances; i++ {
+		if s.instanceLogicalID(i) == logicalID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Stack) stackTemplateBody() (string, error) {
+	var stackTemplateBuffer bytes.Buffer
+	err := stackTemplate.Execute(&stackTemplateBuffer, &stackTemplateData{
+		Instances:            s.stackTemplateInstances(),
+		DefaultInstanceType:  DefaultInstanceType,
+		PlacementGroup:       s.PlacementGroup,
+		CreatePlacementGroup: s.CreatePlacementGroup,
+		VpcID:                s.VpcID,
+		SubnetID:             s.SubnetID,
+		EncryptVolumes:       s.EncryptVolumes,
+		DNSZoneID:            s.externalDNSZoneID(),
+		SpotInstances:        s.spotInstances(),
+		SpotMaxPrice:         s.SpotMaxPrice,
+	})
+	if err != nil {
+		return "", err
+	}
+	return stackTemplateBuffer.String(), nil
 }
 
 func (s *Stack) createStack() error {
 	s.SendEvent("Generating start script")
-	startScript, discoveryToken, err := genStartScript(s.NumInstances)
+	if s.DiscoveryToken == "" {
+		if err := s.ensureDiscoveryToken(s.NumInstances); err != nil {
+			return err
+		}
+	}
+	startScript, err := genStartScript(s.DiscoveryToken)
 	if err != nil {
 		return err
 	}
-	s.DiscoveryToken = discoveryToken
 	s.persist()
 
-	var stackTemplateBuffer bytes.Buffer
-	err = stackTemplate.Execute(&stackTemplateBuffer, &stackTemplateData{
-		Instances:           make([]struct{}, s.NumInstances),
-		DefaultInstanceType: DefaultInstanceType,
-	})
+	stackTemplateString, err := s.stackTemplateBody()
 	if err != nil {
 		return err
 	}
-	stackTemplateString := stackTemplateBuffer.String()
 
 	parameters := []cloudformation.Parameter{
 		{
@@ -408,13 +1415,21 @@ func (s *Stack) createStack() error {
 			ParameterKey:   aws.String("SubnetCidrBlock"),
 			ParameterValue: aws.String(s.SubnetCidr),
 		},
+		{
+			ParameterKey:   aws.String("VolumeSize"),
+			ParameterValue: aws.String(strconv.Itoa(s.VolumeSize)),
+		},
 	}
 
 	stackEventsSince := time.Now()
 
 	if s.StackID != "" && s.StackName != "" {
 		if err := s.fetchStack(); err == nil && !strings.HasPrefix(*s.Stack.StackStatus, "DELETE") {
-			if s.YesNoPrompt(fmt.Sprintf("Stack found from previous installation (%s), would you like to delete it? (a new one will be created either way)", s.StackName)) {
+			deleteStack, err := s.YesNoPrompt(fmt.Sprintf("Stack found from previous installation (%s), would you like to delete it? (a new one will be created either way)", s.StackName))
+			if err != nil {
+				return err
+			}
+			if deleteStack {
 				s.SendEvent(fmt.Sprintf("Deleting stack %s", s.StackName))
 				if err := s.cf.DeleteStack(&cloudformation.DeleteStackInput{
 					StackName: aws.String(s.StackName),
@@ -427,13 +1442,17 @@ func (s *Stack) createStack() error {
 
 	s.SendEvent("Creating stack")
 	s.StackName = fmt.Sprintf("flynn-%d", time.Now().Unix())
-	res, err := s.cf.CreateStack(&cloudformation.CreateStackInput{
-		OnFailure:        aws.String("DELETE"),
-		StackName:        aws.String(s.StackName),
-		Tags:             []cloudformation.Tag{},
-		TemplateBody:     aws.String(stackTemplateString),
-		TimeoutInMinutes: aws.Integer(10),
-		Parameters:       parameters,
+	var res *cloudformation.CreateStackResult
+	err = s.retryAWS("CreateStack", func() (err error) {
+		res, err = s.cf.CreateStack(&cloudformation.CreateStackInput{
+			OnFailure:        aws.String("DELETE"),
+			StackName:        aws.String(s.StackName),
+			Tags:             s.stackTags(),
+			TemplateBody:     aws.String(stackTemplateString),
+			TimeoutInMinutes: aws.Integer(10),
+			Parameters:       parameters,
+		})
+		return
 	})
 	if err != nil {
 		return err
@@ -441,28 +1460,33 @@ func (s *Stack) createStack() error {
 	s.StackID = *res.StackID
 
 	s.persist()
-	return s.waitForStackCompletion("CREATE", stackEventsSince)
+	if err := s.waitForStackCompletion("CREATE", stackEventsSince); err != nil {
+		return s.handleSpotFailure(err)
+	}
+	return nil
 }
 
-func fetchLatestVersion() (*release.EC2Version, error) {
+func fetchManifest(channel string) (*release.EC2Manifest, error) {
+	url, ok := ReleaseChannels[channel]
+	if !ok {
+		return nil, fmt.Errorf("Unknown release channel %s", channel)
+	}
 	client := &http.Client{}
-	resp, err := client.Get("https://dl.flynn.io/ec2/images.json")
+	resp, err := client.Get(url)
 	if err != nil {
 		return nil, err
 	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, errors.New(fmt.Sprintf("Failed to fetch list of flynn images: %s", resp.Status))
 	}
+	defer resp.Body.Close()
 	dec := json.NewDecoder(resp.Body)
-	manifest := release.EC2Manifest{}
-	err = dec.Decode(&manifest)
+	manifest := &release.EC2Manifest{}
+	err = dec.Decode(manifest)
 	if err != nil {
 		return nil, err
 	}
-	if len(manifest.Versions) == 0 {
-		return nil, errors.New("No versions in manifest")
-	}
-	return manifest.Versions[0], nil
+	return manifest, nil
 }
 
 type StackEventSort []cloudformation.StackEvent
@@ -486,15 +1510,22 @@ func (s *Stack) waitForStackCompletion(action string, after time.Time) error {
 	actionFailureSuffix := "_FAILED"
 	isComplete := false
 	isFailed := false
+	// the status and cause of a failure, for the StackRollbackError
+	var failedStatus, failedReason string
 
 	stackEvents := make([]cloudformation.StackEvent, 0)
 	var nextToken aws.StringValue
+	instancesRunning := 0
 
 	var fetchStackEvents func() error
 	fetchStackEvents = func() error {
-		res, err := s.cf.DescribeStackEvents(&cloudformation.DescribeStackEventsInput{
-			NextToken: nextToken,
-			StackName: stackID,
+		var res *cloudformation.DescribeStackEventsResult
+		err := s.retryAWS("DescribeStackEvents", func() (err error) {
+			res, err = s.cf.DescribeStackEvents(&cloudformation.DescribeStackEventsInput{
+				NextToken: nextToken,
+				StackName: stackID,
+			})
+			return
 		})
 		if err != nil {
 			switch err.(type) {
@@ -530,10 +1561,14 @@ func (s *Stack) waitForStackCompletion(action string, after time.Time) error {
 							isComplete = true
 						} else {
 							isFailed = true
+							failedStatus = *se.ResourceStatus
 						}
 					} else if strings.HasSuffix(*se.ResourceStatus, actionFailureSuffix) {
 						isFailed = true
+						failedStatus = *se.ResourceStatus
 					}
+				} else if strings.HasSuffix(*se.ResourceStatus, actionFailureSuffix) && se.ResourceStatusReason != nil && failedReason == "" {
+					failedReason = *se.ResourceStatusReason
 				}
 				var desc string
 				if se.ResourceStatusReason != nil {
@@ -543,7 +1578,10 @@ func (s *Stack) waitForStackCompletion(action string, after time.Time) error {
 				if se.LogicalResourceID != nil {
 					name = fmt.Sprintf("%s (%s)", name, *se.LogicalResourceID)
 				}
-				s.SendEvent(fmt.Sprintf("%s\t%s%s", name, *se.ResourceStatus, desc))
+				s.sendTypedEvent("stack_event", fmt.Sprintf("%s\t%s%s", name, *se.ResourceStatus, desc), nil)
+				if action == "CREATE" {
+					s.stackProgress(se, &instancesRunning)
+				}
 			}
 		}
 		if res.NextToken != nil {
@@ -554,15 +1592,72 @@ func (s *Stack) waitForStackCompletion(action string, after time.Time) error {
 		return nil
 	}
 
+	// only fetch and stream the individual stack events while someone is
+	// watching, otherwise poll the stack status. Events created while nobody
+	// was watching are sent once a subscriber appears.
+	checkStackStatus := func() error {
+		var res *cloudformation.DescribeStacksResult
+		err := s.retryAWS("DescribeStacks", func() (err error) {
+			res, err = s.cf.DescribeStacks(&cloudformation.DescribeStacksInput{
+				StackName: stackID,
+			})
+			return
+		})
+		if err != nil {
+			switch err.(type) {
+			case *url.Error:
+				return nil
+			default:
+				return err
+			}
+		}
+		if len(res.Stacks) == 0 || res.Stacks[0].StackStatus == nil {
+			return nil
+		}
+		status := *res.Stacks[0].StackStatus
+		if strings.HasSuffix(status, actionCompleteSuffix) {
+			if strings.HasPrefix(status, action) {
+				isComplete = true
+			} else {
+				isFailed = true
+			}
+		} else if strings.HasSuffix(status, actionFailureSuffix) {
+			isFailed = true
+		}
+		if isFailed {
+			failedStatus = status
+			if reason := res.Stacks[0].StackStatusReason; reason != nil && failedReason == "" {
+				failedReason = *reason
+			}
+		}
+		return nil
+	}
+
 	for {
-		if err := fetchStackEvents(); err != nil {
+		if s.cancelled() {
+			return ErrCancelled
+		}
+		check := checkStackStatus
+		streamed := s.hasSubscribers()
+		if streamed {
+			check = fetchStackEvents
+		}
+		if err := check(); err != nil {
 			return err
 		}
 		if isComplete {
+			// send the stack events nobody was watching so that the
+			// progress events are recorded
+			if !streamed {
+				nextToken = nil
+				if err := fetchStackEvents(); err != nil {
+					return err
+				}
+			}
 			break
 		}
 		if isFailed {
-			return fmt.Errorf("Failed to create stack %s", s.StackName)
+			return &StackRollbackError{StackName: s.StackName, Status: failedStatus, Reason: failedReason}
 		}
 		time.Sleep(1 * time.Second)
 	}
@@ -574,8 +1669,12 @@ func (s *Stack) fetchStack() error {
 	stackID := aws.String(s.StackID)
 
 	s.SendEvent("Fetching stack")
-	res, err := s.cf.DescribeStacks(&cloudformation.DescribeStacksInput{
-		StackName: stackID,
+	var res *cloudformation.DescribeStacksResult
+	err := s.retryAWS("DescribeStacks", func() (err error) {
+		res, err = s.cf.DescribeStacks(&cloudformation.DescribeStacksInput{
+			StackName: stackID,
+		})
+		return
 	})
 	if err != nil {
 		return err
@@ -592,20 +1691,37 @@ func (s *Stack) fetchStack() error {
 }
 
 func (s *Stack) fetchStackOutputs() error {
-	s.fetchStack()
+	if err := s.fetchStack(); err != nil {
+		return err
+	}
 
-	s.InstanceIPs = make([]string, 0, s.NumInstances)
+	// the outputs aren't in any particular order, so are matched to the
+	// instances by the index in their keys
+	s.InstanceIPs = make([]string, s.NumInstances)
+	s.healthCheckIDs = make(map[int]string, s.NumInstances)
+	found := 0
 	for _, o := range s.Stack.Outputs {
-		v := *o.OutputValue
-		if strings.HasPrefix(*o.OutputKey, "IPAddress") {
-			s.InstanceIPs = append(s.InstanceIPs, v)
+		if o.OutputKey == nil || o.OutputValue == nil {
+			continue
+		}
+		key, v := *o.OutputKey, *o.OutputValue
+		if strings.HasPrefix(key, "IPAddress") {
+			if i, err := strconv.Atoi(strings.TrimPrefix(key, "IPAddress")); err == nil && i >= 0 && i < s.NumInstances && s.InstanceIPs[i] == "" {
+				s.InstanceIPs[i] = v
+				found++
+			}
 		}
-		if *o.OutputKey == "DNSZoneID" {
+		if strings.HasPrefix(key, "HealthCheckID") {
+			if i, err := strconv.Atoi(strings.TrimPrefix(key, "HealthCheckID")); err == nil {
+				s.healthCheckIDs[i] = v
+			}
+		}
+		if key == "DNSZoneID" {
 			s.DNSZoneID = v
 		}
 	}
-	if len(s.InstanceIPs) != s.NumInstances {
-		return fmt.Errorf("expected stack outputs to include %d instance IPs but found %d", s.NumInstances, len(s.InstanceIPs))
+	if found != s.NumInstances {
+		return fmt.Errorf("expected stack outputs to include %d instance IPs but found %d", s.NumInstances, found)
 	}
 	if s.DNSZoneID == "" {
 		return fmt.Errorf("stack outputs do not include DNSZoneID")
@@ -617,14 +1733,26 @@ func (s *Stack) fetchStackOutputs() error {
 func (s *Stack) configureDNS() error {
 	// TODO(jvatic): Run directly after receiving zone create complete stack event
 	s.SendEvent("Configuring DNS")
+	if err := s.ensureDNSRecords(); err != nil {
+		return err
+	}
 
-	// Set region to us-east-1, since any other region will fail for global services like Route53
-	r53 := route53.New(s.Creds, "us-east-1", nil)
-	res, err := r53.GetHostedZone(&route53.GetHostedZoneRequest{ID: aws.String(s.DNSZoneID)})
+	// a custom domain is delegated to its zone by the user
+	if s.DomainName != "" {
+		return nil
+	}
+	if err := s.Domain.Validate(); err != nil {
+		return err
+	}
+	dns, err := s.dnsProvider()
+	if err != nil {
+		return err
+	}
+	nameservers, err := dns.Nameservers()
 	if err != nil {
 		return err
 	}
-	if err := s.Domain.Configure(res.DelegationSet.NameServers); err != nil {
+	if err := s.Domain.Configure(nameservers); err != nil {
 		return err
 	}
 
@@ -632,6 +1760,10 @@ func (s *Stack) configureDNS() error {
 }
 
 func (s *Stack) waitForDNS() error {
+	if s.DomainName != "" {
+		s.checkDelegation()
+		return nil
+	}
 	s.SendEvent("Waiting for DNS to propagate")
 	for {
 		status, err := s.Domain.Status()
@@ -643,6 +1775,11 @@ func (s *Stack) waitForDNS() error {
 		}
 		time.Sleep(time.Second)
 	}
+	if dns, err := s.dnsProvider(); err == nil {
+		if err := dns.VerifyDelegation(s.Domain.Name); err != nil {
+			s.SendEvent(fmt.Sprintf("WARNING: Unable to verify DNS delegation: %s", err))
+		}
+	}
 	s.SendEvent("DNS is live")
 	return nil
 }
@@ -694,7 +1831,16 @@ type stepInfo struct {
 }
 
 func (s *Stack) bootstrap() error {
-	s.SendEvent("Running bootstrap")
+	// a resumed install may have been interrupted while bootstrapping
+	if s.State != StateBootstrapping {
+		if err := s.setState(StateBootstrapping); err != nil {
+			return err
+		}
+	}
+	s.sendProgressEvent("bootstrap_started", "Running bootstrap", progressBootstrapStarted, map[string]string{
+		"channel": s.Channel,
+		"version": s.InstalledVersion,
+	})
 
 	if s.Stack == nil {
 		return errors.New("No stack found")
@@ -707,14 +1853,10 @@ func (s *Stack) bootstrap() error {
 	// bootstrap only needs to run on one instance
 	ipAddress := s.InstanceIPs[0]
 
-	signer, err := ssh.NewSignerFromKey(s.SSHKey.PrivateKey)
+	sshConfig, err := s.sshConfig()
 	if err != nil {
 		return err
 	}
-	sshConfig := &ssh.ClientConfig{
-		User: "ubuntu",
-		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
-	}
 
 	attempts := 0
 	maxAttempts := 3
@@ -733,6 +1875,10 @@ func (s *Stack) bootstrap() error {
 	}
 	defer sshConn.Close()
 
+	if err := s.checkHostVersion(sshConn); err != nil {
+		return err
+	}
+
 	sess, err := sshConn.NewSession()
 	if err != nil {
 		return err
@@ -742,7 +1888,12 @@ func (s *Stack) bootstrap() error {
 		return err
 	}
 	sess.Stderr = os.Stderr
-	if err := sess.Start(fmt.Sprintf("CLUSTER_DOMAIN=%s flynn-host bootstrap --json", s.Domain.Name)); err != nil {
+	cmd := fmt.Sprintf("CLUSTER_DOMAIN=%s flynn-host bootstrap --json", s.Domain.Name)
+	if s.bootstrapManifest != nil {
+		sess.Stdin = bytes.NewReader(s.bootstrapManifest)
+		cmd += " -"
+	}
+	if err := sess.Start(cmd); err != nil {
 		s.uploadDebugInfo(sshConfig, ipAddress)
 		return err
 	}
@@ -803,6 +1954,9 @@ func (s *Stack) bootstrap() error {
 	s.ControllerPin = controllerCertData.Pin
 	s.CACert = controllerCertData.CACert
 	s.DashboardLoginToken = loginTokenData.Token
+	if err := s.checkControllerPinFormat(); err != nil {
+		return err
+	}
 
 	if err := sess.Wait(); err != nil {
 		return err
@@ -810,7 +1964,11 @@ func (s *Stack) bootstrap() error {
 	if err := s.waitForDNS(); err != nil {
 		return err
 	}
+	if err := s.VerifyControllerIdentity(); err != nil {
+		s.SendEvent(fmt.Sprintf("WARNING: Unable to verify controller identity: %s", err))
+	}
 
+	s.sendProgressEvent("bootstrap_complete", "Bootstrap complete", progressBootstrapComplete, nil)
 	return nil
 }
 
@@ -830,21 +1988,48 @@ func (s *Stack) configureCLI() error {
 	return nil
 }
 
-func genStartScript(nodes int) (string, string, error) {
+var ErrTokenCapacityExceeded = errors.New("installer: number of instances exceeds discovery token capacity")
+
+// ensureDiscoveryToken mints a new discovery token unless the existing one
+// is large enough for the given number of instances.
+func (s *Stack) ensureDiscoveryToken(nodes int) error {
+	if s.DiscoveryToken != "" {
+		size, err := etcdcluster.DiscoveryTokenSize(s.DiscoveryToken)
+		if err == nil && size >= nodes {
+			return nil
+		}
+	}
+	token, err := etcdcluster.NewDiscoveryToken(strconv.Itoa(nodes))
+	if err != nil {
+		return err
+	}
+	s.DiscoveryToken = token
+	return nil
+}
+
+func (s *Stack) validateDiscoveryToken() error {
+	size, err := etcdcluster.DiscoveryTokenSize(s.DiscoveryToken)
+	if err != nil {
+		return fmt.Errorf("Unable to check discovery token: %s", err)
+	}
+	if s.NumInstances > size {
+		return ErrTokenCapacityExceeded
+	}
+	return nil
+}
+
+func genStartScript(discoveryToken string) (string, error) {
 	var data struct {
 		DiscoveryToken string
 	}
+	data.DiscoveryToken = discoveryToken
 	var err error
-	data.DiscoveryToken, err = etcdcluster.NewDiscoveryToken(strconv.Itoa(nodes))
-	if err != nil {
-		return "", "", err
-	}
 	buf := &bytes.Buffer{}
 	w := base64.NewEncoder(base64.StdEncoding, buf)
 	err = startScript.Execute(w, data)
 	w.Close()
 
-	return buf.String(), data.DiscoveryToken, err
+	return buf.String(), err
 }
 
 var startScript = template.Must(template.New("start.sh").Parse(`
@@ -0,0 +1,72 @@
+package installer
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultInstanceNameTemplate is the default Name tag of instances. The
+// placeholders {cluster}, {role} and {index} are replaced with the cluster
+// ID, the role of the instance and its index.
+var DefaultInstanceNameTemplate = "flynn-{cluster}-{role}-{index}"
+
+// instanceRole is the role of every instance, they all run flynn-host.
+const instanceRole = "host"
+
+// maxTagValueLength is the maximum length of an EC2 tag value.
+const maxTagValueLength = 255
+
+var (
+	namePlaceholder   = regexp.MustCompile(`\{[^}]*\}`)
+	nameTemplateChars = regexp.MustCompile(`^[A-Za-z0-9 _.:/=+@-]*$`)
+)
+
+func (s *Stack) validateInstanceNameTemplate() error {
+	t := s.InstanceNameTemplate
+	for _, p := range namePlaceholder.FindAllString(t, -1) {
+		switch p {
+		case "{cluster}", "{role}", "{index}":
+		default:
+			return fmt.Errorf("Unknown placeholder %s in instance name template, expected {cluster}, {role} or {index}", p)
+		}
+	}
+	if !nameTemplateChars.MatchString(namePlaceholder.ReplaceAllString(t, "")) {
+		return fmt.Errorf("Instance name template %q contains invalid characters", t)
+	}
+	// cluster IDs are 32 characters long
+	cluster := s.clusterName()
+	if len(cluster) < 32 {
+		cluster = strings.Repeat("x", 32)
+	}
+	if name := s.instanceNameTag(cluster, s.NumInstances-1); len(name) > maxTagValueLength {
+		return fmt.Errorf("Instance names may be %d characters long, the maximum is %d", len(name), maxTagValueLength)
+	} else if name == "" {
+		return fmt.Errorf("Instance name template %q produces empty names", t)
+	}
+	return nil
+}
+
+// clusterName returns the name used for the {cluster} placeholder.
+func (s *Stack) clusterName() string {
+	if s.ID != "" {
+		return s.ID
+	}
+	if s.Domain != nil && s.Domain.Name != "" {
+		return strings.SplitN(s.Domain.Name, ".", 2)[0]
+	}
+	return "flynn"
+}
+
+func (s *Stack) instanceNameTag(cluster string, i int) string {
+	t := s.InstanceNameTemplate
+	if t == "" {
+		t = DefaultInstanceNameTemplate
+	}
+	return strings.NewReplacer(
+		"{cluster}", cluster,
+		"{role}", instanceRole,
+		"{index}", strconv.Itoa(i),
+	).Replace(t)
+}
@@ -0,0 +1,137 @@
+package installer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/aws"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/ec2"
+)
+
+// Regions are the AWS regions clusters can be launched in, it should be
+// updated as AWS opens regions.
+var Regions = []string{
+	"af-south-1",
+	"ap-east-1",
+	"ap-northeast-1",
+	"ap-northeast-2",
+	"ap-northeast-3",
+	"ap-south-1",
+	"ap-southeast-1",
+	"ap-southeast-2",
+	"ca-central-1",
+	"eu-central-1",
+	"eu-north-1",
+	"eu-south-1",
+	"eu-west-1",
+	"eu-west-2",
+	"eu-west-3",
+	"me-south-1",
+	"sa-east-1",
+	"us-east-1",
+	"us-east-2",
+	"us-west-1",
+	"us-west-2",
+}
+
+func validateRegion(region string) error {
+	for _, r := range Regions {
+		if r == region {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown region %s, expected one of %s", region, strings.Join(Regions, ", "))
+}
+
+// checkLaunchable verifies that instances of the chosen type can be launched
+// from the image in the stack's region by asking EC2 for a dry run, so that
+// a mistake fails the install straight away rather than once CloudFormation
+// tries to create the instances. Only errors which show the image or the
+// instance type to be unavailable fail the check, as the dry run also fails
+// for reasons which don't affect the stack, such as the account having no
+// default VPC.
+func (s *Stack) checkLaunchable() error {
+	s.SendEvent(fmt.Sprintf("Checking %s instances can be launched from image %s in %s", s.InstanceType, s.ImageID, s.Region))
+	if err := s.checkImageVolumeSize(); err != nil {
+		return err
+	}
+	var err error
+	if rerr := s.retryAWS("RunInstances", func() error {
+		_, err = s.ec2.RunInstances(&ec2.RunInstancesRequest{
+			DryRun:       aws.Boolean(true),
+			ImageID:      aws.String(s.ImageID),
+			InstanceType: aws.String(s.InstanceType),
+			MinCount:     aws.Integer(1),
+			MaxCount:     aws.Integer(1),
+		})
+		// the dry run always fails, so only retry transient errors
+		if retryableAWSError(err) {
+			return err
+		}
+		return nil
+	}); rerr == ErrCancelled {
+		return rerr
+	}
+	return s.launchError(err)
+}
+
+// launchError returns the error to fail the install with given the result
+// of a dry run launch, or nil if the launch would have succeeded.
+func (s *Stack) launchError(err error) error {
+	apiErr, ok := err.(aws.APIError)
+	if !ok {
+		if err != nil {
+			s.SendEvent(fmt.Sprintf("WARNING: Unable to check instances can be launched: %s", err))
+		}
+		return nil
+	}
+	switch {
+	case apiErr.Code == "DryRunOperation":
+		return nil
+	case strings.HasPrefix(apiErr.Code, "InvalidAMIID."):
+		return fmt.Errorf("Image %s does not exist in %s: %s", s.ImageID, s.Region, apiErr.Message)
+	case apiErr.Code == "Unsupported",
+		apiErr.Code == "InvalidParameterValue" && strings.Contains(apiErr.Message, s.InstanceType):
+		return fmt.Errorf("Instance type %s is not available in %s: %s", s.InstanceType, s.Region, apiErr.Message)
+	}
+	s.SendEvent(fmt.Sprintf("WARNING: Unable to check instances can be launched: %s", apiErr.Message))
+	return nil
+}
+
+// checkImageVolumeSize verifies that the image's root volume fits in the
+// instance volumes, as CloudFormation otherwise fails to create the
+// instances.
+func (s *Stack) checkImageVolumeSize() error {
+	var res *ec2.DescribeImagesResult
+	if err := s.retryAWS("DescribeImages", func() (err error) {
+		res, err = s.ec2.DescribeImages(&ec2.DescribeImagesRequest{ImageIDs: []string{s.ImageID}})
+		return
+	}); err != nil {
+		// a missing image is reported by the dry run
+		if apiErr, ok := err.(aws.APIError); ok && strings.HasPrefix(apiErr.Code, "InvalidAMIID.") {
+			return nil
+		}
+		return err
+	}
+	if len(res.Images) == 0 {
+		return nil
+	}
+	if size := imageRootVolumeSize(res.Images[0]); size > s.VolumeSize {
+		return fmt.Errorf("Image %s has a %dGB root volume, larger than the %dGB VolumeSize", s.ImageID, size, s.VolumeSize)
+	}
+	return nil
+}
+
+// imageRootVolumeSize returns the size in GB of the image's root volume, or
+// 0 if it isn't known.
+func imageRootVolumeSize(image ec2.Image) int {
+	if image.RootDeviceName == nil {
+		return 0
+	}
+	for _, m := range image.BlockDeviceMappings {
+		if m.DeviceName != nil && *m.DeviceName == *image.RootDeviceName && m.EBS != nil && m.EBS.VolumeSize != nil {
+			return *m.EBS.VolumeSize
+		}
+	}
+	return 0
+}
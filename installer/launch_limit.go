@@ -0,0 +1,53 @@
+package installer
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// MaxConcurrentLaunches is the number of installs which may run at once,
+// further launches wait for one of them to finish so as not to exhaust the
+// AWS API limits or the resources of the host. Zero means no limit.
+var MaxConcurrentLaunches = 4
+
+// launchSemaphore returns the slots of the installs which are running, it
+// is nil if there is no limit.
+func (api *httpAPI) launchSemaphore() chan struct{} {
+	api.launchSlotsOnce.Do(func() {
+		if MaxConcurrentLaunches > 0 {
+			api.launchSlots = make(chan struct{}, MaxConcurrentLaunches)
+		}
+	})
+	return api.launchSlots
+}
+
+// acquireLaunchSlot waits for the install to be allowed to run, sending a
+// cluster_queued event if it has to wait. It returns false if the install
+// is cancelled while waiting.
+func (s *Stack) acquireLaunchSlot() bool {
+	if s.launchSlots == nil {
+		return true
+	}
+	select {
+	case s.launchSlots <- struct{}{}:
+		return true
+	default:
+	}
+	limit := cap(s.launchSlots)
+	s.sendTypedEvent("cluster_queued", fmt.Sprintf("Waiting for one of the %d installs in progress to finish", limit), map[string]string{
+		"limit": strconv.Itoa(limit),
+	})
+	select {
+	case s.launchSlots <- struct{}{}:
+		s.SendEvent("Starting install")
+		return true
+	case <-s.cancelChan():
+		return false
+	}
+}
+
+func (s *Stack) releaseLaunchSlot() {
+	if s.launchSlots != nil {
+		<-s.launchSlots
+	}
+}
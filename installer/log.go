@@ -0,0 +1,53 @@
+package installer
+
+import (
+	"sync"
+
+	log "github.com/flynn/flynn/Godeps/_workspace/src/gopkg.in/inconshreveable/log15.v2"
+)
+
+// logSinks is a log handler which fans records out to a set of named
+// handlers which may be changed at any time.
+type logSinks struct {
+	mtx      sync.RWMutex
+	handlers map[string]log.Handler
+}
+
+func newLogSinks() *logSinks {
+	return &logSinks{handlers: map[string]log.Handler{"stdout": log.StdoutHandler}}
+}
+
+func (l *logSinks) Log(r *log.Record) error {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+	var err error
+	for _, h := range l.handlers {
+		if e := h.Log(r); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (l *logSinks) Add(name string, h log.Handler) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.handlers[name] = h
+}
+
+func (l *logSinks) Remove(name string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	delete(l.handlers, name)
+}
+
+// AddLogSink sends the logs of all installs to h as well as the existing
+// sinks, replacing any sink with the same name. Logs go to a sink named
+// "stdout" by default.
+func (api *httpAPI) AddLogSink(name string, h log.Handler) {
+	api.logSinks.Add(name, h)
+}
+
+func (api *httpAPI) RemoveLogSink(name string) {
+	api.logSinks.Remove(name)
+}
@@ -0,0 +1,59 @@
+package installer
+
+import (
+	"os"
+
+	cfg "github.com/flynn/flynn/cli/config"
+)
+
+// LoginInfo is what the flynn CLI needs to log in to a cluster.
+type LoginInfo struct {
+	ClusterName   string `json:"cluster_name"`
+	ControllerURL string `json:"controller_url"`
+	ControllerKey string `json:"controller_key"`
+	TLSPin        string `json:"tls_pin"`
+	GitHost       string `json:"git_host"`
+	CACert        string `json:"ca_cert,omitempty"`
+}
+
+// ClusterLoginInfo returns the login details of the cluster with the given
+// ID, returning ErrConfigNotReady until the install has finished.
+func (api *httpAPI) ClusterLoginInfo(id string) (*LoginInfo, error) {
+	s, err := api.FindCluster(id)
+	if os.IsNotExist(err) {
+		return nil, ErrClusterNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return s.loginInfo()
+}
+
+func (s *Stack) loginInfo() (*LoginInfo, error) {
+	if s.State != StateRunning || s.ControllerKey == "" || s.Domain == nil || s.Domain.Name == "" {
+		return nil, ErrConfigNotReady
+	}
+	conf := s.ClusterConfig()
+	return &LoginInfo{
+		ClusterName:   conf.Name,
+		ControllerURL: conf.URL,
+		ControllerKey: conf.Key,
+		TLSPin:        conf.TLSPin,
+		GitHost:       conf.GitHost,
+		CACert:        s.CACert,
+	}, nil
+}
+
+// Flynnrc renders the login details as a flynnrc with the cluster as the
+// default, to be saved as ~/.flynnrc or pointed to with FLYNNRC. The CA
+// certificate is left out as the CLI verifies the controller by its pin.
+func (l *LoginInfo) Flynnrc() []byte {
+	config := &cfg.Config{Default: l.ClusterName}
+	config.Clusters = []*cfg.Cluster{{
+		Name:    l.ClusterName,
+		URL:     l.ControllerURL,
+		Key:     l.ControllerKey,
+		GitHost: l.GitHost,
+		TLSPin:  l.TLSPin,
+	}}
+	return config.Marshal()
+}
@@ -8,17 +8,24 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
+	log "github.com/flynn/flynn/Godeps/_workspace/src/gopkg.in/inconshreveable/log15.v2"
 	"github.com/flynn/flynn/cli/config"
 	"github.com/flynn/flynn/pkg/sshkeygen"
 )
 
-var keysDir, dataPath string
+var keysDir, dataPath, historyPath, credentialsPath, clustersDir, queueDir string
 
 func init() {
 	dir := filepath.Join(config.Dir(), "installer")
 	keysDir = filepath.Join(dir, "keys")
 	dataPath = filepath.Join(dir, "data.json")
+	historyPath = filepath.Join(dir, "history.json")
+	credentialsPath = filepath.Join(dir, "credentials.json")
+	clustersDir = filepath.Join(dir, "clusters")
+	queueDir = filepath.Join(dir, "queue")
 }
 
 func (s *Stack) load() error {
@@ -37,25 +44,142 @@ func (s *Stack) load() error {
 	return nil
 }
 
+// persist saves the stack. A stack with an ID is saved to its own file in
+// clustersDir only, the data file is shared by all installs so it is just
+// for stacks without one.
 func (s *Stack) persist() error {
 	s.persistMutex.Lock()
 	defer s.persistMutex.Unlock()
 
+	if s.ID != "" {
+		return s.persistCluster()
+	}
 	if err := os.MkdirAll(filepath.Dir(dataPath), 0755); err != nil {
 		return err
 	}
-	file, err := os.Create(dataPath)
+	return writeJSONFile(dataPath, s, 0644)
+}
+
+// persistCluster saves the stack alongside those of all other installs so it
+// can be found by ID, the caller must hold persistMutex.
+func (s *Stack) persistCluster() error {
+	defer clusterCache.Invalidate(s.ID)
+
+	if err := os.MkdirAll(clustersDir, 0755); err != nil {
+		return err
+	}
+	return writeJSONFile(filepath.Join(clustersDir, s.ID+".json"), s, 0600)
+}
+
+// writeJSONFile replaces the file at path with the JSON encoding of v. The
+// value is encoded before anything is written, so an encoding error leaves
+// the file as it was.
+func writeJSONFile(path string, v interface{}, perm os.FileMode) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("Unable to encode %s: %s", filepath.Base(path), err)
+	}
+	return writeFile(path, append(data, '\n'), perm)
+}
+
+// writeFile replaces the file at path with data by renaming a temporary
+// file over it, so a crash never leaves a partially written file behind.
+// Each write has its own temporary file, so concurrent writes of the same
+// file never mix.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	enc := json.NewEncoder(file)
-	if err := enc.Encode(s); err != nil {
+	tmp := file.Name()
+	fail := func(err error) error {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Chmod(perm); err != nil {
+		return fail(err)
+	}
+	if _, err := file.Write(data); err != nil {
+		return fail(err)
+	}
+	if err := file.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
 		return err
 	}
 	return nil
 }
 
+func loadCluster(id string) (*Stack, error) {
+	file, err := os.Open(filepath.Join(clustersDir, filepath.Base(id)+".json"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	s := &Stack{}
+	if err := json.NewDecoder(file).Decode(s); err != nil {
+		return nil, err
+	}
+	// a cluster with a malformed pin is still listed and can be deleted
+	s.MalformedControllerPin = false
+	if err := s.checkControllerPinFormat(); err != nil {
+		log.Error("saved cluster has a malformed controller pin", "cluster", id, "err", err)
+		s.MalformedControllerPin = true
+	}
+	return s, nil
+}
+
+// ListClusters returns the stacks of the installs in progress and of those
+// saved to disk, each exactly once and ordered by ID.
+func (api *httpAPI) ListClusters() ([]*Stack, error) {
+	var stacks []*Stack
+	seen := make(map[string]struct{})
+	for _, s := range api.snapshotClusters() {
+		stacks = append(stacks, s.Stack)
+		seen[s.ID] = struct{}{}
+	}
+
+	files, err := ioutil.ReadDir(clustersDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, f := range files {
+		// clusters are saved as <id>.json and their events as <id>.events.json
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".events.json") {
+			continue
+		}
+		id := strings.TrimSuffix(name, ".json")
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		s, err := api.FindCluster(id)
+		if os.IsNotExist(err) {
+			// deleted since the directory was read
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		stacks = append(stacks, s)
+		seen[id] = struct{}{}
+	}
+	sort.Sort(stackSort(stacks))
+	return stacks, nil
+}
+
+type stackSort []*Stack
+
+func (s stackSort) Len() int           { return len(s) }
+func (s stackSort) Less(i, j int) bool { return s[i].ID < s[j].ID }
+func (s stackSort) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
 func saveSSHKey(name string, key *sshkeygen.SSHKey) error {
 	if err := os.MkdirAll(keysDir, 0755); err != nil {
 		return err
@@ -0,0 +1,87 @@
+package installer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net"
+	"time"
+)
+
+var (
+	ErrMalformedControllerPin = errors.New("installer: controller pin or CA certificate is malformed")
+	ErrPinCertMismatch        = errors.New("installer: controller certificate does not match pin or CA certificate")
+)
+
+// checkControllerPinFormat checks that the stored controller pin is a
+// SHA256 hash and that the CA certificate is one. The pin is the hash of
+// the controller's own certificate, which is only signed by the CA, so the
+// two can't be checked against each other without connecting to the
+// controller, which VerifyControllerIdentity does.
+func (s *Stack) checkControllerPinFormat() error {
+	if s.ControllerPin == "" && s.CACert == "" {
+		return nil
+	}
+	if _, err := s.caCertPool(); err != nil {
+		return err
+	}
+	if pin, err := base64.StdEncoding.DecodeString(s.ControllerPin); err != nil || len(pin) != sha256.Size {
+		return ErrMalformedControllerPin
+	}
+	return nil
+}
+
+func (s *Stack) caCertPool() (*x509.CertPool, error) {
+	b, _ := pem.Decode([]byte(s.CACert))
+	if b == nil {
+		return nil, ErrMalformedControllerPin
+	}
+	ca, err := x509.ParseCertificate(b.Bytes)
+	if err != nil || !ca.IsCA {
+		return nil, ErrMalformedControllerPin
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return pool, nil
+}
+
+// VerifyControllerIdentity connects to the controller and checks that the
+// certificate it presents has the stored pin and is signed by the stored CA.
+func (s *Stack) VerifyControllerIdentity() error {
+	if err := s.checkControllerPinFormat(); err != nil {
+		return err
+	}
+	if len(s.InstanceIPs) == 0 || s.Domain == nil {
+		return errors.New("installer: cluster has no instances")
+	}
+	pool, err := s.caCertPool()
+	if err != nil {
+		return err
+	}
+	serverName := "controller." + s.Domain.Name
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", s.InstanceIPs[0]+":443", &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return ErrPinCertMismatch
+	}
+	pin, _ := base64.StdEncoding.DecodeString(s.ControllerPin)
+	sum := sha256.Sum256(certs[0].Raw)
+	if !bytes.Equal(sum[:], pin) {
+		return ErrPinCertMismatch
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{DNSName: serverName, Roots: pool}); err != nil {
+		return ErrPinCertMismatch
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package installer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/golang.org/x/crypto/ssh"
+	"github.com/flynn/flynn/pkg/attempt"
+)
+
+var instanceProbeAttempts = attempt.Strategy{
+	Total: 5 * time.Minute,
+	Delay: 5 * time.Second,
+}
+
+func (s *Stack) sshConfig() (*ssh.ClientConfig, error) {
+	signer, err := ssh.NewSignerFromKey(s.SSHKey.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &ssh.ClientConfig{
+		User: "ubuntu",
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+	}, nil
+}
+
+// probeInstances waits for flynn-host to be up on every instance, sending an
+// instance_ready or instance_failed event for each as soon as it is known.
+//
+// If PostBootWait is set, it is waited for once all instances are ready.
+//
+// The instances are plain CloudFormation resources rather than part of an
+// auto scaling group, so failed instances are reported rather than replaced.
+func (s *Stack) probeInstances() error {
+	if s.SSHKey == nil {
+		return fmt.Errorf("No SSHKey found")
+	}
+	sshConfig, err := s.sshConfig()
+	if err != nil {
+		return err
+	}
+
+	s.SendEvent("Waiting for instances to become ready")
+	var wg sync.WaitGroup
+	var mtx sync.Mutex
+	var failed []string
+	for i, ip := range s.InstanceIPs {
+		wg.Add(1)
+		go func(name, ip string) {
+			defer wg.Done()
+			metadata := map[string]string{"instance": name, "ip": ip}
+			if err := instanceProbeAttempts.Run(func() error {
+				return probeInstance(sshConfig, ip)
+			}); err != nil {
+				s.sendTypedEvent("instance_failed", fmt.Sprintf("Instance %s (%s) failed: %s", name, ip, err), metadata)
+				mtx.Lock()
+				failed = append(failed, name)
+				mtx.Unlock()
+				return
+			}
+			s.sendTypedEvent("instance_ready", fmt.Sprintf("Instance %s (%s) is ready", name, ip), metadata)
+		}(instanceName(i), ip)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Instances failed to become ready: %s", strings.Join(failed, ", "))
+	}
+
+	if s.PostBootWait > 0 {
+		s.SendEvent(fmt.Sprintf("Waiting %s for instances to settle", s.PostBootWait))
+		select {
+		case <-time.After(s.PostBootWait):
+		case <-s.cancelChan():
+			return ErrCancelled
+		}
+	}
+	return nil
+}
+
+// probeInstance checks flynn-host is up on the instance with the given IP.
+var probeInstance = func(sshConfig *ssh.ClientConfig, ip string) error {
+	return sshRun(sshConfig, ip, "curl -fsS -o /dev/null http://localhost:1113/host/jobs")
+}
+
+func sshRun(sshConfig *ssh.ClientConfig, ip, cmd string) error {
+	conn, err := ssh.Dial("tcp", ip+":22", sshConfig)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	sess, err := conn.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+	return sess.Run(cmd)
+}
@@ -0,0 +1,69 @@
+package installer
+
+import (
+	"fmt"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/cloudformation"
+)
+
+// The progress of an install, in percent, at each provisioning milestone.
+// Creating the stack takes up most of an install, with the instances being
+// launched towards the end of it.
+const (
+	progressVPCCreated           = 10
+	progressSecurityGroupCreated = 20
+	progressInstancesLaunching   = 30
+	progressInstancesRunning     = 60
+	progressBootstrapStarted     = 70
+	progressBootstrapComplete    = 95
+)
+
+// sendProgressEvent sends an event marking a provisioning milestone, along
+// with the install's progress and the cluster ID.
+func (s *Stack) sendProgressEvent(eventType, description string, percent int, metadata map[string]string) {
+	if metadata == nil {
+		metadata = make(map[string]string, 1)
+	}
+	metadata["cluster_id"] = s.ID
+	select {
+	case s.EventChan <- &Event{Type: eventType, Description: description, Metadata: metadata, Percent: percent}:
+	case <-s.cancelChan():
+	}
+}
+
+// stackProgress follows the creation of the stack's resources, sending a
+// progress event when the VPC or security group has been created and as each
+// instance is launched and running. running counts the running instances.
+func (s *Stack) stackProgress(se cloudformation.StackEvent, running *int) {
+	if se.ResourceType == nil || se.ResourceStatus == nil || se.LogicalResourceID == nil {
+		return
+	}
+	name := *se.LogicalResourceID
+	metadata := map[string]string{"resource": name}
+	if se.PhysicalResourceID != nil && *se.PhysicalResourceID != "" {
+		metadata["resource_id"] = *se.PhysicalResourceID
+	}
+	switch *se.ResourceType + " " + *se.ResourceStatus {
+	case "AWS::EC2::VPC CREATE_COMPLETE":
+		s.sendProgressEvent("vpc_created", fmt.Sprintf("Created VPC %s", metadata["resource_id"]), progressVPCCreated, metadata)
+	case "AWS::EC2::SecurityGroup CREATE_COMPLETE":
+		s.sendProgressEvent("security_group_created", fmt.Sprintf("Created security group %s", metadata["resource_id"]), progressSecurityGroupCreated, metadata)
+	case "AWS::EC2::Instance CREATE_IN_PROGRESS":
+		// CloudFormation reports the creation starting and then the
+		// physical ID being assigned, only the first is a launch
+		if _, ok := metadata["resource_id"]; ok {
+			return
+		}
+		s.sendProgressEvent("instance_launching", fmt.Sprintf("Launching instance %s", name), progressInstancesLaunching, metadata)
+		s.spotProgress(*se.ResourceStatus, metadata)
+	case "AWS::EC2::Instance CREATE_COMPLETE":
+		*running++
+		metadata["instance_id"] = metadata["resource_id"]
+		percent := progressInstancesLaunching
+		if s.NumInstances > 0 {
+			percent += (progressInstancesRunning - progressInstancesLaunching) * *running / s.NumInstances
+		}
+		s.sendProgressEvent("instance_running", fmt.Sprintf("Instance %s (%s) is running", name, metadata["instance_id"]), percent, metadata)
+		s.spotProgress(*se.ResourceStatus, metadata)
+	}
+}
@@ -0,0 +1,295 @@
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/golang.org/x/net/context"
+	log "github.com/flynn/flynn/Godeps/_workspace/src/gopkg.in/inconshreveable/log15.v2"
+	"github.com/flynn/flynn/pkg/random"
+)
+
+const (
+	JobLaunch = "launch"
+	JobDelete = "delete"
+)
+
+// Job is an operation on a cluster which is recorded before it starts and
+// removed once it has finished, so operations interrupted by a restart are
+// resumed when the installer next starts.
+type Job struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	ClusterID string          `json:"cluster_id"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// JobQueue stores jobs durably until they are complete.
+type JobQueue interface {
+	Enqueue(job *Job) error
+	Complete(id string) error
+
+	// Pending returns the jobs which haven't completed, oldest first.
+	Pending() ([]*Job, error)
+}
+
+type fileJobQueue struct {
+	dir string
+	mtx sync.Mutex
+}
+
+func newFileJobQueue(dir string) *fileJobQueue {
+	return &fileJobQueue{dir: dir}
+}
+
+func (q *fileJobQueue) Enqueue(job *Job) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if job.ID == "" {
+		job.ID = random.Hex(16)
+	}
+	if job.CreatedAt.IsZero() {
+		job.CreatedAt = time.Now()
+	}
+	if err := os.MkdirAll(q.dir, 0755); err != nil {
+		return err
+	}
+	return writeJSONFile(filepath.Join(q.dir, job.ID+".json"), job, 0600)
+}
+
+func (q *fileJobQueue) Complete(id string) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	err := os.Remove(filepath.Join(q.dir, filepath.Base(id)+".json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (q *fileJobQueue) Pending() ([]*Job, error) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(q.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*Job, 0, len(paths))
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		job := &Job{}
+		err = json.NewDecoder(file).Decode(job)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Sort(jobSort(jobs))
+	return jobs, nil
+}
+
+type jobSort []*Job
+
+func (j jobSort) Len() int           { return len(j) }
+func (j jobSort) Less(a, b int) bool { return j[a].CreatedAt.Before(j[b].CreatedAt) }
+func (j jobSort) Swap(a, b int)      { j[a], j[b] = j[b], j[a] }
+
+var clusterIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// AlreadyExistsError is returned when launching a cluster with the ID of one
+// which already exists and isn't being launched.
+type AlreadyExistsError struct {
+	ID    string
+	State string
+}
+
+func (e *AlreadyExistsError) Error() string {
+	return fmt.Sprintf("installer: cluster %s already exists (%s)", e.ID, e.State)
+}
+
+// existingLaunch returns the install of the cluster with the given ID if it
+// is still being launched, or an AlreadyExistsError if the cluster otherwise
+// exists. The caller must hold InstallerStackMtx.
+func (api *httpAPI) existingLaunch(id string) (*httpInstaller, error) {
+	if inst := api.InstallerStacks[id]; inst != nil {
+		select {
+		case <-inst.Stack.doneChan():
+		default:
+			return inst, nil
+		}
+		return nil, &AlreadyExistsError{ID: id, State: inst.Stack.currentState()}
+	}
+	s, err := loadCluster(id)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return nil, &AlreadyExistsError{ID: id, State: s.State}
+}
+
+// launch queues and starts an install.
+func (api *httpAPI) launch(input *jsonInput) (*httpInstaller, error) {
+	return api.launchContext(context.Background(), input)
+}
+
+// launchContext queues and starts an install which is aborted, as if by
+// CancelInstall, if ctx is done before the install finishes. If the input
+// has the ClusterID of an install still being launched, as when a launch is
+// retried, that install is returned rather than a duplicate being started.
+func (api *httpAPI) launchContext(ctx context.Context, input *jsonInput) (*httpInstaller, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	id := input.ClusterID
+	if id == "" {
+		id = random.Hex(16)
+	} else if !clusterIDPattern.MatchString(id) {
+		return nil, validationErr("cluster_id", "must be at most 64 letters, digits, dashes or underscores")
+	} else {
+		api.InstallerStackMtx.RLock()
+		inst, err := api.existingLaunch(id)
+		api.InstallerStackMtx.RUnlock()
+		if inst != nil || err != nil {
+			return inst, err
+		}
+	}
+	data, err := encodeLaunchInput(input)
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{Type: JobLaunch, ClusterID: id, Input: data}
+	if err := api.queue.Enqueue(job); err != nil {
+		return nil, err
+	}
+	s, existing, err := api.launchCluster(job.ClusterID, input)
+	if err != nil || existing {
+		// the install of a concurrent launch with the same ID is
+		// completed by the job of that launch
+		api.completeJob(job)
+		return s, err
+	}
+	go api.completeWhenDone(job, s)
+	if ctx.Done() != nil {
+		go api.cancelWhenDone(ctx, s)
+	}
+	return s, nil
+}
+
+// encodeLaunchInput encodes the input of a launch job. Jobs are stored on
+// disk, so the secret access key is encrypted like the stored credentials if
+// the CredentialsKeyEnv passphrase is set.
+func encodeLaunchInput(input *jsonInput) (json.RawMessage, error) {
+	if passphrase := credentialsPassphrase(); passphrase != "" && input.Creds.SecretAccessKey != "" {
+		encrypted := *input
+		var err error
+		if encrypted.Creds.SecretAccessKey, err = encryptSecret(passphrase, input.Creds.SecretAccessKey); err != nil {
+			return nil, err
+		}
+		input = &encrypted
+	}
+	return json.Marshal(input)
+}
+
+// decodeLaunchInput decodes the input of a launch job, decrypting its secret
+// access key.
+func decodeLaunchInput(data json.RawMessage) (*jsonInput, error) {
+	var input *jsonInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		return nil, err
+	}
+	if input != nil && isEncryptedSecret(input.Creds.SecretAccessKey) {
+		secret, err := decryptSecret(credentialsPassphrase(), input.Creds.SecretAccessKey)
+		if err != nil {
+			return nil, err
+		}
+		input.Creds.SecretAccessKey = secret
+	}
+	return input, nil
+}
+
+// delete queues and runs the deletion of a cluster.
+func (api *httpAPI) delete(id string) error {
+	job := &Job{Type: JobDelete, ClusterID: id}
+	if err := api.queue.Enqueue(job); err != nil {
+		return err
+	}
+	defer api.completeJob(job)
+	return api.DeleteCluster(id)
+}
+
+func (api *httpAPI) completeWhenDone(job *Job, s *httpInstaller) {
+	<-s.Stack.doneChan()
+	api.completeJob(job)
+}
+
+func (api *httpAPI) completeJob(job *Job) {
+	if err := api.queue.Complete(job.ID); err != nil {
+		log.Error("error completing job", "id", job.ID, "type", job.Type, "err", err)
+	}
+}
+
+// resumeJobs runs the jobs left over from a previous run of the installer.
+func (api *httpAPI) resumeJobs() {
+	jobs, err := api.queue.Pending()
+	if err != nil {
+		log.Error("error loading pending jobs", "err", err)
+		return
+	}
+	for _, job := range jobs {
+		l := log.New("job", job.ID, "type", job.Type, "cluster", job.ClusterID)
+		switch job.Type {
+		case JobLaunch:
+			api.InstallerStackMtx.RLock()
+			inst := api.InstallerStacks[job.ClusterID]
+			api.InstallerStackMtx.RUnlock()
+			if inst != nil {
+				// resumed by ResumeClusters
+				go api.completeWhenDone(job, inst)
+				continue
+			}
+			if _, err := loadCluster(job.ClusterID); err == nil {
+				// the install was saved, so ResumeClusters either resumed
+				// it or failed it as it couldn't be resumed
+				api.completeJob(job)
+				continue
+			}
+			input, err := decodeLaunchInput(job.Input)
+			if err != nil {
+				l.Error("error decoding job input", "err", err)
+				api.completeJob(job)
+				continue
+			}
+			l.Info("resuming install")
+			s, _, err := api.launchCluster(job.ClusterID, input)
+			if err != nil {
+				l.Error("error resuming install", "err", err)
+				api.completeJob(job)
+				continue
+			}
+			go api.completeWhenDone(job, s)
+		case JobDelete:
+			l.Info("resuming delete")
+			if err := api.DeleteCluster(job.ClusterID); err != nil {
+				l.Error("error resuming delete", "err", err)
+			}
+			api.completeJob(job)
+		default:
+			l.Error("unknown job type")
+			api.completeJob(job)
+		}
+	}
+}
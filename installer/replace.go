@@ -0,0 +1,122 @@
+package installer
+
+import "fmt"
+
+// ReplaceInstance replaces the instance with the given EC2 instance ID with
+// a new one with the same configuration, leaving the rest of the cluster
+// untouched. The instance is drained by stopping flynn-host so its jobs are
+// rescheduled, then the stack is updated to launch the replacement and
+// terminate the old instance, and finally the replacement is waited for to
+// join the cluster.
+//
+// The replaced instance is a consensus (etcd) member, so ErrQuorumViolation
+// is returned unless enough of the other members are healthy to keep a
+// quorum while it is gone. ErrClusterBusy is returned if the cluster is
+// already being changed.
+func (api *httpAPI) ReplaceInstance(clusterID, instanceID string) error {
+	inst, err := api.savedInstaller(clusterID)
+	if err != nil {
+		return err
+	}
+	s := inst.Stack
+	if err := s.beginOperation(); err != nil {
+		return err
+	}
+	defer s.endOperation()
+	if state := s.currentState(); state != StateRunning {
+		return fmt.Errorf("Cannot replace an instance of a cluster which is %s", state)
+	}
+
+	// the install has finished so forward stack events while replacing
+	defer inst.pumpEvents()()
+
+	return s.replaceInstance(instanceID)
+}
+
+func (s *Stack) replaceInstance(instanceID string) error {
+	index, err := s.findInstance(instanceID)
+	if err != nil {
+		return err
+	}
+	sshConfig, err := s.sshConfig()
+	if err != nil {
+		return err
+	}
+	name := instanceName(index)
+	ip, err := s.instanceIP(index)
+	if err != nil {
+		return err
+	}
+
+	members, err := s.consensusSize()
+	if err != nil {
+		return err
+	}
+	healthy := 0
+	for _, other := range s.InstanceIPs {
+		if other != ip && probeInstance(sshConfig, other) == nil {
+			healthy++
+		}
+	}
+	if healthy < members/2+1 {
+		return ErrQuorumViolation
+	}
+
+	metadata := map[string]string{"instance": name, "instance_id": instanceID, "ip": ip}
+	s.sendTypedEvent("instance_replacing", fmt.Sprintf("Replacing instance %s (%s)", name, ip), metadata)
+	if err := sshRun(sshConfig, ip, "sudo stop flynn-host"); err != nil {
+		s.SendEvent(fmt.Sprintf("WARNING: Unable to stop flynn-host on %s: %s", name, err))
+	}
+
+	if s.InstanceGenerations == nil {
+		s.InstanceGenerations = make(map[int]int)
+	}
+	s.InstanceGenerations[index]++
+	if err := s.updateStack(); err != nil {
+		s.InstanceGenerations[index]--
+		return err
+	}
+	if err := s.fetchStackOutputs(); err != nil {
+		return err
+	}
+	s.persist()
+	if err := s.ensureDNSRecords(); err != nil {
+		return err
+	}
+
+	newIP, err := s.instanceIP(index)
+	if err != nil {
+		return err
+	}
+	if err := instanceProbeAttempts.Run(func() error {
+		return probeInstance(sshConfig, newIP)
+	}); err != nil {
+		return fmt.Errorf("Replacement for instance %s (%s) failed to become ready: %s", name, newIP, err)
+	}
+	metadata["new_ip"] = newIP
+	s.sendTypedEvent("instance_replaced", fmt.Sprintf("Replaced instance %s (%s), the replacement is %s", name, ip, newIP), metadata)
+	return nil
+}
+
+// findInstance returns the index of the stack instance with the given EC2
+// instance ID.
+func (s *Stack) findInstance(instanceID string) (int, error) {
+	instances, err := s.stackInstances()
+	if err != nil {
+		return 0, err
+	}
+	for _, i := range instances {
+		if i.InstanceID == nil || *i.InstanceID != instanceID {
+			continue
+		}
+		for _, t := range i.Tags {
+			if t.Key == nil || *t.Key != "aws:cloudformation:logical-id" || t.Value == nil {
+				continue
+			}
+			if n := s.instanceIndex(*t.Value); n >= 0 && n < len(s.InstanceIPs) {
+				return n, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("Instance %s is not part of stack %s", instanceID, s.StackName)
+}
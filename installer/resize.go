@@ -0,0 +1,221 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/aws"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/cloudformation"
+	"github.com/flynn/flynn/pkg/etcdcluster"
+)
+
+var ErrQuorumViolation = errors.New("installer: removing instances would leave the cluster without a consensus quorum")
+
+// stackParameters lists the parameters of the stack template, all of which
+// keep their values when the stack is updated.
+var stackParameters = []string{"ImageId", "ClusterDomain", "KeyName", "UserData", "InstanceType", "VpcCidrBlock", "SubnetCidrBlock", "VolumeSize"}
+
+// ResizeCluster changes the number of instances in the cluster with the given
+// ID, which must be running, one instance at a time. When growing, each new
+// instance is launched by updating the stack and waited for to join the
+// cluster. New instances join the consensus (etcd) cluster through the
+// discovery token it was formed with, whose size can't be changed once the
+// cluster has formed, so those beyond its size join as proxies rather than
+// members. When shrinking, instances are removed newest first, waiting for
+// the remaining instances to be healthy after each removal. The instances
+// stay members of the consensus cluster, so ErrQuorumViolation is returned
+// if fewer than a majority of those members would remain.
+//
+// ErrClusterBusy is returned if the cluster is already being changed.
+func (api *httpAPI) ResizeCluster(id string, newCount int) error {
+	inst, err := api.savedInstaller(id)
+	if err != nil {
+		return err
+	}
+	s := inst.Stack
+	if err := s.beginOperation(); err != nil {
+		return err
+	}
+	defer s.endOperation()
+	if state := s.currentState(); state != StateRunning {
+		return fmt.Errorf("Cannot resize a cluster which is %s", state)
+	}
+	if err := validateNumInstances(newCount); err != nil {
+		return err
+	}
+	if newCount == s.NumInstances {
+		return fmt.Errorf("Cluster %s already has %d instances", id, newCount)
+	}
+	members, err := s.consensusSize()
+	if err != nil {
+		return err
+	}
+	if newCount < members/2+1 {
+		return ErrQuorumViolation
+	}
+
+	// the install has finished so forward stack events while resizing
+	defer inst.pumpEvents()()
+
+	for s.NumInstances < newCount {
+		if err := s.addInstance(); err != nil {
+			return err
+		}
+	}
+	for s.NumInstances > newCount {
+		if s.NumInstances-1 < members/2+1 {
+			return ErrQuorumViolation
+		}
+		if err := s.removeInstance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// consensusSize returns the number of members of the etcd cluster, the size
+// the discovery token was created for if there is one.
+func (s *Stack) consensusSize() (int, error) {
+	if s.DiscoveryToken == "" {
+		return s.NumInstances, nil
+	}
+	size, err := etcdcluster.DiscoveryTokenSize(s.DiscoveryToken)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to check discovery token: %s", err)
+	}
+	if size <= 0 {
+		return s.NumInstances, nil
+	}
+	return size, nil
+}
+
+// addInstance adds an instance to the stack and waits for it to join the
+// cluster.
+func (s *Stack) addInstance() error {
+	name := instanceName(s.NumInstances)
+	metadata := map[string]string{"instance": name}
+	s.sendTypedEvent("node_joining", fmt.Sprintf("Adding instance %s", name), metadata)
+	sshConfig, err := s.sshConfig()
+	if err != nil {
+		return err
+	}
+
+	s.NumInstances++
+	if err := s.updateStack(); err != nil {
+		s.NumInstances--
+		return err
+	}
+	if err := s.fetchStackOutputs(); err != nil {
+		return err
+	}
+	s.persist()
+	if err := s.ensureDNSRecords(); err != nil {
+		return err
+	}
+
+	ip, err := s.instanceIP(s.NumInstances - 1)
+	if err != nil {
+		return err
+	}
+	metadata["ip"] = ip
+	if err := instanceProbeAttempts.Run(func() error {
+		return probeInstance(sshConfig, ip)
+	}); err != nil {
+		return fmt.Errorf("New instance %s (%s) failed to become ready: %s", name, ip, err)
+	}
+	s.sendTypedEvent("node_added", fmt.Sprintf("Added instance %s (%s)", name, ip), metadata)
+	return nil
+}
+
+// removeInstance stops flynn-host on the newest instance so its jobs are
+// rescheduled, removes it from the stack and waits for the remaining
+// instances to be healthy.
+func (s *Stack) removeInstance() error {
+	name := instanceName(s.NumInstances - 1)
+	ip, err := s.instanceIP(s.NumInstances - 1)
+	if err != nil {
+		return err
+	}
+	metadata := map[string]string{"instance": name, "ip": ip}
+
+	s.sendTypedEvent("node_draining", fmt.Sprintf("Draining instance %s (%s)", name, ip), metadata)
+	sshConfig, err := s.sshConfig()
+	if err != nil {
+		return err
+	}
+	if err := sshRun(sshConfig, ip, "sudo stop flynn-host"); err != nil {
+		s.SendEvent(fmt.Sprintf("WARNING: Unable to stop flynn-host on %s: %s", name, err))
+	}
+	if err := s.deleteInstanceDNSRecord(s.NumInstances - 1); err != nil {
+		return err
+	}
+
+	s.NumInstances--
+	if err := s.updateStack(); err != nil {
+		s.NumInstances++
+		return err
+	}
+	if err := s.fetchStackOutputs(); err != nil {
+		return err
+	}
+	s.persist()
+	s.sendTypedEvent("node_removed", fmt.Sprintf("Removed instance %s (%s)", name, ip), metadata)
+
+	// wait for the cluster to stabilize before removing any more instances
+	for _, ip := range s.InstanceIPs {
+		if err := instanceProbeAttempts.Run(func() error {
+			return probeInstance(sshConfig, ip)
+		}); err != nil {
+			return fmt.Errorf("Instance %s is unhealthy after removing %s: %s", ip, name, err)
+		}
+	}
+	return nil
+}
+
+// updateStack updates the stack to the template for the current spec,
+// keeping the values of its parameters, and waits for the update to finish.
+func (s *Stack) updateStack() error {
+	template, err := s.stackTemplateBody()
+	if err != nil {
+		return err
+	}
+	params := make([]cloudformation.Parameter, len(stackParameters))
+	for i, p := range stackParameters {
+		params[i] = cloudformation.Parameter{ParameterKey: aws.String(p), UsePreviousValue: aws.Boolean(true)}
+	}
+	updateSince := time.Now()
+	if _, err := s.cf.UpdateStack(&cloudformation.UpdateStackInput{
+		StackName:    aws.String(s.StackName),
+		TemplateBody: aws.String(template),
+		Parameters:   params,
+	}); err != nil {
+		return err
+	}
+	if err := s.waitForStackCompletion("UPDATE", updateSince); err != nil {
+		return err
+	}
+	if err := s.fetchStack(); err != nil {
+		return err
+	}
+	if status := *s.Stack.StackStatus; status != "UPDATE_COMPLETE" {
+		return fmt.Errorf("Failed to update stack %s: %s", s.StackName, status)
+	}
+	return nil
+}
+
+// instanceIP returns the IP address of the instance with the given index
+// from the stack outputs.
+func (s *Stack) instanceIP(i int) (string, error) {
+	if err := s.fetchStack(); err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("IPAddress%d", i)
+	for _, o := range s.Stack.Outputs {
+		if o.OutputKey != nil && *o.OutputKey == key && o.OutputValue != nil {
+			return strings.TrimSpace(*o.OutputValue), nil
+		}
+	}
+	return "", fmt.Errorf("No IP address found for %s", instanceName(i))
+}
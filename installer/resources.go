@@ -0,0 +1,145 @@
+package installer
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/golang.org/x/crypto/ssh"
+)
+
+// The minimum resources of a host for Flynn to bootstrap without the
+// bootstrap jobs being OOM killed. MemTotal of a 2GB host is slightly less
+// than 2048MB once the kernel has reserved its share.
+var (
+	MinHostMemoryMB = 1800
+	MinHostCPUs     = 1
+	MinHostDiskMB   = 10 * 1024
+)
+
+// hostResourcesCmd prints the number of CPUs, the total memory in MB and
+// the free disk space in MB of the filesystem of /var/lib/flynn (or / if it
+// doesn't exist yet), one per line.
+const hostResourcesCmd = `nproc; awk '/^MemTotal:/ { print int($2 / 1024) }' /proc/meminfo; { df -Pm /var/lib/flynn 2>/dev/null || df -Pm /; } | awk 'NR == 2 { print $4 }'`
+
+// ErrInsufficientResources is returned when hosts have less than the
+// minimum resources, Hosts maps the name of each of them to its shortfalls.
+type ErrInsufficientResources struct {
+	Hosts map[string][]string
+}
+
+func (e *ErrInsufficientResources) Error() string {
+	names := make([]string, 0, len(e.Hosts))
+	for name := range e.Hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hosts := make([]string, len(names))
+	for i, name := range names {
+		hosts[i] = fmt.Sprintf("%s (%s)", name, strings.Join(e.Hosts[name], ", "))
+	}
+	return "Insufficient resources on " + strings.Join(hosts, "; ")
+}
+
+type hostResources struct {
+	CPUs     int
+	MemoryMB int
+	DiskMB   int
+}
+
+func parseHostResources(out string) (*hostResources, error) {
+	fields := strings.Fields(out)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("unexpected output %q", out)
+	}
+	values := make([]int, len(fields))
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected output %q", out)
+		}
+		values[i] = n
+	}
+	return &hostResources{CPUs: values[0], MemoryMB: values[1], DiskMB: values[2]}, nil
+}
+
+// shortfalls describes each resource below the minimum.
+func (r *hostResources) shortfalls() []string {
+	var s []string
+	if r.MemoryMB < MinHostMemoryMB {
+		s = append(s, fmt.Sprintf("%dMB memory, %dMB required", r.MemoryMB, MinHostMemoryMB))
+	}
+	if r.CPUs < MinHostCPUs {
+		s = append(s, fmt.Sprintf("%d CPUs, %d required", r.CPUs, MinHostCPUs))
+	}
+	if r.DiskMB < MinHostDiskMB {
+		s = append(s, fmt.Sprintf("%dMB free disk, %dMB required", r.DiskMB, MinHostDiskMB))
+	}
+	return s
+}
+
+// checkResources checks that every instance has at least the minimum
+// memory, CPUs and disk before bootstrapping, sending a host_resources_ok or
+// host_resources_insufficient event for each.
+func (s *Stack) checkResources() error {
+	sshConfig, err := s.sshConfig()
+	if err != nil {
+		return err
+	}
+
+	s.SendEvent("Checking instance resources")
+	var wg sync.WaitGroup
+	var mtx sync.Mutex
+	insufficient := make(map[string][]string)
+	for i, ip := range s.InstanceIPs {
+		wg.Add(1)
+		go func(name, ip string) {
+			defer wg.Done()
+			metadata := map[string]string{"instance": name, "ip": ip}
+			var shortfalls []string
+			res, err := hostResourcesOf(sshConfig, ip)
+			if err != nil {
+				shortfalls = []string{fmt.Sprintf("unable to check resources: %s", err)}
+			} else {
+				metadata["cpus"] = strconv.Itoa(res.CPUs)
+				metadata["memory_mb"] = strconv.Itoa(res.MemoryMB)
+				metadata["disk_mb"] = strconv.Itoa(res.DiskMB)
+				shortfalls = res.shortfalls()
+			}
+			if len(shortfalls) > 0 {
+				s.sendTypedEvent("host_resources_insufficient", fmt.Sprintf("Instance %s (%s) has insufficient resources: %s", name, ip, strings.Join(shortfalls, ", ")), metadata)
+				mtx.Lock()
+				insufficient[name] = shortfalls
+				mtx.Unlock()
+				return
+			}
+			s.sendTypedEvent("host_resources_ok", fmt.Sprintf("Instance %s (%s) has %d CPUs, %dMB memory and %dMB free disk", name, ip, res.CPUs, res.MemoryMB, res.DiskMB), metadata)
+		}(instanceName(i), ip)
+	}
+	wg.Wait()
+
+	if len(insufficient) > 0 {
+		return &ErrInsufficientResources{Hosts: insufficient}
+	}
+	return nil
+}
+
+func hostResourcesOf(sshConfig *ssh.ClientConfig, ip string) (*hostResources, error) {
+	conn, err := ssh.Dial("tcp", ip+":22", sshConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	sess, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+	out, err := sess.Output(hostResourcesCmd)
+	if err != nil {
+		return nil, err
+	}
+	return parseHostResources(string(out))
+}
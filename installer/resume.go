@@ -0,0 +1,273 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/aws"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/cloudformation"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/ec2"
+)
+
+// ResumeClusters continues the installs which were interrupted by the
+// installer exiting, i.e. saved clusters which are still provisioning or
+// bootstrapping but aren't being installed by this process. An install whose
+// CloudFormation stack is still being created or is complete is re-attached
+// to, waiting for the stack and then running the rest of the install. Any
+// other install is moved to the error state with an error event explaining
+// why it couldn't be resumed.
+//
+// Resumed installs take a launch slot like new ones, so beyond
+// MaxConcurrentLaunches they wait for others to finish.
+func (api *httpAPI) ResumeClusters() error {
+	jobs, err := api.queue.Pending()
+	if err != nil {
+		return err
+	}
+	inputs := make(map[string]*jsonInput, len(jobs))
+	for _, job := range jobs {
+		if job.Type != JobLaunch {
+			continue
+		}
+		if input, err := decodeLaunchInput(job.Input); err == nil {
+			inputs[job.ClusterID] = input
+		}
+	}
+
+	clusters, err := api.ListClusters()
+	if err != nil {
+		return err
+	}
+	for _, c := range clusters {
+		if c.State != StateProvisioning && c.State != StateBootstrapping {
+			continue
+		}
+		api.InstallerStackMtx.RLock()
+		inst := api.InstallerStacks[c.ID]
+		api.InstallerStackMtx.RUnlock()
+		if inst != nil {
+			continue
+		}
+		if err := api.resumeCluster(c.ID, inputs[c.ID]); err != nil {
+			reason := fmt.Sprintf("Install was interrupted and can't be resumed: %s", err)
+			if err := api.failCluster(c.ID, reason); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resumeCluster starts the interrupted install of the saved cluster with the
+// given ID. input is that of the install's launch job, if it is still queued,
+// otherwise the cluster's stored or environment credentials are used.
+func (api *httpAPI) resumeCluster(id string, input *jsonInput) error {
+	s, err := loadCluster(id)
+	if err != nil {
+		return err
+	}
+	if s.StackID == "" {
+		return errors.New("its stack hadn't been created")
+	}
+	inst, err := api.newSavedInstaller(s, input)
+	if err != nil {
+		return err
+	}
+	s.launchSlots = api.launchSemaphore()
+	if err := s.resume(); err != nil {
+		return err
+	}
+
+	api.InstallerStackMtx.Lock()
+	defer api.InstallerStackMtx.Unlock()
+	api.InstallerStacks[id] = inst
+	go inst.handleEvents()
+	return nil
+}
+
+// newSavedInstaller returns an install of the saved stack s which carries on
+// from the events saved for it. input is that of the install's launch job,
+// if there is one, otherwise the stack's stored or environment credentials
+// are used.
+func (api *httpAPI) newSavedInstaller(s *Stack, input *jsonInput) (*httpInstaller, error) {
+	if input == nil {
+		input = &jsonInput{CredentialID: s.CredentialID}
+	}
+	creds, err := inputCredentials(input)
+	if err != nil {
+		return nil, err
+	}
+	logger, logBuffer, err := api.installLogger(s.ID, s.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	// new events are numbered on from the saved ones
+	events, err := loadEvents(s.ID)
+	if err != nil {
+		return nil, err
+	}
+	inst := &httpInstaller{
+		ID:            s.ID,
+		PromptOutChan: make(chan *httpPrompt),
+		PromptInChan:  make(chan *httpPrompt),
+		logger:        logger,
+		logBuffer:     logBuffer,
+		api:           api,
+		Stack:         s,
+		events:        events,
+	}
+	s.Creds = creds
+	s.Tracer = api.tracer
+	s.PromptInput = inst.PromptInput
+	s.YesNoPrompt = inst.YesNoPrompt
+	s.HasSubscribers = inst.HasSubscribers
+	s.ec2 = ec2.New(s.Creds, s.Region, nil)
+	s.cf = cloudformation.New(s.Creds, s.Region, nil)
+	return inst, nil
+}
+
+// savedInstaller returns the install of the cluster with the given ID. A
+// cluster which isn't in InstallerStacks, as one installed before the
+// installer was restarted, is loaded from disk and added to them so that it
+// can be operated on. ErrClusterNotFound is returned if the cluster doesn't
+// exist.
+func (api *httpAPI) savedInstaller(id string) (*httpInstaller, error) {
+	api.InstallerStackMtx.RLock()
+	inst := api.InstallerStacks[id]
+	api.InstallerStackMtx.RUnlock()
+	if inst != nil {
+		return inst, nil
+	}
+
+	s, err := loadCluster(id)
+	if os.IsNotExist(err) {
+		return nil, ErrClusterNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	inst, err = api.newSavedInstaller(s, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the install is over
+	s.EventChan = make(chan *Event)
+	s.ErrChan = make(chan error)
+	s.Done = make(chan struct{})
+	close(s.Done)
+
+	api.InstallerStackMtx.Lock()
+	defer api.InstallerStackMtx.Unlock()
+	if existing := api.InstallerStacks[id]; existing != nil {
+		return existing, nil
+	}
+	api.InstallerStacks[id] = inst
+	return inst, nil
+}
+
+// failCluster moves the saved cluster with the given ID to the error state,
+// sending an error event with the reason.
+func (api *httpAPI) failCluster(id, reason string) error {
+	s, err := loadCluster(id)
+	if err != nil {
+		return err
+	}
+	if err := s.setState(StateError); err != nil {
+		return err
+	}
+	s.ErrorReason = reason
+	s.persistMutex.Lock()
+	err = s.persistCluster()
+	s.persistMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return api.appendEvent(s, &httpEvent{Type: "error", Description: reason})
+}
+
+// resume continues the install of a stack loaded from disk once its
+// CloudFormation stack is complete, returning an error if the stack no
+// longer exists or has failed.
+func (s *Stack) resume() error {
+	if s.Timeout == 0 {
+		s.Timeout = DefaultTimeout
+	}
+	s.EventChan = make(chan *Event)
+	s.ErrChan = make(chan error)
+	s.Done = make(chan struct{})
+	s.resetCancel()
+
+	res, err := s.cf.DescribeStacks(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(s.StackID),
+	})
+	if err != nil {
+		return err
+	}
+	if len(res.Stacks) == 0 || res.Stacks[0].StackStatus == nil {
+		return fmt.Errorf("stack %s no longer exists", s.StackName)
+	}
+	stack := res.Stacks[0]
+	var attach func() error
+	switch status := *stack.StackStatus; status {
+	case "CREATE_COMPLETE", "UPDATE_COMPLETE":
+		attach = s.fetchStack
+	case "CREATE_IN_PROGRESS":
+		attach = func() error {
+			return s.waitForStackCompletion("CREATE", stack.CreationTime.Add(-time.Second))
+		}
+	case "UPDATE_IN_PROGRESS":
+		attach = func() error {
+			return s.waitForStackCompletion("UPDATE", stack.LastUpdatedTime.Add(-time.Second))
+		}
+	default:
+		return fmt.Errorf("stack %s is %s", s.StackName, status)
+	}
+
+	steps := []installStep{
+		// the instances were launched with the saved key pair, so it must
+		// be loaded rather than replaced
+		{"key_pair", s.loadSavedKeyPair},
+		{"stack", attach},
+	}
+	for i, step := range s.installSteps() {
+		if step.Name == "stack" {
+			steps = append(steps, s.installSteps()[i+1:]...)
+			break
+		}
+	}
+
+	from := s.State
+	go func() {
+		defer close(s.Done)
+		s.sendTypedEvent("cluster_resumed", fmt.Sprintf("Resuming install of stack %s which was interrupted while %s", s.StackName, from), map[string]string{
+			"state": from,
+		})
+		if !s.acquireLaunchSlot() {
+			s.setState(StateError)
+			s.persist()
+			return
+		}
+		defer s.releaseLaunchSlot()
+		s.runInstall(steps)
+	}()
+	return nil
+}
+
+// loadSavedKeyPair loads the key pair the stack's instances were launched
+// with, for a stack loaded from disk.
+func (s *Stack) loadSavedKeyPair() error {
+	if s.GeneratedSSHKey {
+		key, err := loadClusterSSHKey(s.ID)
+		if err != nil {
+			return err
+		}
+		s.SSHKey = key
+		return nil
+	}
+	keyPairName := "flynn"
+	if s.SSHKeyName != "" {
+		keyPairName = s.SSHKeyName
+	}
+	return s.loadKeyPair(keyPairName)
+}
@@ -0,0 +1,196 @@
+package installer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/aws"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/cloudformation"
+)
+
+// RetryFailedResources resumes a failed install with the given ID from the
+// step it failed at. The stack is always checked again: if it can still be
+// updated only the resources which failed are retried, otherwise it is
+// recreated. The install then continues as if it had been launched,
+// streaming events to the install's subscribers, and takes a launch slot like
+// a new one. ErrClusterBusy is returned if the cluster is being changed by
+// another operation.
+func (api *httpAPI) RetryFailedResources(id string) error {
+	inst, err := api.savedInstaller(id)
+	if err != nil {
+		return err
+	}
+	s := inst.Stack
+	if err := s.beginOperation(); err != nil {
+		return err
+	}
+	defer s.endOperation()
+	if state := s.currentState(); state != StateError {
+		return fmt.Errorf("Cannot retry a cluster which is %s", state)
+	}
+	// the failed install may still be sending its last events
+	<-s.doneChan()
+
+	if s.Timeout == 0 {
+		s.Timeout = DefaultTimeout
+	}
+	s.launchSlots = api.launchSemaphore()
+	steps := s.retrySteps()
+	done, err := s.restartInstall()
+	if err != nil {
+		return err
+	}
+	inst.installErr = nil
+	go inst.handleEvents()
+
+	go func() {
+		defer close(done)
+		if !s.acquireLaunchSlot() {
+			s.setState(StateError)
+			s.persist()
+			return
+		}
+		defer s.releaseLaunchSlot()
+		s.runInstall(steps)
+	}()
+	return nil
+}
+
+// retrySteps returns the steps of the install from the one it failed at. The
+// stack and its outputs are always checked, with retryStack in place of
+// createStack, as the stack may have changed since. The key pair of a stack
+// loaded from disk is loaded if the failed install got past creating it.
+func (s *Stack) retrySteps() []installStep {
+	steps := s.installSteps()
+	start := 0
+	for i, step := range steps {
+		if step.Name == s.FailedStep {
+			start = i
+			break
+		}
+	}
+	var retry []installStep
+	if start > 0 && s.SSHKey == nil {
+		retry = append(retry, installStep{"key_pair", s.loadSavedKeyPair})
+	}
+	for i, step := range steps {
+		switch {
+		case step.Name == "stack":
+			retry = append(retry, installStep{"stack", s.retryStack})
+		case step.Name == "stack_outputs" || i >= start:
+			retry = append(retry, step)
+		}
+	}
+	return retry
+}
+
+// restartInstall moves a failed stack back to provisioning to run its
+// install again, replacing the channels of the failed install. It returns
+// the new Done channel, which must be closed once the install has finished.
+func (s *Stack) restartInstall() (chan struct{}, error) {
+	// a cancellation of the failed install mustn't stop this one
+	s.resetCancel()
+	s.persistMutex.Lock()
+	defer s.persistMutex.Unlock()
+	if !validTransition(s.State, StateProvisioning) {
+		return nil, ErrInvalidTransition
+	}
+	s.State = StateProvisioning
+	s.ErrorReason = ""
+	s.EventChan = make(chan *Event)
+	s.ErrChan = make(chan error)
+	s.Done = make(chan struct{})
+	return s.Done, nil
+}
+
+// retryStack brings the stack back to a complete state. CloudFormation only
+// changes the resources which differ from the template when a stack is
+// updated, so a stack which was rolled back after a failed update is updated
+// in place. The vendored SDK has no ContinueUpdateRollback, so any other
+// failed stack is deleted and created again.
+func (s *Stack) retryStack() error {
+	status := ""
+	if s.StackID != "" {
+		res, err := s.cf.DescribeStacks(&cloudformation.DescribeStacksInput{
+			StackName: aws.String(s.StackID),
+		})
+		if err != nil {
+			return err
+		}
+		if len(res.Stacks) > 0 && res.Stacks[0].StackStatus != nil {
+			status = *res.Stacks[0].StackStatus
+		}
+	}
+
+	switch status {
+	case "CREATE_COMPLETE", "UPDATE_COMPLETE":
+		// the install failed after the stack was created
+		return s.fetchStack()
+	case "UPDATE_ROLLBACK_COMPLETE":
+		failed, err := s.failedResources()
+		if err != nil {
+			return err
+		}
+		s.sendTypedEvent("retrying_resources", fmt.Sprintf("Retrying failed resources: %s", strings.Join(failed, ", ")), map[string]string{
+			"resources": strings.Join(failed, ","),
+			"strategy":  "update",
+		})
+		err = s.updateStack()
+		if apiErr, ok := err.(aws.APIError); ok && strings.Contains(apiErr.Message, "No updates are to be performed") {
+			return s.fetchStack()
+		}
+		return err
+	}
+
+	s.sendTypedEvent("retrying_resources", fmt.Sprintf("Unable to retry individual resources of stack %s (%s), recreating the stack", s.StackName, status), map[string]string{
+		"strategy": "recreate",
+	})
+	if status != "" && !strings.HasPrefix(status, "DELETE") {
+		if err := s.deleteDNSRecords(); err != nil {
+			return err
+		}
+		s.SendEvent(fmt.Sprintf("Deleting stack %s", s.StackName))
+		if err := s.cf.DeleteStack(&cloudformation.DeleteStackInput{
+			StackName: aws.String(s.StackName),
+		}); err != nil {
+			return err
+		}
+	}
+	s.StackID = ""
+	s.StackName = ""
+	return s.createStack()
+}
+
+// failedResources returns the logical IDs of the resources which failed in
+// the stack's most recent update. They have been rolled back since, so they
+// are found from the stack events rather than the resources' status.
+func (s *Stack) failedResources() ([]string, error) {
+	res, err := s.cf.DescribeStackEvents(&cloudformation.DescribeStackEventsInput{
+		StackName: aws.String(s.StackID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	events := res.StackEvents
+	sort.Sort(sort.Reverse(StackEventSort(events)))
+	var failed []string
+	seen := make(map[string]struct{})
+	for _, e := range events {
+		if e.ResourceType == nil || e.ResourceStatus == nil || e.LogicalResourceID == nil {
+			continue
+		}
+		if *e.ResourceType == "AWS::CloudFormation::Stack" {
+			if *e.ResourceStatus == "UPDATE_IN_PROGRESS" {
+				break
+			}
+			continue
+		}
+		if _, ok := seen[*e.LogicalResourceID]; ok || !strings.HasSuffix(*e.ResourceStatus, "_FAILED") {
+			continue
+		}
+		seen[*e.LogicalResourceID] = struct{}{}
+		failed = append(failed, *e.LogicalResourceID)
+	}
+	return failed, nil
+}
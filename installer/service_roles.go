@@ -0,0 +1,79 @@
+package installer
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/aws"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/endpoints"
+)
+
+const (
+	// spotServiceLinkedRole is the role EC2 needs to launch spot instances,
+	// which doesn't exist in an account which has never used them.
+	spotServiceLinkedRole = "AWSServiceRoleForEC2Spot"
+	spotServiceName       = "spot.amazonaws.com"
+)
+
+var ErrMissingServiceLinkedRole = errors.New("installer: the " + spotServiceLinkedRole + " service-linked role needed to launch spot instances does not exist and could not be created, create it with `aws iam create-service-linked-role --aws-service-name " + spotServiceName + "` or use on-demand instances")
+
+type getRoleRequest struct {
+	RoleName aws.StringValue `query:"RoleName"`
+}
+
+type getRoleResponse struct {
+	XMLName xml.Name `xml:"GetRoleResponse"`
+}
+
+type createServiceLinkedRoleRequest struct {
+	AWSServiceName aws.StringValue `query:"AWSServiceName"`
+}
+
+type createServiceLinkedRoleResponse struct {
+	XMLName xml.Name `xml:"CreateServiceLinkedRoleResponse"`
+}
+
+func (s *Stack) iam() *aws.QueryClient {
+	endpoint, service, region := endpoints.Lookup("iam", s.Region)
+	return &aws.QueryClient{
+		Context: aws.Context{
+			Credentials: s.Creds,
+			Service:     service,
+			Region:      region,
+		},
+		Client:     http.DefaultClient,
+		Endpoint:   endpoint,
+		APIVersion: "2010-05-08",
+	}
+}
+
+// validateSpotServiceRole checks the service-linked role spot instances need
+// exists, creating it if it doesn't, as otherwise the spot requests of the
+// stack fail long after it has started being created. The role is only
+// checked if the credentials are allowed to, and a dry run only warns that
+// it would be created. If it can't be created on-demand instances are
+// launched instead when SpotFallback is set.
+func (s *Stack) validateSpotServiceRole() error {
+	client := s.iam()
+	err := client.Do("GetRole", "POST", "/", &getRoleRequest{RoleName: aws.String(spotServiceLinkedRole)}, &getRoleResponse{})
+	if err == nil {
+		return nil
+	}
+	if apiErr, ok := err.(aws.APIError); !ok || apiErr.Code != "NoSuchEntity" {
+		s.warn("Unable to check the %s service-linked role exists, launching spot instances fails without it: %s", spotServiceLinkedRole, err)
+		return nil
+	}
+	if s.DryRun {
+		s.warn("The %s service-linked role needed to launch spot instances does not exist and would be created", spotServiceLinkedRole)
+		return nil
+	}
+	if err := client.Do("CreateServiceLinkedRole", "POST", "/", &createServiceLinkedRoleRequest{AWSServiceName: aws.String(spotServiceName)}, &createServiceLinkedRoleResponse{}); err != nil {
+		if !s.SpotFallback {
+			return ErrMissingServiceLinkedRole
+		}
+		s.warn("The %s service-linked role needed to launch spot instances could not be created, launching on-demand instances instead: %s", spotServiceLinkedRole, err)
+		s.LaunchedOnDemand = true
+	}
+	return nil
+}
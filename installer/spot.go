@@ -0,0 +1,120 @@
+package installer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// spotUnavailableCodes are the EC2 error and spot request status codes of a
+// spot request which can't be fulfilled, because of a lack of capacity or
+// as SpotMaxPrice is too low.
+var spotUnavailableCodes = []string{
+	"InsufficientInstanceCapacity",
+	"SpotMaxPriceTooLow",
+	"capacity-not-available",
+	"capacity-oversubscribed",
+	"price-too-low",
+}
+
+// SpotRequestError is returned when the instances of an install using spot
+// instances can't be launched as the spot requests aren't fulfilled.
+type SpotRequestError struct {
+	InstanceType string
+	Region       string
+	Err          error
+}
+
+func (e *SpotRequestError) Error() string {
+	return fmt.Sprintf("Spot requests for %s instances in %s could not be fulfilled, retry later, with a higher SpotMaxPrice or with on-demand instances: %s", e.InstanceType, e.Region, e.Err)
+}
+
+func (s *Stack) validateSpot() error {
+	if !s.UseSpotInstances {
+		if s.SpotMaxPrice != "" || s.SpotFallback {
+			return fmt.Errorf("SpotMaxPrice and SpotFallback require UseSpotInstances")
+		}
+		return nil
+	}
+	if s.SpotMaxPrice != "" {
+		if price, err := strconv.ParseFloat(s.SpotMaxPrice, 64); err != nil || price <= 0 {
+			return fmt.Errorf("Invalid SpotMaxPrice %s, must be a price in USD per hour", s.SpotMaxPrice)
+		}
+	}
+	if s.NumInstances == 1 {
+		s.warn("The single spot instance of the cluster can be reclaimed by AWS at any time, taking the cluster down with it, use on-demand instances for production")
+	} else {
+		s.warn("Spot instances can be reclaimed by AWS at any time, the cluster goes down if more than half of them are at once")
+	}
+	return nil
+}
+
+// spotInstances returns whether the stack's instances are launched as spot
+// instances, which they aren't once the install has fallen back to
+// on-demand ones.
+func (s *Stack) spotInstances() bool {
+	return s.UseSpotInstances && !s.LaunchedOnDemand
+}
+
+// stackTimeoutReason is the reason CloudFormation gives for the resources
+// still being created when the stack times out after TimeoutInMinutes, as
+// it does when spot requests are left open.
+const stackTimeoutReason = "Resource creation cancelled"
+
+// spotUnavailable returns whether the stack failed to be created as the
+// spot requests of its instances couldn't be fulfilled, either failing or
+// being left open until the stack timed out.
+func spotUnavailable(err error) bool {
+	e, ok := err.(*StackRollbackError)
+	if !ok {
+		return false
+	}
+	if strings.Contains(e.Reason, stackTimeoutReason) {
+		return true
+	}
+	for _, code := range spotUnavailableCodes {
+		if strings.Contains(e.Reason, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleSpotFailure handles the stack failing to be created. If it failed
+// as the spot requests weren't fulfilled the stack is created again with
+// on-demand instances if SpotFallback is set, or a SpotRequestError is
+// returned. A spot request which is never fulfilled fails once the stack
+// times out.
+//
+// The instances of the failed stack may have joined the etcd cluster of
+// the discovery token before it was rolled back, so a new token is minted
+// for the on-demand instances.
+func (s *Stack) handleSpotFailure(err error) error {
+	if !s.spotInstances() || !spotUnavailable(err) {
+		return err
+	}
+	if !s.SpotFallback {
+		return &SpotRequestError{InstanceType: s.InstanceType, Region: s.Region, Err: err}
+	}
+	s.SendEvent(fmt.Sprintf("WARNING: Spot requests for %s instances could not be fulfilled, launching on-demand instances instead: %s", s.InstanceType, err))
+	s.LaunchedOnDemand = true
+	s.DiscoveryToken = ""
+	s.persist()
+	return s.createStack()
+}
+
+// spotProgress sends the spot_request_pending and spot_request_fulfilled
+// events of the instances of a stack using spot instances, given the
+// metadata of their instance_launching and instance_running events.
+func (s *Stack) spotProgress(status string, metadata map[string]string) {
+	if !s.spotInstances() {
+		return
+	}
+	name := metadata["resource"]
+	switch status {
+	case "CREATE_IN_PROGRESS":
+		s.sendTypedEvent("spot_request_pending", fmt.Sprintf("Waiting for the spot request of instance %s to be fulfilled", name), metadata)
+	case "CREATE_COMPLETE":
+		s.sendTypedEvent("spot_request_fulfilled", fmt.Sprintf("Spot request of instance %s fulfilled (%s)", name, metadata["instance_id"]), metadata)
+	}
+}
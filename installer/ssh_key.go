@@ -0,0 +1,190 @@
+package installer
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/aws"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/ec2"
+	"github.com/flynn/flynn/Godeps/_workspace/src/golang.org/x/crypto/ssh"
+	"github.com/flynn/flynn/pkg/sshkeygen"
+)
+
+var ErrNoSSHKey = errors.New("installer: no SSH key found for the cluster")
+
+// clusterKeyPairName is the name of the EC2 key pair generated for the
+// cluster with the given ID.
+func clusterKeyPairName(id string) string {
+	return "flynn-" + id
+}
+
+func clusterSSHKeyPath(id string) string {
+	return filepath.Join(clustersDir, filepath.Base(id)+".key")
+}
+
+// generateKeyPair generates a key pair for the cluster and imports it to EC2,
+// for when no SSHKeyName is given. The private key is kept alongside the
+// cluster and the key pair is deleted with it. A key pair generated by an
+// earlier attempt at the install is reused.
+func (s *Stack) generateKeyPair() error {
+	name := clusterKeyPairName(s.ID)
+	if key, err := loadClusterSSHKey(s.ID); err == nil {
+		s.SendEvent(fmt.Sprintf("Using generated key pair (%s)", name))
+		s.SSHKey = key
+		s.SSHKeyName = name
+		s.GeneratedSSHKey = true
+		return nil
+	}
+
+	s.SendEvent(fmt.Sprintf("Generating key pair (%s)", name))
+	key, err := sshkeygen.Generate()
+	if err != nil {
+		return err
+	}
+	if err := s.importKeyPair(name, key); err != nil {
+		return err
+	}
+	if err := saveClusterSSHKey(s.ID, key); err != nil {
+		return err
+	}
+	s.SSHKey = key
+	s.SSHKeyName = name
+	s.GeneratedSSHKey = true
+	return nil
+}
+
+// importKeyPair imports the public key to EC2 as the key pair with the given
+// name, replacing an existing key pair left behind by an earlier attempt at
+// the install, as the name is unique to the cluster.
+func (s *Stack) importKeyPair(name string, key *sshkeygen.SSHKey) error {
+	enc := base64.StdEncoding
+	publicKey := make([]byte, enc.EncodedLen(len(key.PublicKey)))
+	enc.Encode(publicKey, key.PublicKey)
+	importKey := func() error {
+		return s.retryAWS("ImportKeyPair", func() error {
+			_, err := s.ec2.ImportKeyPair(&ec2.ImportKeyPairRequest{
+				KeyName:           aws.String(name),
+				PublicKeyMaterial: publicKey,
+			})
+			return err
+		})
+	}
+	err := importKey()
+	if apiErr, ok := err.(aws.APIError); ok && apiErr.Code == "InvalidKeyPair.Duplicate" {
+		if err := s.deleteKeyPair(name); err != nil {
+			return err
+		}
+		err = importKey()
+	}
+	return err
+}
+
+func (s *Stack) deleteKeyPair(name string) error {
+	return s.retryAWS("DeleteKeyPair", func() error {
+		return s.ec2.DeleteKeyPair(&ec2.DeleteKeyPairRequest{KeyName: aws.String(name)})
+	})
+}
+
+// cleanupKeyPair deletes the key pair generated for the cluster, if there is
+// one and an EC2 client to delete it with.
+func (s *Stack) cleanupKeyPair() error {
+	if !s.GeneratedSSHKey || s.ec2 == nil {
+		return nil
+	}
+	s.SendEvent(fmt.Sprintf("Deleting key pair %s", s.SSHKeyName))
+	return s.deleteKeyPair(s.SSHKeyName)
+}
+
+// saveClusterSSHKey stores the PEM encoded private key generated for the
+// cluster, encrypted like the stored credentials if the CredentialsKeyEnv
+// passphrase is set.
+func saveClusterSSHKey(id string, key *sshkeygen.SSHKey) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key.PrivateKey)})
+	if passphrase := credentialsPassphrase(); passphrase != "" {
+		encrypted, err := encryptSecret(passphrase, string(data))
+		if err != nil {
+			return err
+		}
+		data = []byte(encrypted)
+	}
+	if err := os.MkdirAll(clustersDir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(clusterSSHKeyPath(id), data, 0600)
+}
+
+func loadClusterSSHKey(id string) (*sshkeygen.SSHKey, error) {
+	data, err := readClusterSSHKey(id)
+	if err != nil {
+		return nil, err
+	}
+	b, _ := pem.Decode(data)
+	if b == nil {
+		return nil, fmt.Errorf("Invalid SSH key for cluster %s", id)
+	}
+	privateKey, err := x509.ParsePKCS1PrivateKey(b.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &sshkeygen.SSHKey{
+		PublicKey:  bytes.TrimSpace(ssh.MarshalAuthorizedKey(publicKey)),
+		PrivateKey: privateKey,
+	}, nil
+}
+
+// readClusterSSHKey returns the decrypted PEM encoded private key generated
+// for the cluster.
+func readClusterSSHKey(id string) ([]byte, error) {
+	data, err := ioutil.ReadFile(clusterSSHKeyPath(id))
+	if err != nil {
+		return nil, err
+	}
+	if isEncryptedSecret(string(data)) {
+		decrypted, err := decryptSecret(credentialsPassphrase(), string(data))
+		if err != nil {
+			return nil, err
+		}
+		data = []byte(decrypted)
+	}
+	return data, nil
+}
+
+// ClusterSSHKey returns the PEM encoded private key of the key pair the
+// instances of the cluster with the given ID were launched with, whether it
+// was generated for the cluster or is a saved named key pair.
+func (api *httpAPI) ClusterSSHKey(id string) ([]byte, error) {
+	s, err := api.FindCluster(id)
+	if os.IsNotExist(err) {
+		return nil, ErrClusterNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if s.GeneratedSSHKey {
+		data, err := readClusterSSHKey(id)
+		if os.IsNotExist(err) {
+			return nil, ErrNoSSHKey
+		}
+		return data, err
+	}
+	if s.SSHKeyName == "" {
+		return nil, ErrNoSSHKey
+	}
+	key, err := loadSSHKey(s.SSHKeyName)
+	if os.IsNotExist(err) {
+		return nil, ErrNoSSHKey
+	} else if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key.PrivateKey)}), nil
+}
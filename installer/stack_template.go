@@ -47,6 +47,7 @@ var stackTemplate = template.Must(template.New("stack_template.json").Parse(`
   },
 
   "Resources": {
+    {{if not .SubnetID}}
     "VPC": {
       "Type": "AWS::EC2::VPC",
       "Properties": {
@@ -124,12 +125,13 @@ var stackTemplate = template.Must(template.New("stack_template.json").Parse(`
         "SubnetId": { "Ref": "Subnet" }
       }
     },
+    {{end}}
 
     "PublicSecurityGroup": {
       "Type": "AWS::EC2::SecurityGroup",
       "Properties": {
         "GroupDescription": "flynn public ports",
-        "VpcId": { "Ref": "VPC" },
+        "VpcId": {{if .VpcID}}"{{.VpcID}}"{{else}}{ "Ref": "VPC" }{{end}},
         "SecurityGroupIngress": [
           {
             "IpProtocol": "tcp",
@@ -183,19 +185,61 @@ var stackTemplate = template.Must(template.New("stack_template.json").Parse(`
       }
     },
 
-    {{range $i, $_ := .Instances}}
+    {{if .CreatePlacementGroup}}
+    "PlacementGroup": {
+      "Type": "AWS::EC2::PlacementGroup",
+      "Properties": {
+        "Strategy": "cluster"
+      }
+    },
+    {{end}}
+
+    {{if .SpotInstances}}
+    "SpotLaunchTemplate": {
+      "Type": "AWS::EC2::LaunchTemplate",
+      "Properties": {
+        "LaunchTemplateData": {
+          "InstanceMarketOptions": {
+            "MarketType": "spot",
+            "SpotOptions": {
+              {{if .SpotMaxPrice}}"MaxPrice": "{{.SpotMaxPrice}}",{{end}}
+              "SpotInstanceType": "one-time",
+              "InstanceInterruptionBehavior": "terminate"
+            }
+          }
+        }
+      }
+    },
+    {{end}}
+
+    {{if not .DNSZoneID}}
+    "DNSZone": {
+      "Type": "AWS::Route53::HostedZone",
+      "Properties": {
+        "Name": { "Ref": "ClusterDomain" }
+      }
+    }{{if .Instances}},{{end}}
+    {{end}}
 
-    "Instance{{$i}}": {
+    {{range $i, $instance := .Instances}}
+
+    "{{$instance.LogicalID}}": {
       "Type": "AWS::EC2::Instance",
       "Properties": {
         "ImageId": { "Ref": "ImageId" },
         "InstanceType": { "Ref": "InstanceType" },
-        "AvailabilityZone": { "Fn::GetAtt": ["Subnet", "AvailabilityZone"] },
+        {{if not $.SubnetID}}"AvailabilityZone": { "Fn::GetAtt": ["Subnet", "AvailabilityZone"] },{{end}}
         "KeyName": { "Ref": "KeyName" },
+        {{if $.SpotInstances}}"LaunchTemplate": {
+          "LaunchTemplateId": { "Ref": "SpotLaunchTemplate" },
+          "Version": { "Fn::GetAtt": ["SpotLaunchTemplate", "LatestVersionNumber"] }
+        },{{end}}
+        {{if $.CreatePlacementGroup}}"PlacementGroupName": { "Ref": "PlacementGroup" },{{else if $.PlacementGroup}}"PlacementGroupName": "{{$.PlacementGroup}}",{{end}}
         "BlockDeviceMappings": [
           {
             "DeviceName": "/dev/sda1",
             "Ebs": {
+              {{if $instance.SnapshotID}}"SnapshotId": "{{$instance.SnapshotID}}",{{else if $.EncryptVolumes}}"Encrypted": true,{{end}}
               "VolumeSize": { "Ref" : "VolumeSize" },
               "VolumeType": "gp2"
             }
@@ -205,10 +249,10 @@ var stackTemplate = template.Must(template.New("stack_template.json").Parse(`
           {
             "DeviceIndex": 0,
             "AssociatePublicIpAddress": true,
-            "SubnetId": { "Ref": "Subnet" },
+            "SubnetId": {{if $.SubnetID}}"{{$.SubnetID}}"{{else}}{ "Ref": "Subnet" }{{end}},
             "GroupSet": [
-              { "Ref": "PublicSecurityGroup" },
-              { "Fn::GetAtt": ["VPC", "DefaultSecurityGroup"] }
+              { "Ref": "PublicSecurityGroup" }{{if not $.SubnetID}},
+              { "Fn::GetAtt": ["VPC", "DefaultSecurityGroup"] }{{end}}
             ]
           }
         ],
@@ -216,7 +260,7 @@ var stackTemplate = template.Must(template.New("stack_template.json").Parse(`
         "Tags": [
           {
             "Key": "Name",
-            "Value": { "Ref": "AWS::StackName" }
+            "Value": "{{$instance.Name}}"
           }
         ]
       }
@@ -228,60 +272,26 @@ var stackTemplate = template.Must(template.New("stack_template.json").Parse(`
         "HealthCheckConfig": {
           "FullyQualifiedDomainName": { "Fn::Join": [".", ["controller", { "Ref": "ClusterDomain" }]] },
           "Type": "HTTP",
-          "IPAddress": { "Fn::GetAtt": ["Instance{{$i}}", "PublicIp"] },
+          "IPAddress": { "Fn::GetAtt": ["{{$instance.LogicalID}}", "PublicIp"] },
           "ResourcePath": "/ping"
         }
       }
-    },
+    }{{if not $instance.Last}},{{end}}
 
     {{end}}
-
-    "DNSRecords": {
-      "Type": "AWS::Route53::RecordSetGroup",
-      "Properties": {
-        "HostedZoneId": { "Ref": "DNSZone" },
-        "RecordSets": [
-          {{range $i, $_ := .Instances}}
-          {
-            "Name": { "Fn::Join": [".", [{ "Ref": "ClusterDomain" }, ""]] },
-            "SetIdentifier": "frontend{{$i}}",
-            "HealthCheckId": { "Ref": "Instance{{$i}}HealthCheck" },
-            "Weight": 10,
-            "Type": "A",
-            "ResourceRecords": [
-              { "Fn::GetAtt": ["Instance{{$i}}", "PublicIp"] }
-            ],
-            "TTL": "60"
-          },
-          {{end}}
-          {
-            "Name": { "Fn::Join": [".", ["*", { "Ref": "ClusterDomain" }, ""]] },
-            "Type": "CNAME",
-            "ResourceRecords": [
-              { "Fn::Join": [".", [{ "Ref": "ClusterDomain" }, ""]] }
-            ],
-            "TTL": "3600"
-          }
-        ]
-      }
-    },
-
-    "DNSZone": {
-      "Type": "AWS::Route53::HostedZone",
-      "Properties": {
-        "Name": { "Ref": "ClusterDomain" }
-      }
-    }
   },
 
   "Outputs": {
-    {{range $i, $_ := .Instances}}
+    {{range $i, $instance := .Instances}}
       "IPAddress{{$i}}": {
-        "Value": { "Fn::GetAtt": ["Instance{{$i}}", "PublicIp"] }
+        "Value": { "Fn::GetAtt": ["{{$instance.LogicalID}}", "PublicIp"] }
+      },
+      "HealthCheckID{{$i}}": {
+        "Value": { "Ref": "Instance{{$i}}HealthCheck" }
       },
     {{end}}
     "DNSZoneID": {
-      "Value": { "Ref": "DNSZone" }
+      "Value": {{if .DNSZoneID}}"{{.DNSZoneID}}"{{else}}{ "Ref": "DNSZone" }{{end}}
     }
   }
 }
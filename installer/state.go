@@ -0,0 +1,159 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	StateProvisioning  = "provisioning"
+	StateBootstrapping = "bootstrapping"
+	StateRunning       = "running"
+	StateDeleting      = "deleting"
+	StateDeleted       = "deleted"
+	StateError         = "error"
+	StateAborted       = "aborted"
+)
+
+var ErrInvalidTransition = errors.New("installer: invalid cluster state transition")
+
+// ErrClusterBusy is returned when an operation is started on a cluster which
+// another one is still changing.
+var ErrClusterBusy = errors.New("installer: another operation is in progress on the cluster")
+
+// stateTransitions maps each state to the states it may move to, the
+// empty state being that of a cluster which hasn't been launched yet. A
+// provisioning cluster moves straight to running when the install uses the
+// running cluster of a previous one.
+var stateTransitions = map[string][]string{
+	"":                 {StateProvisioning},
+	StateProvisioning:  {StateBootstrapping, StateRunning, StateError},
+	StateBootstrapping: {StateRunning, StateError},
+	StateRunning:       {StateDeleting},
+	StateDeleting:      {StateDeleted, StateError},
+	StateError:         {StateProvisioning, StateDeleting, StateAborted},
+	StateAborted:       {StateDeleting},
+	StateDeleted:       {},
+}
+
+func validTransition(from, to string) bool {
+	for _, s := range stateTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// currentState returns the state of the stack, which its install may be
+// changing.
+func (s *Stack) currentState() string {
+	s.persistMutex.Lock()
+	defer s.persistMutex.Unlock()
+	return s.State
+}
+
+// beginOperation marks the stack as being changed by an operation run once
+// its install is over, returning ErrClusterBusy if another one already is.
+// Operations change the stack and replace its EventChan, so only one runs
+// at a time. endOperation must be called once the operation has finished.
+func (s *Stack) beginOperation() error {
+	s.persistMutex.Lock()
+	defer s.persistMutex.Unlock()
+	if s.operating {
+		return ErrClusterBusy
+	}
+	s.operating = true
+	return nil
+}
+
+func (s *Stack) endOperation() {
+	s.persistMutex.Lock()
+	defer s.persistMutex.Unlock()
+	s.operating = false
+}
+
+// setState moves the stack to the given state, returning
+// ErrInvalidTransition if it can't be reached from the current one.
+func (s *Stack) setState(state string) error {
+	s.persistMutex.Lock()
+	defer s.persistMutex.Unlock()
+	if !validTransition(s.State, state) {
+		return ErrInvalidTransition
+	}
+	s.State = state
+	return nil
+}
+
+// SetState moves the cluster with the given ID to the given state, saves it
+// and sends a cluster_state event. It returns a descriptive error if the
+// cluster can't move to the state from its current one.
+func (api *httpAPI) SetState(id, state string) error {
+	api.InstallerStackMtx.RLock()
+	inst := api.InstallerStacks[id]
+	api.InstallerStackMtx.RUnlock()
+	var s *Stack
+	if inst != nil {
+		s = inst.Stack
+	} else {
+		var err error
+		s, err = api.FindCluster(id)
+		if os.IsNotExist(err) {
+			return ErrClusterNotFound
+		} else if err != nil {
+			return err
+		}
+	}
+
+	from := s.State
+	if err := s.setState(state); err == ErrInvalidTransition {
+		allowed := "none"
+		if len(stateTransitions[from]) > 0 {
+			allowed = strings.Join(stateTransitions[from], ", ")
+		}
+		return fmt.Errorf("Cannot move cluster %s from %q to %q, allowed states are: %s", id, from, state, allowed)
+	} else if err != nil {
+		return err
+	}
+
+	event := &httpEvent{
+		Type:        "cluster_state",
+		Description: fmt.Sprintf("Cluster %s moved from %q to %q", id, from, state),
+		Metadata:    map[string]string{"from": from, "to": state},
+	}
+	if inst != nil {
+		if err := s.persist(); err != nil {
+			return err
+		}
+		inst.sendEvent(event)
+		return nil
+	}
+
+	s.persistMutex.Lock()
+	err := s.persistCluster()
+	s.persistMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return api.appendEvent(s, event)
+}
+
+// appendEvent adds the event to those saved for the stack and sends it to
+// subscribers, for stacks not being installed by this process.
+func (api *httpAPI) appendEvent(s *Stack, event *httpEvent) error {
+	events, err := loadEvents(s.ID)
+	if err != nil {
+		return err
+	}
+	if len(events) > 0 {
+		event.ID = events[len(events)-1].ID + 1
+	}
+	event.Timestamp = s.now()
+	if err := persistEvent(s.ID, event); err != nil {
+		return err
+	}
+	api.publish(s.ID, event)
+	return nil
+}
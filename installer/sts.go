@@ -0,0 +1,112 @@
+package installer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/aws"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/endpoints"
+)
+
+const (
+	// assumeRoleSessionName identifies the installer in the CloudTrail logs
+	// of the assumed role.
+	assumeRoleSessionName = "flynn-installer"
+
+	assumeRoleDuration = time.Hour
+
+	// assumeRoleRefreshMargin is how long before they expire assumed role
+	// credentials are refreshed, so that requests aren't signed with
+	// credentials which expire in flight.
+	assumeRoleRefreshMargin = 5 * time.Minute
+)
+
+type assumeRoleRequest struct {
+	RoleARN         aws.StringValue  `query:"RoleArn"`
+	RoleSessionName aws.StringValue  `query:"RoleSessionName"`
+	ExternalID      aws.StringValue  `query:"ExternalId"`
+	DurationSeconds aws.IntegerValue `query:"DurationSeconds"`
+}
+
+type assumeRoleResponse struct {
+	XMLName     xml.Name `xml:"AssumeRoleResponse"`
+	Credentials struct {
+		AccessKeyID     string    `xml:"AccessKeyId"`
+		SecretAccessKey string    `xml:"SecretAccessKey"`
+		SessionToken    string    `xml:"SessionToken"`
+		Expiration      time.Time `xml:"Expiration"`
+	} `xml:"AssumeRoleResult>Credentials"`
+}
+
+// assumeRoleProvider is a provider of the temporary credentials of an IAM
+// role, which it assumes with the source credentials by calling STS
+// AssumeRole, and again each time the credentials are about to expire.
+type assumeRoleProvider struct {
+	roleARN    string
+	externalID string
+	client     *aws.QueryClient
+
+	mtx    sync.Mutex
+	creds  *aws.Credentials
+	expiry time.Time
+}
+
+func newAssumeRoleProvider(source aws.CredentialsProvider, roleARN, externalID string) *assumeRoleProvider {
+	endpoint, service, region := endpoints.Lookup("sts", "us-east-1")
+	return &assumeRoleProvider{
+		roleARN:    roleARN,
+		externalID: externalID,
+		client: &aws.QueryClient{
+			Context: aws.Context{
+				Credentials: source,
+				Service:     service,
+				Region:      region,
+			},
+			Client:     http.DefaultClient,
+			Endpoint:   endpoint,
+			APIVersion: "2011-06-15",
+		},
+	}
+}
+
+func (p *assumeRoleProvider) Credentials() (*aws.Credentials, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.creds != nil && time.Now().Add(assumeRoleRefreshMargin).Before(p.expiry) {
+		return p.creds, nil
+	}
+	req := &assumeRoleRequest{
+		RoleARN:         aws.String(p.roleARN),
+		RoleSessionName: aws.String(assumeRoleSessionName),
+		DurationSeconds: aws.Integer(int(assumeRoleDuration / time.Second)),
+	}
+	if p.externalID != "" {
+		req.ExternalID = aws.String(p.externalID)
+	}
+	res := &assumeRoleResponse{}
+	if err := p.client.Do("AssumeRole", "POST", "/", req, res); err != nil {
+		return nil, fmt.Errorf("Unable to assume role %s: %s", p.roleARN, err)
+	}
+	p.creds = &aws.Credentials{
+		AccessKeyID:     res.Credentials.AccessKeyID,
+		SecretAccessKey: res.Credentials.SecretAccessKey,
+		SecurityToken:   res.Credentials.SessionToken,
+	}
+	p.expiry = res.Credentials.Expiration
+	return p.creds, nil
+}
+
+// validateRoleARN checks that arn names an IAM role, e.g.
+// arn:aws:iam::123456789012:role/flynn-installer.
+func validateRoleARN(arn string) error {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "iam" || parts[4] == "" || !strings.HasPrefix(parts[5], "role/") {
+		return fmt.Errorf("Invalid role ARN %q", arn)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package installer
+
+// clusterSubscriptionBufferSize is the number of events buffered for each
+// ClusterSubscription before events for it are dropped.
+const clusterSubscriptionBufferSize = 1000
+
+// ClusterEvent is an event of the install with ClusterID.
+type ClusterEvent struct {
+	ClusterID string     `json:"cluster_id"`
+	Event     *httpEvent `json:"event"`
+}
+
+// ClusterSubscription delivers the live events of the install with
+// ClusterID, or of every install if it is empty, to EventChan. Events are
+// buffered so a slow subscriber never blocks an install, if the buffer fills
+// up events are dropped.
+type ClusterSubscription struct {
+	ClusterID string
+	EventChan chan *ClusterEvent
+
+	ch   chan *ClusterEvent
+	stop chan struct{}
+}
+
+func (sub *ClusterSubscription) run() {
+	for {
+		select {
+		case e := <-sub.ch:
+			select {
+			case sub.EventChan <- e:
+			case <-sub.stop:
+				return
+			}
+		case <-sub.stop:
+			return
+		}
+	}
+}
+
+// Subscribe sends the events of the install with the given ID to eventChan
+// until the subscription is passed to Unsubscribe. An empty clusterID
+// subscribes to the events of every install.
+func (api *httpAPI) Subscribe(clusterID string, eventChan chan *ClusterEvent) *ClusterSubscription {
+	sub := &ClusterSubscription{
+		ClusterID: clusterID,
+		EventChan: eventChan,
+		ch:        make(chan *ClusterEvent, clusterSubscriptionBufferSize),
+		stop:      make(chan struct{}),
+	}
+	go sub.run()
+
+	api.subscriptionsMtx.Lock()
+	defer api.subscriptionsMtx.Unlock()
+	api.subscriptions = append(api.subscriptions, sub)
+	return sub
+}
+
+func (api *httpAPI) Unsubscribe(sub *ClusterSubscription) {
+	api.subscriptionsMtx.Lock()
+	defer api.subscriptionsMtx.Unlock()
+	for i, s := range api.subscriptions {
+		if s == sub {
+			api.subscriptions = append(api.subscriptions[:i], api.subscriptions[i+1:]...)
+			close(sub.stop)
+			return
+		}
+	}
+}
+
+// publish queues the event for the subscriptions to its cluster. It doesn't
+// block, so it is called while holding the install's eventsMtx to keep each
+// cluster's events in order.
+func (api *httpAPI) publish(clusterID string, event *httpEvent) {
+	api.subscriptionsMtx.RLock()
+	defer api.subscriptionsMtx.RUnlock()
+	for _, sub := range api.subscriptions {
+		if sub.ClusterID != "" && sub.ClusterID != clusterID {
+			continue
+		}
+		select {
+		case sub.ch <- &ClusterEvent{ClusterID: clusterID, Event: event}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package installer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/aws"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/cloudformation"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/ec2"
+)
+
+// ClusterIDTag is the tag set to the cluster ID on every AWS resource the
+// installer creates for a cluster.
+const ClusterIDTag = "flynn-cluster-id"
+
+// AWS tag limits, one tag is reserved for ClusterIDTag.
+const (
+	maxTags         = 50
+	maxTagKeyLength = 128
+)
+
+func validateTags(tags map[string]string) error {
+	if len(tags) > maxTags-1 {
+		return fmt.Errorf("A maximum of %d tags may be specified", maxTags-1)
+	}
+	for k, v := range tags {
+		switch {
+		case k == "":
+			return fmt.Errorf("Tag keys must not be empty")
+		case len(k) > maxTagKeyLength:
+			return fmt.Errorf("Tag key %s is longer than %d characters", k, maxTagKeyLength)
+		case len(v) > maxTagValueLength:
+			return fmt.Errorf("Value of tag %s is longer than %d characters", k, maxTagValueLength)
+		case strings.HasPrefix(strings.ToLower(k), "aws:"):
+			return fmt.Errorf("Tag key %s uses the reserved aws: prefix", k)
+		case k == ClusterIDTag:
+			return fmt.Errorf("Tag key %s is reserved", k)
+		}
+	}
+	return nil
+}
+
+// resourceTags returns Tags along with ClusterIDTag.
+func (s *Stack) resourceTags() map[string]string {
+	tags := make(map[string]string, len(s.Tags)+1)
+	for k, v := range s.Tags {
+		tags[k] = v
+	}
+	tags[ClusterIDTag] = s.ID
+	return tags
+}
+
+func sortedTagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// stackTags returns the tags of the CloudFormation stack, which it applies
+// to each of the resources it creates.
+func (s *Stack) stackTags() []cloudformation.Tag {
+	tags := s.resourceTags()
+	res := make([]cloudformation.Tag, 0, len(tags))
+	for _, k := range sortedTagKeys(tags) {
+		res = append(res, cloudformation.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	return res
+}
+
+func (s *Stack) ec2Tags() []ec2.Tag {
+	tags := s.resourceTags()
+	res := make([]ec2.Tag, 0, len(tags))
+	for _, k := range sortedTagKeys(tags) {
+		res = append(res, ec2.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	return res
+}
+
+// tagVolumes applies the resource tags to the volumes of the stack's
+// instances, which CloudFormation doesn't propagate the stack tags to.
+func (s *Stack) tagVolumes() error {
+	instances, err := s.stackInstances()
+	if err != nil {
+		return err
+	}
+	var volumes []string
+	for _, i := range instances {
+		for _, m := range i.BlockDeviceMappings {
+			if m.EBS != nil && m.EBS.VolumeID != nil {
+				volumes = append(volumes, *m.EBS.VolumeID)
+			}
+		}
+	}
+	if len(volumes) == 0 {
+		return nil
+	}
+	s.SendEvent(fmt.Sprintf("Tagging volumes %s", strings.Join(volumes, ", ")))
+	return s.retryAWS("CreateTags", func() error {
+		return s.ec2.CreateTags(&ec2.CreateTagsRequest{Resources: volumes, Tags: s.ec2Tags()})
+	})
+}
+
+// strayInstances returns the IDs of instances tagged with the cluster's ID
+// which haven't been terminated, e.g. because the stack was only partially
+// deleted.
+func (s *Stack) strayInstances() ([]string, error) {
+	res, err := s.ec2.DescribeInstances(&ec2.DescribeInstancesRequest{
+		Filters: []ec2.Filter{
+			{Name: aws.String("tag:" + ClusterIDTag), Values: []string{s.ID}},
+			{Name: aws.String("instance-state-name"), Values: []string{"pending", "running", "stopping", "stopped"}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, r := range res.Reservations {
+		for _, i := range r.Instances {
+			if i.InstanceID != nil {
+				ids = append(ids, *i.InstanceID)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// terminateStrayInstances terminates any instances of the cluster which
+// remain once its stack has been deleted.
+func (s *Stack) terminateStrayInstances() error {
+	ids, err := s.strayInstances()
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	s.SendEvent(fmt.Sprintf("Terminating instances left behind by the stack: %s", strings.Join(ids, ", ")))
+	_, err = s.ec2.TerminateInstances(&ec2.TerminateInstancesRequest{InstanceIDs: ids})
+	return err
+}
@@ -0,0 +1,100 @@
+package installer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"unicode"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/aws"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/awslabs/aws-sdk-go/gen/cloudformation"
+)
+
+// terraformResourceTypes maps the CloudFormation resource types in the stack
+// template to the Terraform AWS provider types which import them by physical
+// ID. Routes, attachments and record sets have composite import IDs and are
+// left out.
+var terraformResourceTypes = map[string]string{
+	"AWS::EC2::VPC":             "aws_vpc",
+	"AWS::EC2::InternetGateway": "aws_internet_gateway",
+	"AWS::EC2::RouteTable":      "aws_route_table",
+	"AWS::EC2::Subnet":          "aws_subnet",
+	"AWS::EC2::SecurityGroup":   "aws_security_group",
+	"AWS::EC2::PlacementGroup":  "aws_placement_group",
+	"AWS::EC2::Instance":        "aws_instance",
+	"AWS::Route53::HealthCheck": "aws_route53_health_check",
+	"AWS::Route53::HostedZone":  "aws_route53_zone",
+}
+
+// ExportTerraformImports returns `terraform import` commands for the AWS
+// resources of the cluster with the given ID, for users moving the cluster's
+// infrastructure to Terraform. The resources are looked up from the
+// CloudFormation stack, so the install must be running in this process.
+func (api *httpAPI) ExportTerraformImports(id string) (string, error) {
+	s, err := api.FindCluster(id)
+	if os.IsNotExist(err) {
+		return "", ErrClusterNotFound
+	} else if err != nil {
+		return "", err
+	}
+	if s.StackID == "" {
+		return "", fmt.Errorf("Stack for cluster %s has not been created", id)
+	}
+	if s.cf == nil {
+		return "", errors.New("No CloudFormation client for the stack")
+	}
+	res, err := s.cf.DescribeStackResources(&cloudformation.DescribeStackResourcesInput{StackName: aws.String(s.StackID)})
+	if err != nil {
+		return "", err
+	}
+	return terraformImports(s.StackID, s.StackName, res.StackResources), nil
+}
+
+func terraformImports(stackID, stackName string, resources []cloudformation.StackResource) string {
+	var lines []string
+	for _, r := range resources {
+		if r.ResourceType == nil || r.LogicalResourceID == nil || r.PhysicalResourceID == nil {
+			continue
+		}
+		typ, ok := terraformResourceTypes[*r.ResourceType]
+		if !ok {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("terraform import %s.%s %s", typ, terraformName(*r.LogicalResourceID), *r.PhysicalResourceID))
+	}
+	sort.Strings(lines)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Resources of CloudFormation stack %s\n", stackName)
+	fmt.Fprintf(&buf, "terraform import aws_cloudformation_stack.%s %s\n", terraformName(stackName), stackID)
+	for _, l := range lines {
+		buf.WriteString(l)
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// terraformName converts a CloudFormation logical ID or stack name to a
+// Terraform resource name, e.g. PublicSecurityGroup to public_security_group
+// and DNSZone to dns_zone.
+func terraformName(s string) string {
+	runes := []rune(s)
+	var buf bytes.Buffer
+	for i, r := range runes {
+		switch {
+		case unicode.IsUpper(r):
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1])) {
+				buf.WriteByte('_')
+			}
+			buf.WriteRune(unicode.ToLower(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			buf.WriteRune(r)
+		default:
+			buf.WriteByte('_')
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,163 @@
+package installer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type installStep struct {
+	Name string
+	Run  func() error
+}
+
+// PhaseTiming records how long a single phase of an install took.
+type PhaseTiming struct {
+	Phase     string        `json:"phase"`
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// installRecord is a completed install as stored in the history file.
+type installRecord struct {
+	Provider     string         `json:"provider"`
+	Region       string         `json:"region"`
+	NumInstances int            `json:"num_instances"`
+	Timeline     []*PhaseTiming `json:"timeline"`
+}
+
+func (r *installRecord) total() time.Duration {
+	var d time.Duration
+	for _, t := range r.Timeline {
+		d += t.Duration
+	}
+	return d
+}
+
+// saveTimeline appends the timeline of a successful install to the history
+// file.
+func (s *Stack) saveTimeline() error {
+	s.persistMutex.Lock()
+	defer s.persistMutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(historyPath), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(historyPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return json.NewEncoder(file).Encode(&installRecord{
+		Provider:     "aws",
+		Region:       s.Region,
+		NumInstances: s.NumInstances,
+		Timeline:     s.Timeline,
+	})
+}
+
+// loadInstallHistory reads the records of the history file, one per line.
+// Lines which can't be decoded are skipped: a crash while appending a
+// record leaves a partially written line, which the next record is then
+// appended to.
+func loadInstallHistory() ([]*installRecord, error) {
+	file, err := os.Open(historyPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var records []*installRecord
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			record := &installRecord{}
+			if json.Unmarshal(line, record) == nil {
+				records = append(records, record)
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+type Percentiles struct {
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+}
+
+type DurationStats struct {
+	Provider string                  `json:"provider"`
+	Installs int                     `json:"installs"`
+	Total    Percentiles             `json:"total"`
+	Phases   map[string]*Percentiles `json:"phases"`
+}
+
+// InstallDurationStats computes install duration percentiles, in total and per
+// phase, from the timelines of previous installs with the given provider.
+func InstallDurationStats(provider string) (*DurationStats, error) {
+	records, err := loadInstallHistory()
+	if err != nil {
+		return nil, err
+	}
+	var totals []time.Duration
+	phases := make(map[string][]time.Duration)
+	for _, r := range records {
+		if r.Provider != provider {
+			continue
+		}
+		totals = append(totals, r.total())
+		for _, t := range r.Timeline {
+			phases[t.Phase] = append(phases[t.Phase], t.Duration)
+		}
+	}
+	if len(totals) == 0 {
+		return nil, fmt.Errorf("No recorded installs for provider %s", provider)
+	}
+	stats := &DurationStats{
+		Provider: provider,
+		Installs: len(totals),
+		Total:    *percentiles(totals),
+		Phases:   make(map[string]*Percentiles, len(phases)),
+	}
+	for phase, d := range phases {
+		stats.Phases[phase] = percentiles(d)
+	}
+	return stats, nil
+}
+
+type durationSort []time.Duration
+
+func (d durationSort) Len() int           { return len(d) }
+func (d durationSort) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d durationSort) Less(i, j int) bool { return d[i] < d[j] }
+
+func percentiles(d []time.Duration) *Percentiles {
+	sort.Sort(durationSort(d))
+	// nearest-rank method
+	rank := func(p int) time.Duration {
+		i := (p*len(d)+99)/100 - 1
+		if i < 0 {
+			i = 0
+		}
+		return d[i]
+	}
+	return &Percentiles{
+		P50: rank(50),
+		P90: rank(90),
+		P99: rank(99),
+	}
+}
@@ -0,0 +1,55 @@
+package installer
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// TopologyDOT renders the layout of the cluster with the given ID as a
+// Graphviz DOT graph.
+func (api *httpAPI) TopologyDOT(id string) (string, error) {
+	s, err := api.FindCluster(id)
+	if err != nil {
+		return "", err
+	}
+	return s.TopologyDOT(), nil
+}
+
+// TopologyDOT renders the persisted layout of the stack as a Graphviz DOT
+// graph, it doesn't require the cluster to be reachable.
+func (s *Stack) TopologyDOT() string {
+	var buf bytes.Buffer
+	name := s.StackName
+	if name == "" {
+		name = s.ID
+	}
+	fmt.Fprintf(&buf, "digraph %q {\n", name)
+	fmt.Fprintf(&buf, "  label=%q;\n", fmt.Sprintf("%s (%s)", name, s.Region))
+
+	domain := ""
+	if s.Domain != nil {
+		domain = s.Domain.Name
+	}
+	fmt.Fprintln(&buf, "  subgraph cluster_vpc {")
+	fmt.Fprintf(&buf, "    label=%q;\n", "VPC "+s.VpcCidr)
+	fmt.Fprintln(&buf, "    subgraph cluster_subnet {")
+	fmt.Fprintf(&buf, "      label=%q;\n", "Subnet "+s.SubnetCidr)
+	for i, ip := range s.InstanceIPs {
+		fmt.Fprintf(&buf, "      instance%d [shape=box label=%q];\n", i, fmt.Sprintf("Instance%d\n%s\n%s", i, s.InstanceType, ip))
+	}
+	fmt.Fprintln(&buf, "    }")
+	fmt.Fprintln(&buf, "  }")
+
+	if domain != "" {
+		fmt.Fprintf(&buf, "  dns [shape=ellipse label=%q];\n", fmt.Sprintf("DNS\n%s\nzone %s", domain, s.DNSZoneID))
+		fmt.Fprintf(&buf, "  router [shape=ellipse label=%q];\n", "router\n*."+domain)
+		fmt.Fprintln(&buf, "  dns -> router;")
+		for i := range s.InstanceIPs {
+			fmt.Fprintf(&buf, "  router -> instance%d;\n", i)
+		}
+		fmt.Fprintf(&buf, "  controller [shape=ellipse label=%q];\n", "controller\ncontroller."+domain)
+		fmt.Fprintln(&buf, "  router -> controller [style=dashed];")
+	}
+	fmt.Fprintln(&buf, "}")
+	return buf.String()
+}
@@ -0,0 +1,52 @@
+package installer
+
+// Tracer creates spans for the phases of an install, it is implemented by
+// adapters for tracing systems such as OpenTelemetry so the installer
+// doesn't depend on one.
+type Tracer interface {
+	// StartSpan starts a span, parent is nil for the root span of an
+	// install.
+	StartSpan(name string, parent Span, attrs map[string]interface{}) Span
+}
+
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) RecordError(error)                {}
+func (noopSpan) End()                             {}
+
+func (s *Stack) startSpan(name string, parent Span, attrs map[string]interface{}) Span {
+	if s.Tracer == nil {
+		return noopSpan{}
+	}
+	return s.Tracer.StartSpan(name, parent, attrs)
+}
+
+// startInstallSpan starts the root span of the install, including the
+// install metadata so it can be correlated with the caller's trace.
+func (s *Stack) startInstallSpan() Span {
+	attrs := map[string]interface{}{
+		"provider":      "aws",
+		"region":        s.Region,
+		"instance_type": s.InstanceType,
+		"num_instances": s.NumInstances,
+		"cluster_id":    s.ID,
+	}
+	for k, v := range s.Metadata {
+		attrs["metadata."+k] = v
+	}
+	return s.startSpan("install", nil, attrs)
+}
+
+// SetTracer sets the tracer used for installs started after it is called.
+func (api *httpAPI) SetTracer(t Tracer) {
+	api.InstallerStackMtx.Lock()
+	defer api.InstallerStackMtx.Unlock()
+	api.tracer = t
+}
@@ -0,0 +1,84 @@
+package installer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/flynn/flynn/pkg/attempt"
+)
+
+// webhookTimeout is how long each attempt at calling the NotifyURL may take,
+// and webhookAttempts how long it is retried for.
+var (
+	webhookTimeout  = 10 * time.Second
+	webhookAttempts = attempt.Strategy{
+		Total: 2 * time.Minute,
+		Delay: 10 * time.Second,
+	}
+)
+
+// webhookPayload is POSTed to the NotifyURL once the install has finished,
+// with the login details if it succeeded or the error if it failed.
+type webhookPayload struct {
+	ClusterID string     `json:"cluster_id"`
+	State     string     `json:"state"`
+	Login     *LoginInfo `json:"login,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	ErrorType string     `json:"error_type,omitempty"`
+}
+
+func (s *Stack) validateNotifyURL() error {
+	if s.NotifyURL == "" {
+		return nil
+	}
+	u, err := url.Parse(s.NotifyURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("Invalid NotifyURL %s, must be an http or https URL", s.NotifyURL)
+	}
+	if u.Scheme == "http" {
+		s.warn("NotifyURL %s is not https, the cluster's login details will be sent unencrypted", s.NotifyURL)
+	}
+	return nil
+}
+
+func (api *httpAPI) webhookHTTPClient() *http.Client {
+	if api != nil && api.webhookClient != nil {
+		return api.webhookClient
+	}
+	return &http.Client{Timeout: webhookTimeout}
+}
+
+// notify calls the NotifyURL with the outcome of the install, retrying
+// until webhookAttempts is exhausted. It is run in its own goroutine once
+// the install is done so a failing webhook never holds up or fails the
+// install.
+func (s *httpInstaller) notify() error {
+	payload := &webhookPayload{ClusterID: s.ID, State: s.Stack.State}
+	if s.installErr != nil {
+		payload.Error = s.installErr.Error()
+		payload.ErrorType = InstallErrorType(s.installErr)
+	} else if login, err := s.Stack.loginInfo(); err == nil {
+		payload.Login = login
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	client := s.api.webhookHTTPClient()
+	return webhookAttempts.Run(func() error {
+		res, err := client.Post(s.Stack.NotifyURL, "application/json", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode < 200 || res.StatusCode >= 300 {
+			return fmt.Errorf("unexpected status %d", res.StatusCode)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,254 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/julienschmidt/httprouter"
+	"github.com/flynn/flynn/Godeps/_workspace/src/golang.org/x/net/websocket"
+)
+
+// Event severities, in increasing order.
+const (
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+var severityLevels = map[string]int{
+	SeverityInfo:    0,
+	SeverityWarning: 1,
+	SeverityError:   2,
+}
+
+// errorEventTypes are the event types which report a failure.
+var errorEventTypes = map[string]bool{
+	"error":                       true,
+	"cluster_timeout":             true,
+	"instance_failed":             true,
+	"connectivity_failed":         true,
+	"host_resources_insufficient": true,
+	"dependency_unreachable":      true,
+}
+
+func eventSeverity(e *httpEvent) string {
+	switch {
+	case errorEventTypes[e.Type]:
+		return SeverityError
+	case e.Type == "validation_warning" || e.Type == "sink_lagging" || strings.HasPrefix(e.Description, "WARNING"):
+		return SeverityWarning
+	default:
+		return SeverityInfo
+	}
+}
+
+// wsClientMessage is a message from a WebSocket client. The actions are:
+//
+//	subscribe    stream the events of ClusterID, replaying those after the
+//	             event with ID Since (or all of them if Since is unset)
+//	unsubscribe  stop streaming the events of ClusterID
+//	replay       resubscribe to ClusterID from Since
+//	filter       only stream events of at least the given Severity
+//	pause        stop streaming events until resumed
+//	resume       stream the events missed while paused and continue
+//
+// Only the saved events of a cluster which isn't being operated on by this
+// process are replayed, as it has no live events.
+type wsClientMessage struct {
+	Action    string `json:"action"`
+	ClusterID string `json:"cluster_id,omitempty"`
+	Since     *int   `json:"since,omitempty"`
+	Severity  string `json:"severity,omitempty"`
+}
+
+// wsServerMessage is an event of a cluster, or an error in response to a
+// client message.
+type wsServerMessage struct {
+	ClusterID string     `json:"cluster_id,omitempty"`
+	Event     *httpEvent `json:"event,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// WebSocketHandler streams the events of any number of installs over a
+// WebSocket, controlled by wsClientMessages. Each event carries its ID so a
+// client which reconnects can resume from the last one it received.
+func (api *httpAPI) WebSocketHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	websocket.Server{
+		Handler:   api.serveWebSocket,
+		Handshake: checkWebSocketOrigin,
+	}.ServeHTTP(w, req)
+}
+
+// checkWebSocketOrigin only accepts connections from pages served by the
+// installer, as event streams contain credentials.
+func checkWebSocketOrigin(config *websocket.Config, req *http.Request) error {
+	origin, err := websocket.Origin(config, req)
+	if err != nil {
+		return err
+	}
+	if origin == nil || origin.Host != req.Host {
+		return errors.New("installer: websocket origin not allowed")
+	}
+	return nil
+}
+
+type wsSession struct {
+	api  *httpAPI
+	conn *websocket.Conn
+
+	sendMtx sync.Mutex
+
+	mtx      sync.Mutex
+	subs     map[string]*wsSubscription
+	severity int
+	paused   bool
+}
+
+type wsSubscription struct {
+	inst      *httpInstaller
+	eventChan chan *httpEvent
+	stop      chan struct{}
+	lastID    int
+}
+
+func (api *httpAPI) serveWebSocket(conn *websocket.Conn) {
+	sess := &wsSession{
+		api:  api,
+		conn: conn,
+		subs: make(map[string]*wsSubscription),
+	}
+	defer sess.close()
+	for {
+		var msg wsClientMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			return
+		}
+		if err := sess.handle(&msg); err != nil {
+			sess.send(&wsServerMessage{ClusterID: msg.ClusterID, Error: err.Error()})
+		}
+	}
+}
+
+func (sess *wsSession) handle(msg *wsClientMessage) error {
+	sess.mtx.Lock()
+	defer sess.mtx.Unlock()
+
+	since := -1
+	if msg.Since != nil {
+		since = *msg.Since
+	}
+	switch msg.Action {
+	case "subscribe", "replay":
+		sess.api.InstallerStackMtx.RLock()
+		inst := sess.api.InstallerStacks[msg.ClusterID]
+		sess.api.InstallerStackMtx.RUnlock()
+		if inst == nil {
+			return sess.replay(msg.ClusterID, since)
+		}
+		sess.unsubscribe(msg.ClusterID)
+		sub := &wsSubscription{inst: inst, lastID: since}
+		sess.subs[msg.ClusterID] = sub
+		if !sess.paused {
+			sess.start(msg.ClusterID, sub)
+		}
+	case "unsubscribe":
+		sess.unsubscribe(msg.ClusterID)
+		delete(sess.subs, msg.ClusterID)
+	case "filter":
+		level, ok := severityLevels[msg.Severity]
+		if !ok {
+			return fmt.Errorf("Unknown severity %q", msg.Severity)
+		}
+		sess.severity = level
+	case "pause":
+		sess.paused = true
+		for id := range sess.subs {
+			sess.unsubscribe(id)
+		}
+	case "resume":
+		if sess.paused {
+			sess.paused = false
+			for id, sub := range sess.subs {
+				sess.start(id, sub)
+			}
+		}
+	default:
+		return fmt.Errorf("Unknown action %q", msg.Action)
+	}
+	return nil
+}
+
+// replay sends the saved events after the one with the given ID of a
+// cluster which isn't running in this process, which has no live events to
+// subscribe to.
+func (sess *wsSession) replay(clusterID string, since int) error {
+	if _, err := sess.api.FindCluster(clusterID); err != nil {
+		return ErrClusterNotFound
+	}
+	events, err := loadEvents(clusterID)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		if event.ID > since && severityLevels[eventSeverity(event)] >= sess.severity {
+			sess.send(&wsServerMessage{ClusterID: clusterID, Event: event})
+		}
+	}
+	return nil
+}
+
+// start streams the events of the subscription after its last ID.
+func (sess *wsSession) start(clusterID string, sub *wsSubscription) {
+	sub.eventChan = make(chan *httpEvent)
+	sub.stop = make(chan struct{})
+	done := sub.inst.subscribeSince(sub.eventChan, sub.lastID)
+	go func(eventChan chan *httpEvent, stop chan struct{}) {
+		for {
+			select {
+			case event := <-eventChan:
+				sess.mtx.Lock()
+				if event.ID > sub.lastID {
+					sub.lastID = event.ID
+				}
+				send := severityLevels[eventSeverity(event)] >= sess.severity
+				sess.mtx.Unlock()
+				if send {
+					sess.send(&wsServerMessage{ClusterID: clusterID, Event: event})
+				}
+			case <-done:
+				return
+			case <-stop:
+				return
+			}
+		}
+	}(sub.eventChan, sub.stop)
+}
+
+// unsubscribe stops streaming the cluster's events, keeping its position
+// so it can be restarted.
+func (sess *wsSession) unsubscribe(clusterID string) {
+	sub, ok := sess.subs[clusterID]
+	if !ok || sub.eventChan == nil {
+		return
+	}
+	sub.inst.Unsubscribe(sub.eventChan)
+	close(sub.stop)
+	sub.eventChan = nil
+}
+
+func (sess *wsSession) send(msg *wsServerMessage) {
+	sess.sendMtx.Lock()
+	defer sess.sendMtx.Unlock()
+	websocket.JSON.Send(sess.conn, msg)
+}
+
+func (sess *wsSession) close() {
+	sess.mtx.Lock()
+	defer sess.mtx.Unlock()
+	for id := range sess.subs {
+		sess.unsubscribe(id)
+	}
+}
@@ -7,9 +7,15 @@ import (
 	"io/ioutil"
 	"net/http"
 	"path"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// discoveryClient makes the requests to the discovery service, which is
+// remote so may hang.
+var discoveryClient = &http.Client{Timeout: 30 * time.Second}
+
 type Client struct {
 	URLs []string
 }
@@ -99,7 +105,7 @@ func Discover(url string) ([]Member, error) {
 }
 
 func NewDiscoveryToken(size string) (string, error) {
-	res, err := http.Get("https://discovery.etcd.io/new?size=" + size)
+	res, err := discoveryClient.Get("https://discovery.etcd.io/new?size=" + size)
 	if err != nil {
 		return "", err
 	}
@@ -110,3 +116,25 @@ func NewDiscoveryToken(size string) (string, error) {
 	url, err := ioutil.ReadAll(res.Body)
 	return string(url), err
 }
+
+// DiscoveryTokenSize returns the cluster size the discovery token was
+// created for.
+func DiscoveryTokenSize(token string) (int, error) {
+	res, err := discoveryClient.Get(strings.TrimSuffix(token, "/") + "/_config/size")
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return 0, fmt.Errorf("error getting discovery token size, got status %d", res.StatusCode)
+	}
+	var data struct {
+		Node struct {
+			Value string `json:"value"`
+		} `json:"node"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(data.Node.Value)
+}
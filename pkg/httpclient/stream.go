@@ -45,7 +45,7 @@ func Stream(res *http.Response, outputCh interface{}) stream.Stream {
 			msg := reflect.New(msgType)
 			if err := dec.Decode(msg.Interface()); err != nil {
 				if err != io.EOF {
-					stream.Error = err
+					stream.SetError(err)
 				}
 				break
 			}
@@ -1,12 +1,14 @@
 package stream
 
+import "sync"
+
 /*
 	Initializer for a Basic Stream.
 
 	Suggested usage is to only return the 'Stream' interface from
 	your worker method (see also the package examples); however,
 	you'll need the stream.Basic type reference available in your
-	worker method so that it may modify the error status and
+	worker method so that it may set the error with SetError and
 	select on the stop chan.
 */
 func New() *Basic {
@@ -22,20 +24,56 @@ func New() *Basic {
 
 	- a channel that indicates stopping, which the producer side
 	of the stream should use in a select (see the package examples),
-	- an error field that the producer ride of the stream should
-	set in case of problems (just before closing the associated
-	data channel).
+	- an error that the producer side of the stream should set
+	with SetError in case of problems (just before closing the
+	associated data channel).
 */
 type Basic struct {
 	StopCh chan struct{}
-	Error  error
+
+	closeOnce sync.Once
+	mtx       sync.Mutex
+	closed    bool
+	err       error
 }
 
-func (s Basic) Close() error {
-	close(s.StopCh)
+// Close closes the stop chan, it is safe to call more than once and from
+// several goroutines.
+func (s *Basic) Close() error {
+	s.closeOnce.Do(func() {
+		s.mtx.Lock()
+		s.closed = true
+		s.mtx.Unlock()
+		close(s.StopCh)
+	})
 	return nil
 }
 
-func (s Basic) Err() error {
-	return s.Error
+// IsClosed reports whether Close has been called.
+func (s *Basic) IsClosed() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.closed
+}
+
+// SetError sets the error returned by Err. It must be called by the producer
+// before Close and before it closes the data channel, so that a consumer
+// which has drained the channel sees the error.
+func (s *Basic) SetError(err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.err = err
+}
+
+func (s *Basic) Err() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.err
+}
+
+// Wait blocks until the stream is closed and returns its error, it returns
+// immediately if the stream has already been closed.
+func (s *Basic) Wait() error {
+	<-s.StopCh
+	return s.Err()
 }
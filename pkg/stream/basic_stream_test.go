@@ -0,0 +1,64 @@
+package stream
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestBasicConcurrentClose(t *testing.T) {
+	s := New()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Close()
+		}()
+	}
+	wg.Wait()
+	if !s.IsClosed() {
+		t.Fatal("expected stream to be closed")
+	}
+	select {
+	case <-s.StopCh:
+	default:
+		t.Fatal("expected StopCh to be closed")
+	}
+}
+
+func TestBasicSetErrorRace(t *testing.T) {
+	s := New()
+	ch := make(chan int)
+	expected := errors.New("producer failed")
+	go func() {
+		defer close(ch)
+		for i := 0; i < 100; i++ {
+			ch <- i
+		}
+		s.SetError(expected)
+	}()
+	for range ch {
+		// poll Err while the producer is running
+		s.Err()
+	}
+	if err := s.Err(); err != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+}
+
+func TestBasicWait(t *testing.T) {
+	s := New()
+	expected := errors.New("producer failed")
+	go func() {
+		s.SetError(expected)
+		s.Close()
+	}()
+	if err := s.Wait(); err != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+	// the stream is already closed so this must not block
+	if err := s.Wait(); err != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+}
@@ -14,6 +14,7 @@ Usage:
   flynn-release amis <version> <ids>
   flynn-release version <version> <commit>
   flynn-release export <manifest> <dir>
+  flynn-release retag <image> <registry>...
 
 Options:
   -o --output=<dest>           output destination file ("-" for stdout) [default: -]
@@ -35,5 +36,7 @@ Options:
 		version(args)
 	case args.Bool["export"]:
 		export(args)
+	case args.Bool["retag"]:
+		retag(args)
 	}
 }
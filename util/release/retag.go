@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/docker/docker/pkg/parsers"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/flynn/go-docopt"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+func retag(args *docopt.Args) {
+	d, err := docker.NewClient("unix:///var/run/docker.sock")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var registries []string
+	for _, r := range args.All["<registry>"].([]string) {
+		registries = append(registries, strings.TrimSuffix(r, "/"))
+	}
+	tags, err := copyTags(d, args.String["<image>"], registries)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, t := range tags {
+		fmt.Println(t)
+	}
+}
+
+// copyTags applies every repo:tag reference of the image to the same
+// repository under each of the given registry hosts and namespaces,
+// returning the references created. The new repository names are validated
+// against the rules of their registry before any tags are created.
+func copyTags(d *docker.Client, name string, registries []string) ([]string, error) {
+	image, err := d.InspectImage(name)
+	if err != nil {
+		return nil, fmt.Errorf("error inspecting %q: %s", name, err)
+	}
+	images, err := d.ListImages(false)
+	if err != nil {
+		return nil, err
+	}
+	var refs []string
+	for _, i := range images {
+		if i.ID == image.ID {
+			refs = i.RepoTags
+			break
+		}
+	}
+
+	type newTag struct {
+		ref, repo, tag, host string
+	}
+	var newTags []newTag
+	for _, ref := range refs {
+		repo, tag := parsers.ParseRepositoryTag(ref)
+		if repo == "<none>" {
+			continue
+		}
+		for _, registry := range registries {
+			host, namespace := splitRegistry(registry)
+			newRepo := remoteName(repo)
+			if namespace != "" {
+				newRepo = namespace + "/" + newRepo
+			}
+			if err := validateRepoName(host, newRepo); err != nil {
+				return nil, fmt.Errorf("cannot tag %s for %s: %s", ref, registryName(host), err)
+			}
+			if host != "" {
+				newRepo = host + "/" + newRepo
+			}
+			newTags = append(newTags, newTag{ref, newRepo, tag, host})
+		}
+	}
+
+	var tags []string
+	for _, t := range newTags {
+		if err := d.TagImage(image.ID, docker.TagImageOptions{Repo: t.repo, Tag: t.tag, Force: true}); err != nil {
+			return tags, fmt.Errorf("error tagging %s as %s:%s: %s", t.ref, t.repo, t.tag, err)
+		}
+		tags = append(tags, fmt.Sprintf("%s -> %s:%s (%s)", t.ref, t.repo, t.tag, registryName(t.host)))
+	}
+	return tags, nil
+}
+
+// splitRegistry splits a destination into a registry host and namespace,
+// the host being empty for Docker Hub.
+func splitRegistry(registry string) (string, string) {
+	parts := strings.SplitN(registry, "/", 2)
+	if !isRegistryHost(parts[0]) {
+		return "", registry
+	}
+	host := parts[0]
+	if dockerHubHosts[host] {
+		host = ""
+	}
+	if len(parts) == 1 {
+		return host, ""
+	}
+	return host, parts[1]
+}
+
+var dockerHubHosts = map[string]bool{
+	"docker.io":            true,
+	"index.docker.io":      true,
+	"registry-1.docker.io": true,
+}
+
+func isRegistryHost(s string) bool {
+	return strings.ContainsAny(s, ".:") || s == "localhost"
+}
+
+func registryName(host string) string {
+	if host == "" {
+		return "Docker Hub"
+	}
+	return host
+}
+
+var (
+	hubNamespace  = regexp.MustCompile(`^[a-z0-9_-]{2,255}$`)
+	hubRepo       = regexp.MustCompile(`^[a-z0-9_.-]+$`)
+	pathComponent = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+)
+
+// validateRepoName checks the repository name is valid for the registry,
+// Docker Hub only allowing a namespace and a name whereas private registries
+// allow any number of path components.
+func validateRepoName(host, repo string) error {
+	parts := strings.Split(repo, "/")
+	if host != "" {
+		for _, p := range parts {
+			if !pathComponent.MatchString(p) {
+				return fmt.Errorf("invalid repository name %q, components must match %s", repo, pathComponent)
+			}
+		}
+		return nil
+	}
+	if len(parts) > 2 {
+		return fmt.Errorf("invalid repository name %q, Docker Hub repositories are <namespace>/<name>", repo)
+	}
+	if len(parts) == 2 {
+		ns := parts[0]
+		if !hubNamespace.MatchString(ns) || strings.HasPrefix(ns, "-") || strings.HasSuffix(ns, "-") || strings.Contains(ns, "--") {
+			return fmt.Errorf("invalid namespace %q, only [a-z0-9-_] are allowed", ns)
+		}
+	}
+	if !hubRepo.MatchString(parts[len(parts)-1]) {
+		return fmt.Errorf("invalid repository name %q, only [a-z0-9-_.] are allowed", repo)
+	}
+	return nil
+}
+
+// remoteName strips the registry host from a repository name.
+func remoteName(repo string) string {
+	parts := strings.SplitN(repo, "/", 2)
+	if len(parts) == 2 && isRegistryHost(parts[0]) {
+		return parts[1]
+	}
+	return repo
+}
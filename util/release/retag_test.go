@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	. "github.com/flynn/flynn/Godeps/_workspace/src/github.com/flynn/go-check"
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/fsouza/go-dockerclient"
+)
+
+// Hook gocheck up to the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type S struct{}
+
+var _ = Suite(S{})
+
+func (S) TestSplitRegistry(c *C) {
+	for _, t := range []struct {
+		registry, host, namespace string
+	}{
+		{"flynn", "", "flynn"},
+		{"docker.io", "", ""},
+		{"index.docker.io/flynn", "", "flynn"},
+		{"localhost", "localhost", ""},
+		{"localhost:5000/flynn", "localhost:5000", "flynn"},
+		{"quay.io/flynn/images", "quay.io", "flynn/images"},
+	} {
+		host, namespace := splitRegistry(t.registry)
+		c.Assert(host, Equals, t.host, Commentf("registry %s", t.registry))
+		c.Assert(namespace, Equals, t.namespace, Commentf("registry %s", t.registry))
+	}
+	c.Assert(remoteName("quay.io/flynn/router"), Equals, "flynn/router")
+	c.Assert(remoteName("flynn/router"), Equals, "flynn/router")
+}
+
+func (S) TestValidateRepoName(c *C) {
+	for _, repo := range []string{"router", "flynn/router", "flynn_ci/router.v2"} {
+		c.Assert(validateRepoName("", repo), IsNil, Commentf("repo %s", repo))
+	}
+	c.Assert(validateRepoName("", "a/b/c"), ErrorMatches, `invalid repository name "a/b/c", Docker Hub repositories are <namespace>/<name>`)
+	for _, ns := range []string{"f", "-flynn", "flynn-", "fl--ynn", "Flynn"} {
+		c.Assert(validateRepoName("", ns+"/router"), ErrorMatches, `invalid namespace ".*", only \[a-z0-9-_\] are allowed`, Commentf("namespace %s", ns))
+	}
+	c.Assert(validateRepoName("", "flynn/Router"), ErrorMatches, `invalid repository name "flynn/Router", only \[a-z0-9-_.\] are allowed`)
+
+	// private registries allow any number of path components
+	c.Assert(validateRepoName("quay.io", "flynn/images/router"), IsNil)
+	c.Assert(validateRepoName("quay.io", "flynn/router_"), ErrorMatches, `invalid repository name "flynn/router_", components must match .*`)
+	c.Assert(validateRepoName("quay.io", "flynn//router"), ErrorMatches, `invalid repository name "flynn//router", components must match .*`)
+}
+
+func (S) TestCopyTags(c *C) {
+	var tagged []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Method == "GET" && req.URL.Path == "/images/flynn/router/json":
+			w.Write([]byte(`{"Id": "abc"}`))
+		case req.Method == "GET" && req.URL.Path == "/images/json":
+			w.Write([]byte(`[{"Id": "def", "RepoTags": ["flynn/other:latest"]}, {"Id": "abc", "RepoTags": ["flynn/router:latest", "<none>:<none>"]}]`))
+		case req.Method == "POST" && req.URL.Path == "/images/abc/tag":
+			q := req.URL.Query()
+			tagged = append(tagged, q.Get("repo")+":"+q.Get("tag"))
+			w.WriteHeader(201)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer srv.Close()
+	d, err := docker.NewClient(srv.URL)
+	c.Assert(err, IsNil)
+
+	// the image is tagged for every registry
+	tags, err := copyTags(d, "flynn/router", []string{"quay.io/flynn/images", "localhost:5000"})
+	c.Assert(err, IsNil)
+	c.Assert(tags, DeepEquals, []string{
+		"flynn/router:latest -> quay.io/flynn/images/flynn/router:latest (quay.io)",
+		"flynn/router:latest -> localhost:5000/flynn/router:latest (localhost:5000)",
+	})
+	sort.Strings(tagged)
+	c.Assert(tagged, DeepEquals, []string{
+		"localhost:5000/flynn/router:latest",
+		"quay.io/flynn/images/flynn/router:latest",
+	})
+
+	// nothing is tagged if a name is invalid for any of the registries
+	tagged = nil
+	_, err = copyTags(d, "flynn/router", []string{"localhost:5000", "flynnci"})
+	c.Assert(err, ErrorMatches, `cannot tag flynn/router:latest for Docker Hub: invalid repository name "flynnci/flynn/router", .*`)
+	c.Assert(tagged, HasLen, 0)
+}
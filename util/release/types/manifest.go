@@ -58,8 +58,9 @@ func (m *EC2Manifest) Add(version string, image *EC2Image) {
 }
 
 type EC2Version struct {
-	Version string      `json:"version"`
-	Images  []*EC2Image `json:"images"`
+	Version  string      `json:"version"`
+	Images   []*EC2Image `json:"images"`
+	Features []string    `json:"features,omitempty"`
 }
 
 func (v *EC2Version) version() string {